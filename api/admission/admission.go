@@ -0,0 +1,222 @@
+// Copyright (c) 2022 Wireleap
+
+// Package admission sheds new tunnels while the relay is short of
+// resources, so that live tunnels are not degraded and clients fail over
+// to other relays.
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wireleap/relay/api/events"
+)
+
+// Shedding reasons
+const (
+	ReasonOpenFiles     = "open_files"
+	ReasonHeap          = "heap"
+	ReasonAcceptLatency = "accept_latency"
+	ReasonGoroutines    = "goroutines"
+)
+
+// Reasons lists the shedding reasons in order of precedence.
+var Reasons = []string{ReasonOpenFiles, ReasonHeap, ReasonAcceptLatency, ReasonGoroutines}
+
+// ErrOverloaded is wrapped by the errors of shed tunnels.
+var ErrOverloaded = errors.New("relay overloaded")
+
+// Options are the shedding thresholds, zero values disable them
+type Options struct {
+	// MaxOpenFiles is the share of the open files limit.
+	MaxOpenFiles float64
+	// MaxGoroutines is the number of goroutines.
+	MaxGoroutines int
+	// MaxHeap is the size in bytes of the allocated heap.
+	MaxHeap uint64
+	// MaxAcceptLatency is the average time from accepting a connection to
+	// serving it, over the last interval.
+	MaxAcceptLatency time.Duration
+	// Interval is the interval of the resource samples.
+	Interval time.Duration
+	// Events receives the shedding state changes, it can be nil.
+	Events *events.Bus
+}
+
+// Enabled returns whether any threshold is set.
+func (o Options) Enabled() bool {
+	return o.MaxOpenFiles > 0 || o.MaxGoroutines > 0 || o.MaxHeap > 0 || o.MaxAcceptLatency > 0
+}
+
+// Sample is a resource usage sample
+type Sample struct {
+	// OpenFiles is -1 where it cannot be counted.
+	OpenFiles     int           `json:"open_files"`
+	FileLimit     uint64        `json:"file_limit"`
+	Goroutines    int           `json:"goroutines"`
+	Heap          uint64        `json:"heap"`
+	AcceptLatency time.Duration `json:"accept_latency"`
+}
+
+// T is the admission control of new tunnels, a nil T admits every tunnel
+type T struct {
+	o Options
+
+	mu     sync.Mutex
+	last   Sample
+	reason string
+	// accept latencies of the current interval
+	latency time.Duration
+	accepts int
+
+	// shed tunnels, by index in Reasons
+	shed []uint64
+
+	done chan struct{}
+	once sync.Once
+}
+
+// New returns an admission control sampling resources every o.Interval.
+func New(o Options) *T {
+	a := &T{o: o, shed: make([]uint64, len(Reasons)), done: make(chan struct{})}
+	a.sample()
+	if o.Interval > 0 {
+		go a.run()
+	}
+	return a
+}
+
+// Admit returns an error wrapping ErrOverloaded if a new tunnel should be
+// rejected.
+func (a *T) Admit() error {
+	if a == nil {
+		return nil
+	}
+
+	// goroutines pile up faster than they can be sampled
+	if a.o.MaxGoroutines > 0 && runtime.NumGoroutine() >= a.o.MaxGoroutines {
+		return a.reject(ReasonGoroutines)
+	}
+
+	a.mu.Lock()
+	r := a.reason
+	a.mu.Unlock()
+
+	if r != "" {
+		return a.reject(r)
+	}
+	return nil
+}
+
+func (a *T) reject(reason string) error {
+	for i, r := range Reasons {
+		if r == reason {
+			atomic.AddUint64(&a.shed[i], 1)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrOverloaded, reason)
+}
+
+// Accepted records the time taken to serve an accepted connection.
+func (a *T) Accepted(d time.Duration) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.latency += d
+	a.accepts++
+	a.mu.Unlock()
+}
+
+// Samples the resources until closed
+func (a *T) run() {
+	t := time.NewTicker(a.o.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-t.C:
+			a.sample()
+		}
+	}
+}
+
+// Samples the resources and updates the shedding reason
+func (a *T) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	s := Sample{Goroutines: runtime.NumGoroutine(), Heap: ms.HeapAlloc}
+	if n, err := openFiles(); err == nil {
+		s.OpenFiles = n
+	} else {
+		s.OpenFiles = -1
+	}
+	if l, err := fileLimit(); err == nil {
+		s.FileLimit = l
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.accepts > 0 {
+		s.AcceptLatency = a.latency / time.Duration(a.accepts)
+	}
+	a.latency, a.accepts = 0, 0
+
+	var r string
+	switch o := a.o; {
+	case o.MaxOpenFiles > 0 && s.OpenFiles >= 0 && s.FileLimit > 0 &&
+		float64(s.OpenFiles) >= o.MaxOpenFiles*float64(s.FileLimit):
+		r = ReasonOpenFiles
+	case o.MaxHeap > 0 && s.Heap >= o.MaxHeap:
+		r = ReasonHeap
+	case o.MaxAcceptLatency > 0 && s.AcceptLatency >= o.MaxAcceptLatency:
+		r = ReasonAcceptLatency
+	case o.MaxGoroutines > 0 && s.Goroutines >= o.MaxGoroutines:
+		r = ReasonGoroutines
+	}
+
+	switch {
+	case r != "" && a.reason == "":
+		log.Printf("shedding new tunnels: %s threshold reached", r)
+		a.o.Events.Emit(events.SheddingStarted, "", map[string]string{"reason": r})
+	case r == "" && a.reason != "":
+		log.Printf("stopped shedding new tunnels")
+		a.o.Events.Emit(events.SheddingStopped, "", nil)
+	}
+	a.last, a.reason = s, r
+}
+
+// Stats are the last resource sample and the shed tunnels by reason,
+// counters are totals since the start
+type Stats struct {
+	Sample
+	Shedding string            `json:"shedding,omitempty"`
+	Shed     map[string]uint64 `json:"shed"`
+}
+
+// Stats returns the admission control statistics.
+func (a *T) Stats() (s Stats) {
+	a.mu.Lock()
+	s.Sample, s.Shedding = a.last, a.reason
+	a.mu.Unlock()
+
+	s.Shed = make(map[string]uint64, len(Reasons))
+	for i, r := range Reasons {
+		s.Shed[r] = atomic.LoadUint64(&a.shed[i])
+	}
+	return
+}
+
+// Close stops the resource samples.
+func (a *T) Close() {
+	a.once.Do(func() { close(a.done) })
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Wireleap
+
+package admission
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wireleap/relay/api/events"
+)
+
+func TestOpenFiles(t *testing.T) {
+	n, err := openFiles()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	f, err := ioutil.TempFile("", "wlrelay-admission.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if n2, err := openFiles(); err != nil {
+		t.Fatal(err)
+	} else if n2 != n+1 {
+		t.Fatalf("expected %d open files, got %d", n+1, n2)
+	}
+
+	if l, err := fileLimit(); err != nil || l == 0 {
+		t.Fatalf("unexpected open files limit %d: %v", l, err)
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	var a *T
+	if err := a.Admit(); err != nil {
+		t.Fatal("nil admission control should admit")
+	}
+
+	a = New(Options{})
+	if err := a.Admit(); err != nil {
+		t.Fatalf("unexpected shedding without thresholds: %s", err)
+	}
+
+	a = New(Options{MaxGoroutines: 1})
+	if err := a.Admit(); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected overload error, got %v", err)
+	}
+
+	a = New(Options{MaxHeap: 1})
+	a.Admit()
+	a.Admit()
+
+	s := a.Stats()
+	if s.Shedding != ReasonHeap || s.Shed[ReasonHeap] != 2 || s.Shed[ReasonGoroutines] != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestAcceptLatency(t *testing.T) {
+	ev := events.New(8)
+	a := New(Options{MaxAcceptLatency: time.Second, Events: ev})
+
+	a.Accepted(3 * time.Second)
+	a.Accepted(time.Second)
+	a.sample()
+
+	if s := a.Stats(); s.Shedding != ReasonAcceptLatency || s.AcceptLatency != 2*time.Second {
+		t.Fatalf("unexpected stats %+v", s)
+	} else if err := a.Admit(); !errors.Is(err, ErrOverloaded) {
+		t.Fatal("slow accepts should shed new tunnels")
+	}
+
+	// no connection accepted during the interval
+	a.sample()
+	if err := a.Admit(); err != nil {
+		t.Fatalf("shedding should stop: %s", err)
+	}
+
+	l := ev.Backlog(0)
+	if len(l) != 2 || l[0].Type != events.SheddingStarted || l[1].Type != events.SheddingStopped {
+		t.Fatalf("unexpected events %+v", l)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2022 Wireleap
+
+//go:build linux || darwin
+// +build linux darwin
+
+package admission
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the number of entries of a file descriptors directory
+func countDir(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	return len(names) - 1, nil
+}
+
+// Returns the soft RLIMIT_NOFILE limit
+func fileLimit() (uint64, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, err
+	}
+	return uint64(rlim.Cur), nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Wireleap
+
+// Package atomicfile replaces files atomically, so readers and restarts see
+// either the previous or the new content, never a partial write.
+package atomicfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/wireleap/common/cli/fsdir"
+)
+
+// WriteFile writes data to a temporary file next to path, syncs it and
+// renames it over path.
+func WriteFile(path string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return
+	} else if err = f.Chmod(perm); err != nil {
+		return
+	} else if err = f.Sync(); err != nil {
+		return
+	} else if err = f.Close(); err != nil {
+		return
+	} else if err = os.Rename(f.Name(), path); err != nil {
+		return
+	}
+
+	// Persist the rename, not supported by every filesystem
+	if d, errD := os.Open(dir); errD == nil {
+		d.Sync()
+		d.Close()
+	}
+	return
+}
+
+// Set marshals the x value into JSON and writes it atomically to the path
+// ps of fm.
+func Set(fm fsdir.T, x interface{}, ps ...string) error {
+	return set(fm, x, false, ps...)
+}
+
+// SetIndented marshals the x value into indented JSON and writes it
+// atomically to the path ps of fm.
+func SetIndented(fm fsdir.T, x interface{}, ps ...string) error {
+	return set(fm, x, true, ps...)
+}
+
+func set(fm fsdir.T, x interface{}, indent bool, ps ...string) (err error) {
+	var b []byte
+	if indent {
+		b, err = json.MarshalIndent(x, "", "    ")
+	} else {
+		b, err = json.Marshal(x)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not marshal %s: %w", fm.Path(ps...), err)
+	}
+	return WriteFile(fm.Path(ps...), b, 0644)
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2022 Wireleap
+
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wireleap/common/cli/fsdir"
+)
+
+func TestSet(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "atomictest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	fm := fsdir.T(tmpd)
+	if err = SetIndented(fm, map[string]int{"a": 1}, "sub", "x.json"); err != nil {
+		t.Fatal(err)
+	} else if err = Set(fm, map[string]int{"a": 2}, "sub", "x.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var x map[string]int
+	if err = fm.Get(&x, "sub", "x.json"); err != nil {
+		t.Fatal(err)
+	} else if x["a"] != 2 {
+		t.Fatalf("file should be replaced, got %v", x)
+	}
+
+	// No leftover temporary files
+	if fs, err := ioutil.ReadDir(filepath.Join(tmpd, "sub")); err != nil {
+		t.Fatal(err)
+	} else if len(fs) != 1 {
+		t.Fatalf("unexpected files %d", len(fs))
+	}
+
+	// Unmarshallable values leave the file untouched
+	if err = Set(fm, make(chan int), "sub", "x.json"); err == nil {
+		t.Fatal("should fail to marshal")
+	} else if err = fm.Get(&x, "sub", "x.json"); err != nil || x["a"] != 2 {
+		t.Fatal("file should be untouched")
+	}
+}
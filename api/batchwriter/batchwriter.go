@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Wireleap
+
+// Package batchwriter implements an io.Writer which batches the flushes of
+// the underlying writer, typically an h2 http.ResponseWriter, while keeping
+// the latency bounded.
+package batchwriter
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Chunks is the number of full chunks pending before a flush
+const Chunks = 4
+
+// T is an io.Writer flushing the underlying writer. Writes shorter than a
+// chunk mean the source is drained and are flushed at once, full chunks are
+// flushed once Chunks are pending or after the delay.
+type T struct {
+	w     io.Writer
+	f     http.Flusher
+	chunk int
+	delay time.Duration
+
+	mu      sync.Mutex
+	pending int
+	armed   bool
+	closed  bool
+	timer   *time.Timer
+}
+
+// New returns a writer batching the flushes of w. A zero delay flushes
+// after every write.
+func New(w io.Writer, chunk int, delay time.Duration) *T {
+	f, _ := w.(http.Flusher)
+	return &T{w: w, f: f, chunk: chunk, delay: delay}
+}
+
+// Write writes to the underlying writer and flushes it if needed
+func (t *T) Write(p []byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	n, err = t.w.Write(p)
+	if t.f == nil {
+		return
+	}
+
+	t.pending += n
+	switch {
+	case err != nil, t.delay <= 0, len(p) < t.chunk, t.pending >= Chunks*t.chunk:
+		t.flush()
+	case !t.armed:
+		// bound the latency of the batched chunks
+		if t.timer == nil {
+			t.timer = time.AfterFunc(t.delay, t.expire)
+		} else {
+			t.timer.Reset(t.delay)
+		}
+		t.armed = true
+	}
+	return
+}
+
+// Flushes the pending data, t.mu must be held
+func (t *T) flush() {
+	if t.pending > 0 {
+		t.f.Flush()
+		t.pending = 0
+	}
+}
+
+func (t *T) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.armed = false
+	if !t.closed {
+		t.flush()
+	}
+}
+
+// Close flushes the pending data, the underlying writer is not used anymore
+func (t *T) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+
+	t.flush()
+	t.closed = true
+	return nil
+}
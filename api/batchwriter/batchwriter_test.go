@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Wireleap
+
+package batchwriter
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flusher struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	flushed int
+}
+
+func (f *flusher) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *flusher) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushed = f.buf.Len()
+}
+
+func (f *flusher) Flushed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushed
+}
+
+func TestWriter(t *testing.T) {
+	f := &flusher{}
+	w := New(f, 8, 50*time.Millisecond)
+	chunk := make([]byte, 8)
+
+	// Short writes are flushed at once
+	w.Write([]byte("hi"))
+	if f.Flushed() != 2 {
+		t.Fatalf("short write should be flushed, flushed %d", f.Flushed())
+	}
+
+	// Full chunks are batched
+	w.Write(chunk)
+	w.Write(chunk)
+	if f.Flushed() != 2 {
+		t.Fatalf("full chunks should be batched, flushed %d", f.Flushed())
+	}
+
+	w.Write(chunk)
+	w.Write(chunk)
+	if f.Flushed() != 34 {
+		t.Fatalf("pending chunks should be flushed, flushed %d", f.Flushed())
+	}
+
+	// Latency is bounded
+	w.Write(chunk)
+	time.Sleep(200 * time.Millisecond)
+	if f.Flushed() != 42 {
+		t.Fatalf("pending chunk should be flushed after the delay, flushed %d", f.Flushed())
+	}
+
+	w.Write(chunk)
+	w.Close()
+	if f.Flushed() != 50 {
+		t.Fatalf("pending chunk should be flushed on close, flushed %d", f.Flushed())
+	} else if _, err := w.Write(chunk); err != io.ErrClosedPipe {
+		t.Fatalf("write after close should fail, got %v", err)
+	}
+}
+
+func TestNoDelay(t *testing.T) {
+	f := &flusher{}
+	w := New(f, 8, 0)
+
+	w.Write(make([]byte, 8))
+	if f.Flushed() != 8 {
+		t.Fatalf("every write should be flushed, flushed %d", f.Flushed())
+	}
+}
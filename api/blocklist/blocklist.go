@@ -0,0 +1,295 @@
+// Copyright (c) 2022 Wireleap
+
+// Package blocklist matches target hostnames against domain list files.
+//
+// Every line of a list is an entry, '#' starts a comment:
+//
+//	example.com      the domain only
+//	.example.com     the domain and its subdomains
+//	*.example.com    the subdomains only
+//
+// Hosts file lines such as "0.0.0.0 example.com" are read as the domain
+// only. Matching looks up the host and its parent domains, so it does not
+// slow down with the size of the lists.
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry kinds, a domain can have several
+const (
+	exact uint8 = 1 << iota
+	suffix
+	wildcard
+)
+
+// Domains of a list with their entry kinds
+type set map[string]uint8
+
+// Returns whether host is matched, host being lowercase
+func (s set) match(host string) bool {
+	if s[host]&(exact|suffix) != 0 {
+		return true
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if s[host]&(suffix|wildcard) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the domain and the kind of an entry, ok is false for blank lines
+func parseLine(line string) (domain string, kind uint8, ok bool, err error) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return
+	case len(fields) == 2 && net.ParseIP(fields[0]) != nil:
+		// hosts file format
+		domain = fields[1]
+	case len(fields) == 1:
+		domain = fields[0]
+	default:
+		return "", 0, false, fmt.Errorf("unexpected fields")
+	}
+
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	switch {
+	case strings.HasPrefix(domain, "*."):
+		domain, kind = domain[2:], wildcard
+	case strings.HasPrefix(domain, "."):
+		domain, kind = domain[1:], suffix
+	default:
+		kind = exact
+	}
+
+	if domain == "" {
+		return "", 0, false, fmt.Errorf("empty domain")
+	}
+	for _, c := range domain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", 0, false, fmt.Errorf("invalid character %q", c)
+		}
+	}
+	return domain, kind, true, nil
+}
+
+// Reads a list file, invalid lines are skipped and counted
+func load(path string) (s set, invalid int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s = set{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		d, k, ok, err := parseLine(sc.Text())
+		if err != nil {
+			invalid++
+		} else if ok {
+			s[d] |= k
+		}
+	}
+	return s, invalid, sc.Err()
+}
+
+// A domain list file
+type list struct {
+	name, path string
+	set        set
+	// file state when loaded
+	mtime time.Time
+	size  int64
+	// matched hosts
+	blocked uint64
+}
+
+// Loads the file of the list if it changed or if forced
+func (l *list) reload(force bool) (changed bool, err error) {
+	fi, err := os.Stat(l.path)
+	if err != nil {
+		return
+	}
+	if !force && fi.ModTime().Equal(l.mtime) && fi.Size() == l.size {
+		return
+	}
+
+	s, invalid, err := load(l.path)
+	if err != nil {
+		return
+	}
+	if invalid > 0 {
+		log.Printf("blocklist %s: skipped %d invalid lines", l.name, invalid)
+	}
+	l.set, l.mtime, l.size = s, fi.ModTime(), fi.Size()
+	return true, nil
+}
+
+// T is a set of domain lists, a nil T blocks nothing
+type T struct {
+	mu    sync.RWMutex
+	lists []*list
+
+	done chan struct{}
+	once sync.Once
+}
+
+// New returns the domain lists of files, by list name. The files are
+// checked for changes every interval if it is not 0.
+func New(files map[string]string, interval time.Duration) (*T, error) {
+	b := &T{done: make(chan struct{})}
+	if err := b.Set(files); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go b.run(interval)
+	}
+	return b, nil
+}
+
+// Set replaces the lists with files, by list name, and reloads them. The
+// lists which fail to load are kept as they were, and the first error is
+// returned.
+func (b *T) Set(files map[string]string) (err error) {
+	b.mu.RLock()
+	old := make(map[string]*list, len(b.lists))
+	for _, l := range b.lists {
+		old[l.name] = &list{set: l.set, mtime: l.mtime, size: l.size}
+	}
+	b.mu.RUnlock()
+
+	lists := make([]*list, 0, len(files))
+	for name, path := range files {
+		l := &list{name: name, path: path}
+		if o, ok := old[name]; ok {
+			l.set, l.mtime, l.size = o.set, o.mtime, o.size
+		}
+
+		if _, lerr := l.reload(true); lerr != nil {
+			if err == nil {
+				err = fmt.Errorf("could not load blocklist %s: %w", name, lerr)
+			}
+			if l.set == nil {
+				continue
+			}
+		}
+		lists = append(lists, l)
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].name < lists[j].name })
+
+	b.mu.Lock()
+	// blocks counted so far
+	for _, o := range b.lists {
+		for _, l := range lists {
+			if l.name == o.name {
+				l.blocked = atomic.LoadUint64(&o.blocked)
+			}
+		}
+	}
+	b.lists = lists
+	b.mu.Unlock()
+	return
+}
+
+// Reloads the changed files until closed
+func (b *T) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-t.C:
+			b.check()
+		}
+	}
+}
+
+// Reloads the lists whose file changed
+func (b *T) check() {
+	b.mu.RLock()
+	lists := append([]*list(nil), b.lists...)
+	b.mu.RUnlock()
+
+	for _, l := range lists {
+		// reloaded on a copy, matches go on meanwhile
+		b.mu.RLock()
+		c := &list{name: l.name, path: l.path, set: l.set, mtime: l.mtime, size: l.size}
+		b.mu.RUnlock()
+
+		if changed, err := c.reload(false); err != nil {
+			log.Printf("could not reload blocklist %s: %s", l.name, err)
+		} else if changed {
+			b.mu.Lock()
+			l.set, l.mtime, l.size = c.set, c.mtime, c.size
+			b.mu.Unlock()
+			log.Printf("reloaded blocklist %s", l.name)
+		}
+	}
+}
+
+// Match returns the name of the first list matching host, if any.
+func (b *T) Match(host string) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, l := range b.lists {
+		if l.set.match(host) {
+			atomic.AddUint64(&l.blocked, 1)
+			return l.name, true
+		}
+	}
+	return "", false
+}
+
+// ListStats are the statistics of a list, Blocked is a total since the
+// start
+type ListStats struct {
+	Name     string    `json:"name"`
+	Domains  int       `json:"domains"`
+	Blocked  uint64    `json:"blocked"`
+	Modified time.Time `json:"modified"`
+}
+
+// Stats returns the statistics of the lists, by name.
+func (b *T) Stats() (s []ListStats) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, l := range b.lists {
+		s = append(s, ListStats{Name: l.name, Domains: len(l.set), Blocked: atomic.LoadUint64(&l.blocked), Modified: l.mtime})
+	}
+	return
+}
+
+// Close stops the file checks.
+func (b *T) Close() {
+	b.once.Do(func() { close(b.done) })
+}
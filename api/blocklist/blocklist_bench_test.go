@@ -0,0 +1,32 @@
+// Copyright (c) 2022 Wireleap
+
+package blocklist
+
+import (
+	"strconv"
+	"testing"
+)
+
+// A list of n domains, a third of every kind
+func newSet(n int) set {
+	s := make(set, n)
+	for i := 0; i < n; i++ {
+		s["host"+strconv.Itoa(i)+".example.com"] = []uint8{exact, suffix, wildcard}[i%3]
+	}
+	return s
+}
+
+func BenchmarkMatch(b *testing.B) {
+	bl := &T{lists: []*list{{name: "bench", set: newSet(3000000)}}}
+	hosts := []string{
+		"host42.example.com",
+		"www.cdn.host43.example.com",
+		"not.listed.example.org",
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bl.Match(hosts[i%len(hosts)])
+	}
+}
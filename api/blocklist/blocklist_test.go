@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Wireleap
+
+package blocklist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a list file in dir
+func writeList(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseLine(t *testing.T) {
+	for line, exp := range map[string]struct {
+		domain string
+		kind   uint8
+	}{
+		"Example.COM.":                  {"example.com", exact},
+		".example.com # comment":        {"example.com", suffix},
+		"*.example.com":                 {"example.com", wildcard},
+		"0.0.0.0 ads.example.com":       {"ads.example.com", exact},
+		"::1\tmalware_host.example.org": {"malware_host.example.org", exact},
+	} {
+		d, k, ok, err := parseLine(line)
+		if err != nil || !ok || d != exp.domain || k != exp.kind {
+			t.Errorf("%q: unexpected entry %q %d %v %v", line, d, k, ok, err)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment"} {
+		if _, _, ok, err := parseLine(line); ok || err != nil {
+			t.Errorf("%q should be skipped", line)
+		}
+	}
+
+	for _, line := range []string{"*", "ads.*.example.com", "example.com/path", "a b c", "*."} {
+		if _, _, _, err := parseLine(line); err == nil {
+			t.Errorf("%q should be invalid", line)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(map[string]string{
+		"malware": writeList(t, dir, "malware.txt", "exact.example.com\n.suffix.example.com\n*.wildcard.example.com\ninvalid entry line\n"),
+		"c2":      writeList(t, dir, "c2.txt", "0.0.0.0 c2.example.org\n"),
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, exp := range map[string]string{
+		"exact.example.com":        "malware",
+		"EXACT.example.com.":       "malware",
+		"www.exact.example.com":    "",
+		"suffix.example.com":       "malware",
+		"a.b.suffix.example.com":   "malware",
+		"wildcard.example.com":     "",
+		"a.wildcard.example.com":   "malware",
+		"c2.example.org":           "c2",
+		"example.com":              "",
+		"notsuffix.example.com":    "",
+		"exact.example.com.evil.x": "",
+	} {
+		if l, ok := b.Match(host); l != exp || ok != (exp != "") {
+			t.Errorf("%s: expected list %q, got %q", host, exp, l)
+		}
+	}
+
+	s := b.Stats()
+	if len(s) != 2 || s[0].Name != "c2" || s[0].Blocked != 1 || s[1].Blocked != 5 || s[1].Domains != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	var nb *T
+	if _, ok := nb.Match("exact.example.com"); ok {
+		t.Fatal("nil blocklist should block nothing")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	p := writeList(t, dir, "list.txt", "one.example.com\n")
+
+	b, err := New(map[string]string{"list": p}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.Match("one.example.com")
+
+	// reloaded once the file changed
+	writeList(t, dir, "list.txt", "two.example.com\n")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(p, later, later)
+
+	for i := 0; ; i++ {
+		if _, ok := b.Match("two.example.com"); ok {
+			break
+		} else if i == 100 {
+			t.Fatal("changed list not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := b.Match("one.example.com"); ok {
+		t.Fatal("removed entry still blocked")
+	}
+
+	// lists which fail to load are kept, with their counts
+	os.Remove(p)
+	if err = b.Set(map[string]string{"list": p}); err == nil {
+		t.Fatal("missing file should fail to load")
+	}
+	if s := b.Stats(); len(s) != 1 || s[0].Blocked != 2 || s[0].Domains != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	if err = b.Set(nil); err != nil || len(b.Stats()) != 0 {
+		t.Fatal("lists should be removed")
+	}
+	if _, err = New(map[string]string{"list": p}, 0); err == nil {
+		t.Fatal("missing file should fail to load")
+	}
+}
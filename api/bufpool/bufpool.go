@@ -0,0 +1,40 @@
+// Copyright (c) 2022 Wireleap
+
+// Package bufpool provides a pool of fixed size byte buffers, so that
+// transmit buffers are reused across tunnels.
+package bufpool
+
+import "sync"
+
+// T is a pool of buffers of the same size
+type T struct {
+	size int
+	p    sync.Pool
+}
+
+// New returns a pool of buffers of size bytes
+func New(size int) *T {
+	t := &T{size: size}
+	t.p.New = func() interface{} {
+		b := make([]byte, size)
+		return &b
+	}
+	return t
+}
+
+// Size returns the size of the pooled buffers
+func (t *T) Size() int {
+	return t.size
+}
+
+// Get returns a buffer from the pool, pointers avoid an allocation on Put
+func (t *T) Get() *[]byte {
+	return t.p.Get().(*[]byte)
+}
+
+// Put returns a buffer to the pool, buffers of another size are dropped
+func (t *T) Put(b *[]byte) {
+	if b != nil && len(*b) == t.size {
+		t.p.Put(b)
+	}
+}
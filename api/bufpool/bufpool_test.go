@@ -0,0 +1,26 @@
+// Copyright (c) 2022 Wireleap
+
+package bufpool
+
+import "testing"
+
+func TestPool(t *testing.T) {
+	p := New(128)
+
+	b := p.Get()
+	if len(*b) != 128 {
+		t.Fatalf("wrong buffer size %d", len(*b))
+	}
+	p.Put(b)
+
+	// Foreign buffers should be dropped
+	small := make([]byte, 16)
+	p.Put(&small)
+	p.Put(nil)
+
+	for i := 0; i < 10; i++ {
+		if b = p.Get(); len(*b) != p.Size() {
+			t.Fatalf("wrong buffer size %d", len(*b))
+		}
+	}
+}
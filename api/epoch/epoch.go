@@ -3,6 +3,8 @@
 package epoch
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -17,3 +19,15 @@ func ToEpochMillis(t time.Time) int64 {
 func FromEpochMillis(i int64) time.Time {
 	return time.Unix(0, i*1000000)
 }
+
+// Parse parses epoch millis, a RFC 3339 time or a UTC date (2006-01-02)
+func Parse(s string) (int64, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return ToEpochMillis(t), nil
+	} else if t, err := time.Parse("2006-01-02", s); err == nil {
+		return ToEpochMillis(t), nil
+	}
+	return 0, fmt.Errorf("invalid time %q: expected epoch millis, RFC 3339 or YYYY-MM-DD", s)
+}
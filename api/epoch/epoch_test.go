@@ -22,3 +22,19 @@ func TestEpochMillis(t *testing.T) {
 		t.Error("Epoch.Now() should be higher")
 	}
 }
+
+func TestParse(t *testing.T) {
+	for s, e := range map[string]int64{
+		"1661811646792":             1661811646792,
+		"2022-08-29T22:20:46+00:00": 1661811646000,
+		"2022-08-30":                1661817600000,
+	} {
+		if i, err := Parse(s); err != nil || i != e {
+			t.Errorf("wrong parse of %s: %d %v", s, i, err)
+		}
+	}
+
+	if _, err := Parse("yesterday"); err == nil {
+		t.Error("should fail to parse")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2022 Wireleap
+
+package events
+
+import (
+	"sync"
+
+	"github.com/wireleap/relay/api/epoch"
+)
+
+// Event types
+const (
+	// Controller
+	Enrolled        = "enrolled"
+	EnrollFailed    = "enroll_failed"
+	Disenrolled     = "disenrolled"
+	DisenrollFailed = "disenroll_failed"
+	HeartbeatFailed = "heartbeat_failed"
+	Disabled        = "disabled"
+	Enabled         = "enabled"
+	Maintenance     = "maintenance"
+
+	// Contract manager
+	NetCapReached  = "netcap_reached"
+	NetCapReleased = "netcap_released"
+	StatsReset     = "stats_reset"
+	StatsArchived  = "stats_archived"
+	ArchiveCleaned = "archive_cleaned"
+
+	// Sharetoken scheduler
+	STSubmitted    = "sharetoken_submitted"
+	STSubmitFailed = "sharetoken_submit_failed"
+
+	// Upgrade handler
+	Upgrade = "upgrade"
+
+	// Admission control
+	SheddingStarted = "shedding_started"
+	SheddingStopped = "shedding_stopped"
+)
+
+// Subscriber channel size, slow subscribers are dropped once full
+const subBuffer = 64
+
+// Event is a typed relay event
+type Event struct {
+	Id       uint64      `json:"id"`
+	Type     string      `json:"type"`
+	Time     int64       `json:"time"`
+	Contract string      `json:"contract,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// Bus fans out events to subscribers and keeps a bounded replay buffer
+// A nil Bus discards every event.
+type Bus struct {
+	lock sync.Mutex
+	seq  uint64
+	ring []Event
+	next int
+	full bool
+	subs map[chan Event]struct{}
+}
+
+// New returns a bus replaying up to size events
+func New(size int) *Bus {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Bus{
+		ring: make([]Event, size),
+		subs: map[chan Event]struct{}{},
+	}
+}
+
+// Emit publishes an event, contract and data are optional
+func (b *Bus) Emit(typ, contract string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.seq++
+	ev := Event{
+		Id:       b.seq,
+		Type:     typ,
+		Time:     epoch.EpochMillis(),
+		Contract: contract,
+		Data:     data,
+	}
+
+	b.ring[b.next] = ev
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+			// pass
+		default:
+			// subscriber too slow, it can catch up by reconnecting
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Returns the buffered events after lastId, lock must be held
+func (b *Bus) backlog(lastId uint64) (l []Event) {
+	if lastId > b.seq {
+		// ids from a previous run, replay everything
+		lastId = 0
+	}
+
+	n := b.next
+	if !b.full {
+		n = 0
+	}
+
+	for i := 0; i < len(b.ring); i++ {
+		ev := b.ring[(n+i)%len(b.ring)]
+		if ev.Id != 0 && ev.Id > lastId {
+			l = append(l, ev)
+		}
+	}
+	return
+}
+
+// Backlog returns the buffered events emitted after lastId
+func (b *Bus) Backlog(lastId uint64) []Event {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.backlog(lastId)
+}
+
+// Subscribe returns the buffered events emitted after lastId and a channel
+// receiving the following ones. The channel is closed by cancel or if the
+// subscriber falls behind.
+func (b *Bus) Subscribe(lastId uint64) (backlog []Event, ch <-chan Event, cancel func()) {
+	c := make(chan Event, subBuffer)
+
+	if b == nil {
+		return nil, c, func() {}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subs[c] = struct{}{}
+
+	cancel = func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+	return b.backlog(lastId), c, cancel
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Wireleap
+
+package events
+
+import (
+	"testing"
+)
+
+func TestNilBus(t *testing.T) {
+	var b *Bus
+
+	// Should not panic
+	b.Emit(Enrolled, "ct1", nil)
+
+	if l := b.Backlog(0); len(l) != 0 {
+		t.Fatal("nil bus should have no backlog")
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	b := New(3)
+
+	for i := 0; i < 5; i++ {
+		b.Emit(Enrolled, "ct1", i)
+	}
+
+	l := b.Backlog(0)
+	if len(l) != 3 {
+		t.Fatalf("backlog should be bounded, got %d events", len(l))
+	} else if l[0].Id != 3 || l[2].Id != 5 {
+		t.Fatalf("wrong backlog order: %d ... %d", l[0].Id, l[2].Id)
+	}
+
+	if l = b.Backlog(4); len(l) != 1 || l[0].Id != 5 {
+		t.Fatal("backlog should start after last id")
+	}
+
+	if l = b.Backlog(100); len(l) != 3 {
+		t.Fatal("unknown last id should replay the whole backlog")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := New(8)
+	b.Emit(Enrolled, "ct1", nil)
+
+	l, ch, cancel := b.Subscribe(0)
+	defer cancel()
+
+	if len(l) != 1 {
+		t.Fatal("subscription should replay the backlog")
+	}
+
+	b.Emit(Disenrolled, "ct1", nil)
+
+	if ev := <-ch; ev.Type != Disenrolled || ev.Id != 2 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// Slow subscribers are dropped
+	for i := 0; i < subBuffer+1; i++ {
+		b.Emit(Enrolled, "ct1", nil)
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+
+	if n != subBuffer {
+		t.Fatalf("expected %d buffered events, got %d", subBuffer, n)
+	}
+}
@@ -0,0 +1,609 @@
+// Copyright (c) 2022 Wireleap
+
+// Package fairqueue shares the relay bandwidth between contracts by weight,
+// then evenly between the clients of each contract, whatever their number
+// of tunnels, while the uplink is saturated. It is a two level self-clocked
+// fair queueing scheduler, tunneled data is not queued otherwise.
+//
+// Requests are tagged with the virtual time at which they would finish if
+// every backlogged flow was served at its share, and served by increasing
+// tags. The backlogged clients of a contract are kept in a heap by the tag
+// of their next request.
+//
+// The forwarded data is counted by contract with synccounters counters,
+// sampled at every interval. The uplink is taken as saturated once writes
+// of several clients stall: the data is then released slightly slower than
+// the throughput measured meanwhile, probing upwards until writes stall
+// again, so that the queues form in the relay rather than in the network.
+// Scheduling stops once no write stalled and no request waited for a while.
+package fairqueue
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wireleap/relay/api/synccounters"
+)
+
+const (
+	// DefaultQuantum is the minimum burst used when none is set.
+	DefaultQuantum = 4096
+	// DefaultInterval is the throughput sampling interval used when none
+	// is set.
+	DefaultInterval = 100 * time.Millisecond
+	// DefaultStall is the write duration past which the uplink is taken as
+	// saturated when none is set.
+	DefaultStall = 5 * time.Millisecond
+)
+
+const (
+	// intervals without stalled writes nor waiting requests before
+	// scheduling stops
+	holdIntervals = 10
+	// rate decrease once writes stall and increase per interval without
+	// stalled writes
+	backoff = 0.9
+	probe   = 1.01
+)
+
+// Options of a scheduler
+type Options struct {
+	// Quantum is the minimum burst in bytes, it should be at least the size
+	// of a read.
+	Quantum int
+	// Interval is the throughput sampling interval, the scheduler is
+	// disabled if it is 0.
+	Interval time.Duration
+	// Stall is the write duration past which the uplink is taken as
+	// saturated, when it is exceeded by several clients.
+	Stall time.Duration
+}
+
+// Enabled returns whether fair queueing is enabled.
+func (o Options) Enabled() bool { return o.Interval > 0 }
+
+// Data waiting to be forwarded
+type request struct {
+	n int
+	// client level finish tag
+	tag       float64
+	done      chan struct{}
+	cancelled int32
+}
+
+func (r *request) isCancelled() bool { return atomic.LoadInt32(&r.cancelled) == 1 }
+
+// Requests of a client
+type client struct {
+	id string
+	// finish tag of the last request
+	last float64
+	reqs []*request
+	// position in the backlog of the contract
+	index int
+}
+
+func (c *client) pop() {
+	c.reqs[0] = nil
+	c.reqs = c.reqs[1:]
+}
+
+// Heap of the backlogged clients of a contract, by next request tag
+type backlog []*client
+
+func (b backlog) Len() int           { return len(b) }
+func (b backlog) Less(i, j int) bool { return b[i].reqs[0].tag < b[j].reqs[0].tag }
+
+func (b backlog) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+	b[i].index, b[j].index = i, j
+}
+
+func (b *backlog) Push(x interface{}) {
+	c := x.(*client)
+	c.index = len(*b)
+	*b = append(*b, c)
+}
+
+func (b *backlog) Pop() interface{} {
+	old := *b
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*b = old[:len(old)-1]
+	return c
+}
+
+// Clients of a contract
+type contract struct {
+	weight float64
+	// contract level finish tag of the last request and start tag of the
+	// next one, while backlogged
+	last, start float64
+	backlogged  bool
+	// client level virtual time
+	v       float64
+	clients map[string]*client
+	backlog backlog
+}
+
+func max(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns the client of the next request of a contract, clients left
+// without requests are dropped
+func (ct *contract) head() *client {
+	for len(ct.backlog) > 0 {
+		cl := ct.backlog[0]
+		if !cl.reqs[0].isCancelled() {
+			return cl
+		}
+
+		for len(cl.reqs) > 0 && cl.reqs[0].isCancelled() {
+			cl.pop()
+		}
+		ct.fix(cl)
+	}
+	return nil
+}
+
+// Restores the backlog order after the head request of a client changed
+func (ct *contract) fix(cl *client) {
+	if len(cl.reqs) == 0 {
+		heap.Remove(&ct.backlog, cl.index)
+		delete(ct.clients, cl.id)
+	} else {
+		heap.Fix(&ct.backlog, cl.index)
+	}
+}
+
+// Token bucket, allowing a burst of burst bytes
+type bucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func (b *bucket) fill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Returns how long to wait before n bytes can be taken, requests larger
+// than the burst are allowed once the bucket is full and leave a debt
+func (b *bucket) wait(n int) time.Duration {
+	b.fill()
+
+	need := float64(n)
+	if need > b.burst {
+		need = b.burst
+	}
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Client of a contract whose writes stalled
+type flow struct{ contract, client string }
+
+// T is a fair queueing scheduler, a nil T does not schedule
+type T struct {
+	o Options
+
+	// set while the uplink is saturated, read without mu on every request
+	congested int32
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	b         bucket
+	contracts map[string]*contract
+	weights   map[string]int
+	// contract level virtual time
+	v      float64
+	queued int
+	closed bool
+	stop   chan struct{}
+	// wakes the scheduler up once the uplink is no longer saturated
+	kick chan struct{}
+
+	// forwarded data by contract and flows whose writes stalled since the
+	// last sample, intervals left before scheduling stops
+	counters map[string]*synccounters.ContractCounter
+	stalled  map[flow]struct{}
+	hold     int
+	// throughput measured at the last sample
+	rate float64
+
+	// requests which had to wait for their turn, in total and at the last
+	// sample
+	waits, sampled uint64
+}
+
+// New returns a scheduler and starts it.
+func New(o Options) *T {
+	if o.Quantum <= 0 {
+		o.Quantum = DefaultQuantum
+	}
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if o.Stall <= 0 {
+		o.Stall = DefaultStall
+	}
+
+	q := &T{
+		o:         o,
+		contracts: map[string]*contract{},
+		weights:   map[string]int{},
+		stop:      make(chan struct{}),
+		kick:      make(chan struct{}, 1),
+		counters:  map[string]*synccounters.ContractCounter{},
+		stalled:   map[flow]struct{}{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	go q.run()
+	go q.sample()
+	return q
+}
+
+// SetWeights sets the weights of the contracts by contract id, contracts
+// without a weight have a weight of 1.
+func (q *T) SetWeights(weights map[string]int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.weights = weights
+	for k, ct := range q.contracts {
+		ct.weight = q.weight(k)
+	}
+}
+
+// Returns the weight of a contract, mu must be held
+func (q *T) weight(id string) float64 {
+	if w := q.weights[id]; w > 0 {
+		return float64(w)
+	}
+	return 1
+}
+
+// Returns the counter of the forwarded data of a contract
+func (q *T) counter(contractId string) *synccounters.ContractCounter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.counters[contractId]
+	if !ok {
+		c = synccounters.NewContractCounter()
+		q.counters[contractId] = c
+	}
+	return c
+}
+
+// Returns the contract and client of the next request, by contract level
+// finish tag, idle contracts without pending share are dropped
+func (q *T) next() (next *contract, cl *client, tag float64) {
+	for k, ct := range q.contracts {
+		if !ct.backlogged {
+			if ct.last <= q.v {
+				delete(q.contracts, k)
+			}
+			continue
+		}
+
+		c := ct.head()
+		if c == nil {
+			ct.backlogged = false
+			continue
+		}
+
+		if f := ct.start + float64(c.reqs[0].n)/ct.weight; next == nil || f < tag {
+			next, cl, tag = ct, c, f
+		}
+	}
+	return
+}
+
+// Wait blocks until n bytes of a client of a contract can be forwarded or
+// ctx is done.
+func (q *T) Wait(ctx context.Context, contractId, clientId string, n int) error {
+	if q == nil || n == 0 || atomic.LoadInt32(&q.congested) == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	// nothing queued and bandwidth available
+	if q.queued == 0 && q.b.wait(n) == 0 {
+		q.b.tokens -= float64(n)
+		q.mu.Unlock()
+		return nil
+	}
+
+	ct, ok := q.contracts[contractId]
+	if !ok {
+		ct = &contract{weight: q.weight(contractId), clients: map[string]*client{}}
+		q.contracts[contractId] = ct
+	}
+	if !ct.backlogged {
+		ct.start, ct.backlogged = max(q.v, ct.last), true
+	}
+
+	cl, ok := ct.clients[clientId]
+	if !ok {
+		cl = &client{id: clientId}
+		ct.clients[clientId] = cl
+	}
+
+	r := &request{n: n, tag: max(ct.v, cl.last) + float64(n), done: make(chan struct{})}
+	cl.last = r.tag
+	if cl.reqs = append(cl.reqs, r); len(cl.reqs) == 1 {
+		heap.Push(&ct.backlog, cl)
+	}
+
+	q.queued++
+	q.waits++
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		atomic.StoreInt32(&r.cancelled, 1)
+		return ctx.Err()
+	}
+}
+
+// Forwards the queued requests in turn, at the bucket rate while the
+// uplink is saturated and at once otherwise
+func (q *T) run() {
+	t := time.NewTimer(0)
+	defer t.Stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		ct, cl, tag := q.next()
+		for ct == nil && !q.closed {
+			q.queued = 0
+			q.cond.Wait()
+			ct, cl, tag = q.next()
+		}
+		if q.closed {
+			return
+		}
+
+		r := cl.reqs[0]
+		if atomic.LoadInt32(&q.congested) == 1 {
+			if d := q.b.wait(r.n); d > 0 {
+				// requests may arrive or be cancelled meanwhile
+				q.mu.Unlock()
+				t.Reset(d)
+				select {
+				case <-t.C:
+				case <-q.kick:
+					if !t.Stop() {
+						<-t.C
+					}
+				case <-q.stop:
+				}
+				q.mu.Lock()
+				continue
+			}
+			q.b.tokens -= float64(r.n)
+		}
+
+		cl.pop()
+		ct.fix(cl)
+		ct.v, ct.last, ct.start = r.tag, tag, tag
+		q.v = tag
+		q.queued--
+		close(r.done)
+
+		// lets the served tunnel read again before the next turn
+		q.mu.Unlock()
+		runtime.Gosched()
+		q.mu.Lock()
+	}
+}
+
+// Samples the throughput and assesses the uplink saturation every interval
+func (q *T) sample() {
+	t := time.NewTicker(q.o.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			q.assess()
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// Sets the bucket rate from the throughput forwarded since the last sample
+func (q *T) assess() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n uint64
+	for _, c := range q.counters {
+		n += c.Reset()
+	}
+	q.rate = float64(n) / q.o.Interval.Seconds()
+
+	switch {
+	case len(q.stalled) > 1:
+		// the throughput measured while saturated is the uplink capacity,
+		// data is released slightly slower so that the network drains
+		if atomic.LoadInt32(&q.congested) == 0 {
+			q.b.tokens, q.b.last = 0, time.Now()
+			q.setRate(q.rate * backoff)
+			atomic.StoreInt32(&q.congested, 1)
+		}
+		q.hold = holdIntervals
+	case q.hold > 0:
+		// scheduling holds while requests have to wait for their turn
+		if q.waits == q.sampled {
+			q.hold--
+		}
+		q.setRate(q.b.rate * probe)
+		if q.hold == 0 {
+			atomic.StoreInt32(&q.congested, 0)
+			select {
+			case q.kick <- struct{}{}:
+			default:
+			}
+		}
+	}
+	q.stalled, q.sampled = map[flow]struct{}{}, q.waits
+}
+
+// Sets the bucket rate, at least a quantum per interval, mu must be held.
+// The burst is kept below what stalls a write at that rate.
+func (q *T) setRate(rate float64) {
+	if min := float64(q.o.Quantum) / q.o.Interval.Seconds(); rate < min {
+		rate = min
+	}
+	q.b.fill()
+	q.b.rate = rate
+	q.b.burst = max(rate*q.o.Stall.Seconds()/2, float64(q.o.Quantum))
+}
+
+// Records a stalled write of a client of a contract
+func (q *T) stall(contractId, clientId string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f := flow{contractId, clientId}
+	if _, ok := q.stalled[f]; ok {
+		return
+	}
+	q.stalled[f] = struct{}{}
+
+	// the rate overshot the uplink capacity, backs off without waiting
+	// for the next sample
+	if len(q.stalled) == 2 && atomic.LoadInt32(&q.congested) == 1 {
+		q.setRate(q.b.rate * backoff)
+	}
+}
+
+// Close stops the scheduler, waiting requests stay blocked until their
+// context is done.
+func (q *T) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+		q.cond.Broadcast()
+	}
+}
+
+// ContractStats are the scheduling state of a contract
+type ContractStats struct {
+	Contract string `json:"contract"`
+	Weight   int    `json:"weight"`
+	Clients  int    `json:"clients"`
+	Queued   int    `json:"queued"`
+}
+
+// Stats are the scheduling state of the contracts with queued data, whether
+// the uplink is saturated and the throughput in bytes per second measured
+// at the last sample. Waits is a total since the start.
+type Stats struct {
+	Contracts []ContractStats `json:"contracts"`
+	Congested bool            `json:"congested"`
+	Rate      uint64          `json:"rate"`
+	Waits     uint64          `json:"waits"`
+}
+
+// Stats returns the scheduler statistics.
+func (q *T) Stats() (s Stats) {
+	q.mu.Lock()
+	for k, ct := range q.contracts {
+		cs := ContractStats{Contract: k, Weight: int(ct.weight), Clients: len(ct.clients)}
+		for _, cl := range ct.clients {
+			for _, r := range cl.reqs {
+				cs.Queued += r.n
+			}
+		}
+		s.Contracts = append(s.Contracts, cs)
+	}
+	s.Congested = atomic.LoadInt32(&q.congested) == 1
+	s.Rate = uint64(q.rate)
+	s.Waits = q.waits
+	q.mu.Unlock()
+
+	sort.Slice(s.Contracts, func(i, j int) bool { return s.Contracts[i].Contract < s.Contracts[j].Contract })
+	return
+}
+
+// Scheduled ReadWriteCloser, reads wait for their turn and are counted,
+// slow writes are taken as a sign of saturation
+type rwc struct {
+	io.ReadWriteCloser
+	ctx              context.Context
+	q                *T
+	contract, client string
+	cc               *synccounters.ConnCounter
+	closed           sync.Once
+}
+
+// NewRWC returns a ReadWriteCloser whose reads are scheduled by q as data
+// of a client of a contract.
+func (q *T) NewRWC(ctx context.Context, c io.ReadWriteCloser, contract, client string) io.ReadWriteCloser {
+	return &rwc{
+		ReadWriteCloser: c,
+		ctx:             ctx,
+		q:               q,
+		contract:        contract,
+		client:          client,
+		cc:              q.counter(contract).NewChild(),
+	}
+}
+
+func (r *rwc) Read(p []byte) (n int, err error) {
+	n, err = r.ReadWriteCloser.Read(p)
+
+	if werr := r.q.Wait(r.ctx, r.contract, r.client, n); werr != nil && err == nil {
+		err = werr
+	}
+	in, _ := r.cc.Inner()
+	atomic.AddUint64(in, uint64(n))
+	return
+}
+
+func (r *rwc) Write(p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = r.ReadWriteCloser.Write(p)
+
+	if time.Since(start) > r.q.o.Stall {
+		r.q.stall(r.contract, r.client)
+	}
+	return
+}
+
+func (r *rwc) Close() error {
+	r.closed.Do(func() { r.cc.Close() })
+	return r.ReadWriteCloser.Close()
+}
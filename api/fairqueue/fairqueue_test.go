@@ -0,0 +1,200 @@
+// Copyright (c) 2022 Wireleap
+
+package fairqueue
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Endless source of data
+type source struct{}
+
+func (source) Read(p []byte) (int, error)  { return len(p), nil }
+func (source) Write(p []byte) (int, error) { return len(p), nil }
+func (source) Close() error                { return nil }
+
+// Uplink shared by the tunnels, forwarding rate bytes per second
+type uplink struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+func (u *uplink) forward(n int) {
+	u.mu.Lock()
+	if now := time.Now(); u.next.Before(now) {
+		u.next = now
+	}
+	u.next = u.next.Add(time.Duration(float64(n) / u.rate * float64(time.Second)))
+	d := time.Until(u.next)
+	u.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+// Tunnel reading from an endless source and writing to the uplink, if any
+type conn struct {
+	source
+	up *uplink
+}
+
+func (c conn) Write(p []byte) (int, error) {
+	if c.up != nil {
+		c.up.forward(len(p))
+	}
+	return len(p), nil
+}
+
+// Tunnels of a client in the synthetic load
+type load struct {
+	contract, client string
+	tunnels          int
+	// bytes forwarded past the warmup
+	n uint64
+}
+
+// Runs the tunnels of every load through q and up for d, the data forwarded
+// during warmup is not counted
+func run(q *T, up *uplink, d, warmup time.Duration, loads ...*load) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	from := time.Now().Add(warmup)
+
+	var wg sync.WaitGroup
+	for _, l := range loads {
+		for i := 0; i < l.tunnels; i++ {
+			wg.Add(1)
+			go func(l *load) {
+				defer wg.Done()
+
+				c := q.NewRWC(ctx, conn{up: up}, l.contract, l.client)
+				defer c.Close()
+
+				p := make([]byte, 2048)
+				for ctx.Err() == nil {
+					n, err := c.Read(p)
+					if err != nil {
+						return
+					}
+					c.Write(p[:n])
+					if time.Now().After(from) {
+						atomic.AddUint64(&l.n, uint64(n))
+					}
+				}
+			}(l)
+		}
+	}
+	wg.Wait()
+}
+
+// Checks that a/b is within 20% of ratio
+func checkShare(t *testing.T, what string, a, b uint64, ratio float64) {
+	if r := float64(a) / float64(b); r < ratio*0.8 || r > ratio*1.2 {
+		t.Errorf("%s: expected a %.2f ratio, got %.2f (%d/%d)", what, ratio, r, a, b)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	q := New(Options{Quantum: 2048, Interval: 50 * time.Millisecond, Stall: 15 * time.Millisecond})
+	defer q.Close()
+	q.SetWeights(map[string]int{"ct1": 3})
+
+	// the more tunnels do not get more bandwidth
+	l1 := &load{contract: "ct1", client: "c1", tunnels: 4}
+	l2 := &load{contract: "ct2", client: "c2", tunnels: 12}
+	run(q, &uplink{rate: 1 << 20}, 2*time.Second, 500*time.Millisecond, l1, l2)
+
+	checkShare(t, "contracts", l1.n, l2.n, 3)
+	if s := q.Stats(); !s.Congested || s.Waits == 0 {
+		t.Errorf("saturated uplink should be scheduled %+v", s)
+	}
+}
+
+func TestClients(t *testing.T) {
+	q := New(Options{Quantum: 2048, Interval: 50 * time.Millisecond, Stall: 15 * time.Millisecond})
+	defer q.Close()
+
+	l1 := &load{contract: "ct1", client: "c1", tunnels: 2}
+	l2 := &load{contract: "ct1", client: "c2", tunnels: 8}
+	l3 := &load{contract: "ct2", client: "c3", tunnels: 4}
+	run(q, &uplink{rate: 1 << 20}, 2*time.Second, 500*time.Millisecond, l1, l2, l3)
+
+	checkShare(t, "clients", l1.n, l2.n, 1)
+	checkShare(t, "contracts", l1.n+l2.n, l3.n, 1)
+}
+
+func TestUncongested(t *testing.T) {
+	var q *T
+	if err := q.Wait(context.Background(), "ct", "c", 1<<20); err != nil {
+		t.Fatal("nil scheduler should not wait")
+	}
+
+	q = New(Options{Interval: 10 * time.Millisecond})
+	defer q.Close()
+
+	// several clients, none stalled by the uplink
+	l1 := &load{contract: "ct1", client: "c1", tunnels: 2}
+	l2 := &load{contract: "ct2", client: "c2", tunnels: 2}
+	run(q, nil, 100*time.Millisecond, 0, l1, l2)
+
+	if s := q.Stats(); s.Congested || s.Waits != 0 || len(s.Contracts) != 0 {
+		t.Fatalf("nothing should be queued while the uplink keeps up %+v", s)
+	} else if s.Rate == 0 {
+		t.Fatalf("throughput should be measured %+v", s)
+	}
+
+	c := q.NewRWC(context.Background(), source{}, "ct1", "c1")
+	defer c.Close()
+	if _, err := io.CopyN(io.Discard, c, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCongestion(t *testing.T) {
+	// sampled by hand
+	q := New(Options{Interval: time.Hour})
+	defer q.Close()
+
+	q.stall("ct1", "c1")
+	q.stall("ct1", "c1")
+	if q.assess(); q.Stats().Congested {
+		t.Fatal("a single stalled client should not be taken as saturation")
+	}
+
+	q.stall("ct1", "c1")
+	q.stall("ct2", "c2")
+	if q.assess(); !q.Stats().Congested {
+		t.Fatal("stalled clients should be taken as saturation")
+	}
+
+	for i := 0; i < holdIntervals-1; i++ {
+		if q.assess(); !q.Stats().Congested {
+			t.Fatalf("scheduling should hold for %d intervals", holdIntervals)
+		}
+	}
+	if q.assess(); q.Stats().Congested {
+		t.Fatal("scheduling should stop without stalled writes")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	q := New(Options{Quantum: 1024, Interval: time.Hour})
+	defer q.Close()
+
+	q.stall("ct", "c1")
+	q.stall("ct", "c2")
+	// the bucket starts empty, at a quantum per hour
+	q.assess()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := q.Wait(ctx, "ct", "c", 1024); err != context.DeadlineExceeded {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2022 Wireleap
+
+// Package h2ping sends keepalive PING frames on server side HTTP/2
+// connections, which x/net/http2 does not do, to close the connections of
+// peers which disappeared silently.
+package h2ping
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Peer types
+const (
+	// PeerInbound is a connection accepted by the listener.
+	PeerInbound = "inbound"
+	// PeerForwarded is a connection forwarded by the previous relay.
+	PeerForwarded = "forwarded"
+	// PeerNextHop is a pooled connection to the next relay.
+	PeerNextHop = "next_hop"
+)
+
+const (
+	frameHeaderLen = 9
+	framePing      = 0x6
+	flagAck        = 0x1
+	pingLen        = 8
+)
+
+// Options of the keepalive pings
+type Options struct {
+	// Interval between pings, 0 disables them.
+	Interval time.Duration
+	// Timeout closes the connection when a ping is not acknowledged.
+	Timeout time.Duration
+	// Recorder records the round-trip times, it can be nil.
+	Recorder *Recorder
+}
+
+// Tracks the frame boundaries of a stream of HTTP/2 frames
+type scanner struct {
+	// bytes left to skip: the client preface or a frame payload
+	skip int
+	hdr  [frameHeaderLen]byte
+	nhdr int
+	// a PING ACK payload is being read
+	ack   bool
+	data  [pingLen]byte
+	ndata int
+}
+
+// Consumes p, calling fn with the payload of each PING ACK
+func (s *scanner) scan(p []byte, fn func([pingLen]byte)) {
+	for len(p) > 0 {
+		switch {
+		case s.ack:
+			n := copy(s.data[s.ndata:], p)
+			s.ndata, p = s.ndata+n, p[n:]
+			if s.ndata == pingLen {
+				s.ack, s.ndata = false, 0
+				if fn != nil {
+					fn(s.data)
+				}
+			}
+		case s.skip > 0:
+			n := s.skip
+			if n > len(p) {
+				n = len(p)
+			}
+			s.skip, p = s.skip-n, p[n:]
+		default:
+			n := copy(s.hdr[s.nhdr:], p)
+			s.nhdr, p = s.nhdr+n, p[n:]
+			if s.nhdr < frameHeaderLen {
+				return
+			}
+			s.nhdr = 0
+
+			l := int(s.hdr[0])<<16 | int(s.hdr[1])<<8 | int(s.hdr[2])
+			if s.hdr[3] == framePing && s.hdr[4]&flagAck != 0 && l == pingLen {
+				s.ack = true
+			} else {
+				s.skip = l
+			}
+		}
+	}
+}
+
+// Whether the stream is between two frames
+func (s *scanner) boundary() bool {
+	return s.skip == 0 && s.nhdr == 0 && !s.ack
+}
+
+// Conn is a server side HTTP/2 connection sending keepalive pings. The
+// frames are tracked in both directions to write the pings between the
+// server frames and to read the acknowledgements, which the server ignores.
+type Conn struct {
+	*tls.Conn
+	peer string
+	o    Options
+
+	// only used by the server reader goroutine
+	rd scanner
+
+	wmu sync.Mutex
+	wr  scanner
+	// the server wrote its first frame, pings can follow
+	started bool
+	// a ping waits for a frame boundary
+	pending bool
+
+	mu   sync.Mutex
+	seq  uint64
+	data [pingLen]byte
+	sent time.Time
+	ack  chan struct{}
+
+	dead int32
+	done chan struct{}
+	once sync.Once
+}
+
+// New wraps a server side connection, after its TLS handshake and before
+// it is passed to the HTTP/2 server, and starts the keepalive pings.
+func New(c *tls.Conn, peer string, o Options) *Conn {
+	pc := &Conn{Conn: c, peer: peer, o: o, done: make(chan struct{})}
+	pc.rd.skip = len(http2.ClientPreface)
+	if o.Interval > 0 {
+		go pc.run()
+	}
+	return pc
+}
+
+// Peer returns the peer type of the connection.
+func (c *Conn) Peer() string { return c.peer }
+
+// Dead returns whether the connection was closed for a missed ping.
+func (c *Conn) Dead() bool { return atomic.LoadInt32(&c.dead) == 1 }
+
+func (c *Conn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	c.rd.scan(p[:n], c.acked)
+	return
+}
+
+func (c *Conn) Write(p []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	n, err = c.Conn.Write(p)
+	c.wr.scan(p[:n], nil)
+	if n > 0 && c.wr.boundary() {
+		c.started = true
+	}
+	if err == nil {
+		c.writePing()
+	}
+	return
+}
+
+func (c *Conn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// Writes the pending ping if between two frames, wmu must be held
+func (c *Conn) writePing() {
+	if !c.pending || !c.started || !c.wr.boundary() {
+		return
+	}
+	c.pending = false
+
+	var f [frameHeaderLen + pingLen]byte
+	f[2], f[3] = pingLen, framePing
+
+	c.mu.Lock()
+	copy(f[frameHeaderLen:], c.data[:])
+	c.sent = time.Now()
+	c.mu.Unlock()
+
+	c.Conn.Write(f[:])
+}
+
+// Called with the payload of a received PING ACK
+func (c *Conn) acked(data [pingLen]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ack == nil || c.sent.IsZero() || data != c.data {
+		return
+	}
+	close(c.ack)
+	c.ack = nil
+	c.o.Recorder.Observe(c.peer, time.Since(c.sent))
+}
+
+// Pings the peer until the connection is closed
+func (c *Conn) run() {
+	t := time.NewTicker(c.o.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+		}
+
+		if !c.ping() {
+			return
+		}
+	}
+}
+
+// Sends a ping and waits for its acknowledgement, the connection is closed
+// when it times out
+func (c *Conn) ping() bool {
+	ack := make(chan struct{})
+
+	c.mu.Lock()
+	c.seq++
+	binary.BigEndian.PutUint64(c.data[:], c.seq)
+	c.sent = time.Time{}
+	c.ack = ack
+	c.mu.Unlock()
+
+	// a write blocked on a vanished peer must not delay the timeout
+	go func() {
+		c.wmu.Lock()
+		c.pending = true
+		c.writePing()
+		c.wmu.Unlock()
+	}()
+
+	tm := time.NewTimer(c.o.Timeout)
+	defer tm.Stop()
+
+	select {
+	case <-ack:
+		return true
+	case <-c.done:
+		return false
+	case <-tm.C:
+		atomic.StoreInt32(&c.dead, 1)
+		c.o.Recorder.Dead(c.peer)
+		c.Close()
+		return false
+	}
+}
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying the connection.
+func NewContext(ctx context.Context, c *Conn) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the connection of a request context, if any.
+func FromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(ctxKey{}).(*Conn)
+	return c
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Wireleap
+
+package h2ping
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestScanner(t *testing.T) {
+	var b bytes.Buffer
+	f := http2.NewFramer(&b, nil)
+	f.WriteData(1, false, make([]byte, 20))
+	f.WritePing(false, [8]byte{1})
+	f.WritePing(true, [8]byte{2})
+	f.WriteSettings()
+
+	var (
+		s    scanner
+		acks [][pingLen]byte
+	)
+	for i, c := range b.Bytes() {
+		s.scan([]byte{c}, func(p [pingLen]byte) { acks = append(acks, p) })
+		if i == frameHeaderLen && s.boundary() {
+			t.Fatal("boundary reported within a frame")
+		}
+	}
+
+	if !s.boundary() {
+		t.Fatal("boundary not reported after the last frame")
+	}
+	if len(acks) != 1 || acks[0] != [8]byte{2} {
+		t.Fatalf("unexpected acks %v", acks)
+	}
+}
+
+func newCert(t *testing.T) tls.Certificate {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "h2ping"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+// Serves HTTP/2 with keepalive pings, accepted connections are sent on the
+// returned channel
+func serve(t *testing.T, o Options) (string, chan *Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	tc := &tls.Config{Certificates: []tls.Certificate{newCert(t)}, NextProtos: []string{http2.NextProtoTLS}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	conns := make(chan *Conn, 1)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			sc := tls.Server(c, tc)
+			if err = sc.Handshake(); err != nil {
+				c.Close()
+				continue
+			}
+
+			pc := New(sc, PeerInbound, o)
+			conns <- pc
+			go (&http2.Server{}).ServeConn(pc, &http2.ServeConnOpts{Handler: h})
+		}
+	}()
+	return l.Addr().String(), conns
+}
+
+func dial(t *testing.T, addr string) *tls.Conn {
+	c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{http2.NextProtoTLS}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Polls until cond is met or fails the test
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestPing(t *testing.T) {
+	r := NewRecorder()
+	addr, conns := serve(t, Options{Interval: 10 * time.Millisecond, Timeout: time.Second, Recorder: r})
+
+	cc, err := (&http2.Transport{}).NewClientConn(dial(t, addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pings are sent between the server frames of a request
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "https://"+addr, nil)
+		res, err := cc.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	waitFor(t, "acknowledged pings", func() bool { return r.Stats()[PeerInbound].Pings >= 3 })
+
+	s := r.Stats()[PeerInbound]
+	if s.RTT <= 0 || s.MinRTT > s.RTT || s.MaxRTT < s.RTT || s.Dead != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if pc := <-conns; pc.Dead() {
+		t.Fatal("answering peer reported dead")
+	}
+}
+
+func TestDeadPeer(t *testing.T) {
+	r := NewRecorder()
+	addr, conns := serve(t, Options{Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond, Recorder: r})
+
+	// a client which stops answering after its preface
+	c := dial(t, addr)
+	c.Write([]byte(http2.ClientPreface))
+	http2.NewFramer(c, nil).WriteSettings()
+
+	pc := <-conns
+	waitFor(t, "the dead peer", pc.Dead)
+
+	if s := r.Stats()[PeerInbound]; s.Dead != 1 || s.Pings != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if _, err := pc.Write([]byte{0}); err == nil {
+		t.Fatal("dead peer connection not closed")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var nr *Recorder
+	nr.Observe(PeerInbound, time.Second)
+	if len(nr.Stats()) != 0 {
+		t.Fatal("nil recorder should record nothing")
+	}
+
+	r := NewRecorder()
+	r.Observe(PeerNextHop, 80*time.Millisecond)
+	r.Observe(PeerNextHop, 160*time.Millisecond)
+	r.DeadTunnel(PeerNextHop)
+
+	s := r.Stats()[PeerNextHop]
+	if s.RTT != 90*time.Millisecond || s.MinRTT != 80*time.Millisecond || s.MaxRTT != 160*time.Millisecond {
+		t.Fatalf("unexpected round-trip times %+v", s)
+	} else if s.Pings != 2 || s.DeadTunnels != 1 {
+		t.Fatalf("unexpected counters %+v", s)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Wireleap
+
+package h2ping
+
+import (
+	"sync"
+	"time"
+)
+
+// PeerStats are the keepalive statistics of a peer type, counters are
+// totals since the start
+type PeerStats struct {
+	// Pings is the number of acknowledged pings.
+	Pings uint64 `json:"pings"`
+	// Dead is the number of connections closed for a missed ping.
+	Dead uint64 `json:"dead"`
+	// DeadTunnels is the number of tunnels ended by a dead peer.
+	DeadTunnels uint64 `json:"dead_tunnels"`
+	// RTT is the smoothed round-trip time, as in RFC 6298.
+	RTT time.Duration `json:"rtt"`
+	// MinRTT and MaxRTT are the extreme round-trip times.
+	MinRTT time.Duration `json:"min_rtt"`
+	MaxRTT time.Duration `json:"max_rtt"`
+}
+
+// Recorder records the keepalive statistics by peer type, a nil Recorder
+// records nothing
+type Recorder struct {
+	mu    sync.Mutex
+	peers map[string]*PeerStats
+}
+
+// NewRecorder returns an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{peers: map[string]*PeerStats{}}
+}
+
+// Returns the statistics of a peer type, mu must be held
+func (r *Recorder) get(peer string) *PeerStats {
+	s, ok := r.peers[peer]
+	if !ok {
+		s = &PeerStats{}
+		r.peers[peer] = s
+	}
+	return s
+}
+
+// Observe records the round-trip time of an acknowledged ping.
+func (r *Recorder) Observe(peer string, rtt time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(peer)
+	if s.Pings == 0 {
+		s.RTT, s.MinRTT, s.MaxRTT = rtt, rtt, rtt
+	} else {
+		s.RTT += (rtt - s.RTT) / 8
+		if rtt < s.MinRTT {
+			s.MinRTT = rtt
+		}
+		if rtt > s.MaxRTT {
+			s.MaxRTT = rtt
+		}
+	}
+	s.Pings++
+}
+
+// Dead records a connection closed for a missed ping.
+func (r *Recorder) Dead(peer string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.get(peer).Dead++
+	r.mu.Unlock()
+}
+
+// DeadTunnel records a tunnel ended by a dead peer.
+func (r *Recorder) DeadTunnel(peer string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.get(peer).DeadTunnels++
+	r.mu.Unlock()
+}
+
+// Stats returns the statistics by peer type.
+func (r *Recorder) Stats() map[string]PeerStats {
+	s := map[string]PeerStats{}
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, v := range r.peers {
+		s[k] = *v
+	}
+	return s
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2022 Wireleap
+
+// Package h2pool keeps pooled, multiplexed HTTP/2 connections to the next
+// relays so that tunnels are opened as new streams rather than new TCP and
+// TLS handshakes.
+package h2pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wireleap/relay/api/h2ping"
+
+	"golang.org/x/net/http2"
+)
+
+var (
+	// ErrFull is returned when every pooled connection to a target is at
+	// its streams limit and the pool size is reached.
+	ErrFull = errors.New("pooled connections are full")
+	// ErrUnsupported is returned by the probe, and then for the idle
+	// timeout, when a target does not accept pooled streams.
+	ErrUnsupported = errors.New("target does not support pooled streams")
+)
+
+// Options of a pool
+type Options struct {
+	// Size is the maximum number of connections per target.
+	Size int
+	// IdleTimeout closes connections without streams.
+	IdleTimeout time.Duration
+	// HealthCheck is the interval of the health checks, 0 disables them.
+	HealthCheck time.Duration
+	// PingTimeout closes the connections not answering a health check
+	// ping, 0 uses the health check interval.
+	PingTimeout time.Duration
+	// Recorder records the health check round-trip times, it can be nil.
+	Recorder *h2ping.Recorder
+	// Dial opens a TLS connection negotiating h2.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Probe is called on new connections, an error discards them.
+	Probe func(ctx context.Context, cc *http2.ClientConn, target string) error
+}
+
+// Target connections
+type target struct {
+	conns       []*http2.ClientConn
+	dialing     int
+	unsupported time.Time
+	// idle connections, by first check finding them idle
+	idle map[*http2.ClientConn]time.Time
+}
+
+// T is a pool of HTTP/2 connections, by target URL
+type T struct {
+	o  Options
+	tr *http2.Transport
+
+	mu      sync.Mutex
+	targets map[string]*target
+	// connections closed for a failed ping, by time of the failure
+	dead map[*http2.ClientConn]time.Time
+
+	dials, streams, fallbacks, failures uint64
+
+	done chan struct{}
+	once sync.Once
+}
+
+// New returns a pool using tr for the HTTP/2 settings of the connections.
+func New(tr *http2.Transport, o Options) *T {
+	p := &T{o: o, tr: tr, targets: map[string]*target{}, dead: map[*http2.ClientConn]time.Time{}, done: make(chan struct{})}
+	if o.HealthCheck > 0 {
+		go p.run()
+	}
+	return p
+}
+
+// Returns the connections by number of active streams
+func sortByStreams(ccs []*http2.ClientConn) []*http2.ClientConn {
+	r := append([]*http2.ClientConn(nil), ccs...)
+	active := make(map[*http2.ClientConn]int, len(r))
+	for _, cc := range r {
+		active[cc] = cc.State().StreamsActive
+	}
+	sort.SliceStable(r, func(i, j int) bool { return active[r[i]] < active[r[j]] })
+	return r
+}
+
+// Get returns a connection to the target with a stream reserved for a
+// request, dialing it if needed.
+func (p *T) Get(ctx context.Context, rawurl string) (*http2.ClientConn, error) {
+	p.mu.Lock()
+	t, ok := p.targets[rawurl]
+	if !ok {
+		t = &target{idle: map[*http2.ClientConn]time.Time{}}
+		p.targets[rawurl] = t
+	}
+
+	if time.Now().Before(t.unsupported) {
+		p.mu.Unlock()
+		return nil, ErrUnsupported
+	}
+
+	for _, cc := range sortByStreams(t.conns) {
+		if cc.ReserveNewRequest() {
+			delete(t.idle, cc)
+			p.mu.Unlock()
+			atomic.AddUint64(&p.streams, 1)
+			return cc, nil
+		}
+	}
+
+	if len(t.conns)+t.dialing >= p.o.Size {
+		p.mu.Unlock()
+		return nil, ErrFull
+	}
+	t.dialing++
+	p.mu.Unlock()
+
+	cc, err := p.dial(ctx, rawurl)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.dialing--
+	if errors.Is(err, ErrUnsupported) {
+		t.unsupported = time.Now().Add(p.o.IdleTimeout)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.conns = append(t.conns, cc)
+	if !cc.ReserveNewRequest() {
+		return nil, ErrFull
+	}
+
+	atomic.AddUint64(&p.streams, 1)
+	return cc, nil
+}
+
+// Release gives back the stream reserved by Get when it is not used.
+func (p *T) Release(cc *http2.ClientConn) {
+	// reservations are only given back by requests, this one is refused
+	// by the transport before a stream is opened
+	req, err := http.NewRequest(http.MethodPut, "https://release.invalid/", nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Upgrade", "release")
+
+	if res, err := cc.RoundTrip(req); err == nil {
+		res.Body.Close()
+	}
+}
+
+func (p *T) dial(ctx context.Context, rawurl string) (cc *http2.ClientConn, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+
+	c, err := p.o.Dial(ctx, "tcp", u.Host)
+	if err != nil {
+		return
+	}
+
+	atomic.AddUint64(&p.dials, 1)
+	if cc, err = p.tr.NewClientConn(c); err != nil {
+		c.Close()
+		return
+	}
+
+	if p.o.Probe != nil {
+		if err = p.o.Probe(ctx, cc, rawurl); err != nil {
+			cc.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// Fallback counts a tunnel dialed directly rather than through the pool
+func (p *T) Fallback() {
+	atomic.AddUint64(&p.fallbacks, 1)
+}
+
+// Runs the health checks until the pool is closed
+func (p *T) run() {
+	t := time.NewTicker(p.o.HealthCheck)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+			p.check()
+		}
+	}
+}
+
+// Closes the idle or broken connections, pings the others
+func (p *T) check() {
+	now := time.Now()
+	var ping []*http2.ClientConn
+
+	timeout := p.o.PingTimeout
+	if timeout == 0 {
+		timeout = p.o.HealthCheck
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	p.mu.Lock()
+	// tunnels end as soon as their connection is closed
+	for cc, at := range p.dead {
+		if now.Sub(at) >= p.o.HealthCheck {
+			delete(p.dead, cc)
+		}
+	}
+	for k, t := range p.targets {
+		conns := t.conns[:0]
+		for _, cc := range t.conns {
+			st := cc.State()
+			idle := st.StreamsActive == 0 && st.StreamsReserved == 0
+
+			if !idle {
+				delete(t.idle, cc)
+			} else if _, ok := t.idle[cc]; !ok {
+				t.idle[cc] = now
+			}
+
+			if st.Closed || (st.Closing && idle) || (idle && now.Sub(t.idle[cc]) >= p.o.IdleTimeout) {
+				delete(t.idle, cc)
+				cc.Close()
+				continue
+			}
+
+			conns = append(conns, cc)
+			ping = append(ping, cc)
+		}
+		t.conns = conns
+
+		if len(t.conns) == 0 && t.dialing == 0 && now.After(t.unsupported) {
+			delete(p.targets, k)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, cc := range ping {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := cc.Ping(ctx)
+		cancel()
+
+		if err != nil {
+			// broken connections are removed on the next check
+			atomic.AddUint64(&p.failures, 1)
+			p.o.Recorder.Dead(h2ping.PeerNextHop)
+
+			p.mu.Lock()
+			p.dead[cc] = time.Now()
+			p.mu.Unlock()
+
+			cc.Close()
+			continue
+		}
+		p.o.Recorder.Observe(h2ping.PeerNextHop, time.Since(start))
+	}
+}
+
+// Dead returns whether the connection was recently closed for a failed
+// health check ping.
+func (p *T) Dead(cc *http2.ClientConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.dead[cc]
+	return ok
+}
+
+// TargetStats are the statistics of the connections to a target
+type TargetStats struct {
+	Target      string `json:"target"`
+	Connections int    `json:"connections"`
+	Streams     int    `json:"streams"`
+}
+
+// Stats are the pool statistics, counters are totals since the start
+type Stats struct {
+	Targets        []TargetStats `json:"targets"`
+	Dials          uint64        `json:"dials"`
+	Streams        uint64        `json:"streams"`
+	Fallbacks      uint64        `json:"fallbacks"`
+	HealthFailures uint64        `json:"health_failures"`
+}
+
+// Stats returns the pool statistics
+func (p *T) Stats() (s Stats) {
+	p.mu.Lock()
+	for k, t := range p.targets {
+		ts := TargetStats{Target: k, Connections: len(t.conns)}
+		for _, cc := range t.conns {
+			ts.Streams += cc.State().StreamsActive
+		}
+		s.Targets = append(s.Targets, ts)
+	}
+	p.mu.Unlock()
+
+	sort.Slice(s.Targets, func(i, j int) bool { return s.Targets[i].Target < s.Targets[j].Target })
+	s.Dials = atomic.LoadUint64(&p.dials)
+	s.Streams = atomic.LoadUint64(&p.streams)
+	s.Fallbacks = atomic.LoadUint64(&p.fallbacks)
+	s.HealthFailures = atomic.LoadUint64(&p.failures)
+	return
+}
+
+// Close stops the health checks and closes the connections
+func (p *T) Close() {
+	p.once.Do(func() { close(p.done) })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for k, t := range p.targets {
+		for _, cc := range t.conns {
+			cc.Close()
+		}
+		delete(p.targets, k)
+	}
+}
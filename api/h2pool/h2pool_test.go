@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Wireleap
+
+package h2pool
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return tls.Dial(network, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+}
+
+func get(t *testing.T, cc *http2.ClientConn, url string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	res, err := cc.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestPool(t *testing.T) {
+	s := newServer(t)
+	p := New(&http2.Transport{}, Options{Size: 1, IdleTimeout: time.Hour, Dial: dial})
+	defer p.Close()
+
+	cc, err := p.Get(context.Background(), s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, cc, s.URL)
+
+	cc2, err := p.Get(context.Background(), s.URL)
+	if err != nil {
+		t.Fatal(err)
+	} else if cc2 != cc {
+		t.Fatal("connection should be reused")
+	}
+	get(t, cc2, s.URL)
+
+	st := p.Stats()
+	if st.Dials != 1 || st.Streams != 2 || len(st.Targets) != 1 || st.Targets[0].Connections != 1 {
+		t.Fatalf("wrong stats %+v", st)
+	}
+
+	// Idle connections are healthy until the idle timeout
+	p.check()
+	if st = p.Stats(); st.HealthFailures != 0 || st.Targets[0].Connections != 1 {
+		t.Fatalf("healthy connection should be kept %+v", st)
+	}
+
+	// Unused streams are given back
+	cc3, err := p.Get(context.Background(), s.URL)
+	if err != nil {
+		t.Fatal(err)
+	} else if cc3.State().StreamsReserved != 1 {
+		t.Fatal("stream should be reserved")
+	}
+	p.Release(cc3)
+	if cc3.State().StreamsReserved != 0 {
+		t.Fatal("stream should be released")
+	}
+
+	p.o.IdleTimeout = 0
+	p.check()
+	if st = p.Stats(); len(st.Targets) != 0 || !cc.State().Closed {
+		t.Fatalf("idle connection should be closed %+v", st)
+	}
+}
+
+func TestPoolUnsupported(t *testing.T) {
+	s := newServer(t)
+	probes := 0
+	p := New(&http2.Transport{}, Options{
+		Size:        1,
+		IdleTimeout: time.Hour,
+		Dial:        dial,
+		Probe: func(ctx context.Context, cc *http2.ClientConn, target string) error {
+			probes++
+			return ErrUnsupported
+		},
+	})
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Get(context.Background(), s.URL); !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("target should be unsupported, got %v", err)
+		}
+	}
+
+	if probes != 1 {
+		t.Fatalf("unsupported target should not be probed again, probed %d times", probes)
+	}
+
+	p.Fallback()
+	if st := p.Stats(); st.Fallbacks != 1 || st.Dials != 1 {
+		t.Fatalf("wrong stats %+v", st)
+	}
+}
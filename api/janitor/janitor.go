@@ -0,0 +1,255 @@
+// Copyright (c) 2022 Wireleap
+
+// Package janitor enforces the retention of archive directories, removing
+// old files and compacting them into monthly bundles.
+package janitor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Compacted bundles are named archive-YYYY-MM.tar.gz
+const (
+	bundlePrefix = "archive-"
+	bundleSuffix = ".tar.gz"
+)
+
+// Policy defines the retention of an archive, zero values are disabled
+type Policy struct {
+	// MaxAge removes files and bundles older than it.
+	MaxAge time.Duration
+	// MaxCount keeps the newest files and bundles only.
+	MaxCount int
+	// MaxSize removes the oldest files and bundles above it.
+	MaxSize uint64
+	// CompactAfter rolls files older than it into monthly bundles.
+	CompactAfter time.Duration
+}
+
+// Enabled returns if the policy has any effect
+func (p Policy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxCount > 0 || p.MaxSize > 0 || p.CompactAfter > 0
+}
+
+// Report is the outcome of a janitor run
+type Report struct {
+	// Removed is the number of files and bundles removed
+	Removed int `json:"removed"`
+	// RemovedBytes is the size of the removed files and bundles
+	RemovedBytes uint64 `json:"removed_bytes"`
+	// Compacted is the number of files rolled into bundles
+	Compacted int `json:"compacted"`
+}
+
+// IsBundle returns if a file name is a compacted bundle
+func IsBundle(name string) bool {
+	return strings.HasPrefix(name, bundlePrefix) && strings.HasSuffix(name, bundleSuffix)
+}
+
+// Archived file or bundle
+type entry struct {
+	path string
+	mod  time.Time
+	size int64
+}
+
+// Returns the archived files and bundles under root, oldest first
+func scan(root string) (es []entry, err error) {
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") {
+			// temporary files are hidden
+			return nil
+		} else if strings.HasSuffix(fi.Name(), ".json") || IsBundle(fi.Name()) {
+			es = append(es, entry{path: path, mod: fi.ModTime(), size: fi.Size()})
+		}
+		return nil
+	})
+
+	sort.SliceStable(es, func(i, j int) bool { return es[i].mod.Before(es[j].mod) })
+	return
+}
+
+// Run compacts and removes the archived files under root according to the
+// policy. Only JSON files and bundles are considered.
+func Run(root string, p Policy, now time.Time) (r Report, err error) {
+	if p.CompactAfter > 0 {
+		if r.Compacted, err = compact(root, now.Add(-p.CompactAfter)); err != nil {
+			return
+		}
+	}
+
+	es, err := scan(root)
+	if err != nil {
+		return
+	}
+
+	var size uint64
+	for _, e := range es {
+		size += uint64(e.size)
+	}
+
+	for i, e := range es {
+		left := len(es) - i
+		if (p.MaxAge > 0 && now.Sub(e.mod) > p.MaxAge) ||
+			(p.MaxCount > 0 && left > p.MaxCount) ||
+			(p.MaxSize > 0 && size > p.MaxSize) {
+			if err = os.Remove(e.path); err != nil {
+				return
+			}
+
+			r.Removed++
+			r.RemovedBytes += uint64(e.size)
+			size -= uint64(e.size)
+		}
+	}
+	return
+}
+
+// Rolls the JSON files modified before t into the bundle of their month,
+// in their directory
+func compact(root string, t time.Time) (n int, err error) {
+	es, err := scan(root)
+	if err != nil {
+		return
+	}
+
+	groups := map[string][]entry{}
+	for _, e := range es {
+		if e.mod.Before(t) && !IsBundle(filepath.Base(e.path)) {
+			b := filepath.Join(filepath.Dir(e.path), bundlePrefix+e.mod.UTC().Format("2006-01")+bundleSuffix)
+			groups[b] = append(groups[b], e)
+		}
+	}
+
+	for b, g := range groups {
+		if err = addToBundle(b, g); err != nil {
+			return
+		}
+
+		for _, e := range g {
+			if err = os.Remove(e.path); err != nil {
+				return
+			}
+		}
+		n += len(g)
+	}
+	return
+}
+
+// Bundled file
+type member struct {
+	name string
+	mod  time.Time
+	data []byte
+}
+
+// Rewrites a bundle with the files added, the bundle is replaced atomically
+func addToBundle(path string, es []entry) (err error) {
+	var ms []member
+	if _, errS := os.Stat(path); errS == nil {
+		err = ReadBundle(path, func(name string, mod time.Time, data []byte) error {
+			ms = append(ms, member{name, mod, data})
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	for _, e := range es {
+		var data []byte
+		if data, err = ioutil.ReadFile(e.path); err != nil {
+			return
+		}
+		ms = append(ms, member{filepath.Base(e.path), e.mod, data})
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".archive-*.tmp")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	var latest time.Time
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, m := range ms {
+		h := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data)), ModTime: m.mod}
+		if err = tw.WriteHeader(h); err != nil {
+			return
+		} else if _, err = tw.Write(m.data); err != nil {
+			return
+		}
+
+		if m.mod.After(latest) {
+			latest = m.mod
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return
+	} else if err = zw.Close(); err != nil {
+		return
+	} else if err = f.Chmod(0644); err != nil {
+		return
+	} else if err = f.Sync(); err != nil {
+		return
+	} else if err = f.Close(); err != nil {
+		return
+	} else if err = os.Rename(f.Name(), path); err != nil {
+		return
+	}
+
+	// Bundles age like their newest file
+	return os.Chtimes(path, latest, latest)
+}
+
+// ReadBundle calls fn with the name, modification time and content of every
+// file of a bundle
+func ReadBundle(path string, fn func(name string, mod time.Time, data []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("could not read bundle %s: %w", path, err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not read bundle %s: %w", path, err)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("could not read bundle %s: %w", path, err)
+		} else if err = fn(h.Name, h.ModTime, data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Wireleap
+
+package janitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "janitortest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	now := time.Date(2022, 9, 15, 0, 0, 0, 0, time.UTC)
+	write := func(name string, mod time.Time) {
+		p := filepath.Join(tmpd, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(`{"name":"`+name+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		} else if err = os.Chtimes(p, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ct1/a.json", now.AddDate(0, -2, 0))
+	write("ct1/b.json", now.AddDate(0, -2, 1))
+	write("ct1/c.json", now.AddDate(0, -1, 0))
+	write("ct1/d.json", now.Add(-time.Hour))
+	write("ct2/e.json", now.AddDate(0, -2, 0))
+	write("ct2/notes.txt", now.AddDate(-1, 0, 0))
+
+	// Compaction only
+	r, err := Run(tmpd, Policy{CompactAfter: 7 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	} else if r.Compacted != 4 || r.Removed != 0 {
+		t.Fatalf("wrong report %+v", r)
+	}
+
+	var names []string
+	err = ReadBundle(filepath.Join(tmpd, "ct1", "archive-2022-07.tar.gz"), func(name string, mod time.Time, data []byte) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(names) != 2 {
+		t.Fatalf("wrong bundle content %v", names)
+	}
+
+	// Adding to an existing bundle
+	write("ct1/f.json", now.AddDate(0, -2, 2))
+	if r, err = Run(tmpd, Policy{CompactAfter: 7 * 24 * time.Hour}, now); err != nil || r.Compacted != 1 {
+		t.Fatalf("wrong report %+v %v", r, err)
+	}
+
+	names = nil
+	ReadBundle(filepath.Join(tmpd, "ct1", "archive-2022-07.tar.gz"), func(name string, mod time.Time, data []byte) error {
+		names = append(names, name)
+		return nil
+	})
+	if len(names) != 3 {
+		t.Fatalf("bundle should be extended %v", names)
+	}
+
+	// ct1: 2022-07 bundle, 2022-08 bundle, d.json; ct2: 2022-07 bundle
+	if r, err = Run(tmpd, Policy{MaxAge: 40 * 24 * time.Hour}, now); err != nil {
+		t.Fatal(err)
+	} else if r.Removed != 2 || r.RemovedBytes == 0 {
+		t.Fatalf("old bundles should be removed %+v", r)
+	}
+
+	if r, err = Run(tmpd, Policy{MaxCount: 1}, now); err != nil || r.Removed != 1 {
+		t.Fatalf("oldest entries should be removed %+v %v", r, err)
+	} else if _, err = os.Stat(filepath.Join(tmpd, "ct1", "d.json")); err != nil {
+		t.Fatal("newest file should be kept")
+	} else if _, err = os.Stat(filepath.Join(tmpd, "ct2", "notes.txt")); err != nil {
+		t.Fatal("other files should be left untouched")
+	}
+
+	if r, err = Run(tmpd, Policy{MaxSize: 1}, now); err != nil || r.Removed != 1 {
+		t.Fatalf("files above max size should be removed %+v %v", r, err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Wireleap
+
+package map_counter
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/wireleap/relay/api/synccounters"
+)
+
+var impls = []struct {
+	name   string
+	newMap func() Map
+}{
+	{"list", NewList},
+	{"sharded", NewSharded},
+}
+
+func keys(n int) []string {
+	ks := make([]string, n)
+	for i := range ks {
+		ks[i] = fmt.Sprintf("contract-%d", i)
+	}
+	return ks
+}
+
+// Tunnels opening and closing over existing contracts
+func BenchmarkGetOrInit(b *testing.B) {
+	for _, impl := range impls {
+		for _, n := range []int{10, 1000} {
+			b.Run(fmt.Sprintf("%s/contracts=%d", impl.name, n), func(b *testing.B) {
+				m, ks := impl.newMap(), keys(n)
+				for _, k := range ks {
+					m.GetOrInit(k).Close()
+				}
+
+				var i uint64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						m.GetOrInit(ks[atomic.AddUint64(&i, 1)%uint64(n)]).Close()
+					}
+				})
+			})
+		}
+	}
+}
+
+// New contracts being inserted
+func BenchmarkInsert(b *testing.B) {
+	for _, impl := range impls {
+		b.Run(impl.name, func(b *testing.B) {
+			m, ks := impl.newMap(), keys(b.N)
+			b.ResetTimer()
+			for _, k := range ks {
+				m.GetOrInit(k).Close()
+			}
+		})
+	}
+}
+
+// Stats being read while tunnels are open
+func BenchmarkRange(b *testing.B) {
+	for _, impl := range impls {
+		b.Run(impl.name, func(b *testing.B) {
+			m := impl.newMap()
+			for _, k := range keys(1000) {
+				m.GetOrInit(k)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Range(func(_ string, v *synccounters.ContractCounter) bool {
+					v.Sum()
+					return true
+				})
+			}
+		})
+	}
+}
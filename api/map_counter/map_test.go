@@ -125,3 +125,8 @@ func TestAtomicList(t *testing.T) {
 	testMap(t, NewList)
 	testMapReset(t, NewList)
 }
+
+func TestSharded(t *testing.T) {
+	testMap(t, NewSharded)
+	testMapReset(t, NewSharded)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Wireleap
+
+package map_counter
+
+import (
+	"sync"
+
+	"github.com/wireleap/relay/api/synccounters"
+)
+
+// SHARDS is the number of shards of a sharded map
+const SHARDS = 32
+
+type shard struct {
+	m  map[string]*synccounters.ContractCounter
+	mu sync.RWMutex
+}
+
+// shardedMap spreads contract counters over several locked maps, lookups
+// only contend with inserts of contracts in the same shard
+type shardedMap struct {
+	shards [SHARDS]shard
+}
+
+func NewSharded() Map {
+	r := &shardedMap{}
+	for i := range r.shards {
+		r.shards[i].m = make(map[string]*synccounters.ContractCounter)
+	}
+	return r
+}
+
+// FNV-1a, without the allocation of hash/fnv
+func (m *shardedMap) shard(key string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &m.shards[h%SHARDS]
+}
+
+func (m *shardedMap) GetOrInit(key string) *synccounters.ConnCounter {
+	s := m.shard(key)
+
+	s.mu.RLock()
+	v, ok := s.m[key]
+	s.mu.RUnlock()
+
+	if ok {
+		return v.NewChild()
+	}
+
+	s.mu.Lock()
+	if v, ok = s.m[key]; !ok {
+		v = synccounters.NewContractCounter()
+		s.m[key] = v
+	}
+	s.mu.Unlock()
+	return v.NewChild()
+}
+
+func (m *shardedMap) Range(f func(key string, value *synccounters.ContractCounter) bool) bool {
+	var ts []Tuple
+	for i := range m.shards {
+		s := &m.shards[i]
+
+		// f is called without holding the shard lock
+		s.mu.RLock()
+		ts = ts[:0]
+		for k, v := range s.m {
+			ts = append(ts, Tuple{Key: k, Val: v})
+		}
+		s.mu.RUnlock()
+
+		for _, t := range ts {
+			if !f(t.Key, t.Val) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *shardedMap) Reset() (map[string]uint64, bool) {
+	return resetMap(m)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2022 Wireleap
+
+package netcap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// Actions taken once a threshold is reached
+const (
+	// Disenroll refuses new connections, live tunnels are left to drain
+	Disenroll = "disenroll"
+	// Disable disenrolls and cancels live tunnels
+	Disable = "disable"
+	// Throttle keeps the relay enrolled with a limited bandwidth
+	Throttle = "throttle"
+)
+
+// Action strictness, a hard threshold cannot be less strict than a soft one
+var strictness = map[string]int{
+	Throttle:  1,
+	Disenroll: 2,
+	Disable:   3,
+}
+
+// Limit is a threshold relative to a network usage limit: either a
+// fraction of the limit, or an absolute margin of bytes below it.
+type Limit struct {
+	Fraction float64
+	Margin   datasize.ByteSize
+}
+
+// Fraction limit
+func Fraction(f float64) Limit { return Limit{Fraction: f} }
+
+// Absolute byte margin limit
+func Margin(b datasize.ByteSize) Limit { return Limit{Margin: b} }
+
+// UnmarshalJSON accepts a fraction (0.9), a percentage ("90%") or a byte
+// margin below the limit ("10GB").
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err == nil {
+		*l = Fraction(f)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid limit %s: expected a fraction, a percentage or a byte size", b)
+	}
+
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid limit %q: %w", s, err)
+		}
+		*l = Fraction(p / 100)
+		return nil
+	}
+
+	var bs datasize.ByteSize
+	if err := bs.UnmarshalText([]byte(s)); err != nil {
+		return fmt.Errorf("invalid limit %q: %w", s, err)
+	}
+	*l = Margin(bs)
+	return nil
+}
+
+func (l Limit) MarshalJSON() ([]byte, error) {
+	if l.Margin != 0 {
+		return json.Marshal(l.Margin.String())
+	}
+	return json.Marshal(l.Fraction)
+}
+
+// Returns the threshold in bytes for a network usage limit
+func (l Limit) Apply(limit uint64) uint64 {
+	if l.Margin != 0 {
+		if m := l.Margin.Bytes(); m < limit {
+			return limit - m
+		}
+		return 0
+	}
+	return uint64(float64(limit) * l.Fraction)
+}
+
+// Validate the limit on its own
+func (l Limit) Validate() error {
+	if l.Margin != 0 {
+		return nil
+	} else if l.Fraction <= 0 || l.Fraction > 1 {
+		return fmt.Errorf("fraction %v has to be in (0, 1]", l.Fraction)
+	}
+	return nil
+}
+
+// Threshold defines the action taken once a limit is reached
+type Threshold struct {
+	// Limit is the usage threshold.
+	Limit Limit `json:"limit"`
+	// Action is one of disenroll, disable or throttle.
+	Action string `json:"action"`
+	// Rate is the allowed bandwidth per second while throttling.
+	Rate datasize.ByteSize `json:"rate,omitempty"`
+}
+
+// Validate the threshold on its own
+func (t *Threshold) Validate() error {
+	if err := t.Limit.Validate(); err != nil {
+		return err
+	} else if _, ok := strictness[t.Action]; !ok {
+		return fmt.Errorf("unknown action %q", t.Action)
+	} else if t.Action == Throttle && t.Rate == 0 {
+		return errors.New("'rate' has to be set to throttle")
+	} else if t.Action != Throttle && t.Rate != 0 {
+		return errors.New("'rate' is only valid to throttle")
+	}
+	return nil
+}
+
+// Policy defines the soft and hard thresholds of a network usage limit
+type Policy struct {
+	Soft *Threshold `json:"soft,omitempty"`
+	Hard *Threshold `json:"hard,omitempty"`
+}
+
+// DefaultPolicy disenrolls at 90% of the limit and disables at 93%
+func DefaultPolicy() Policy {
+	return Policy{
+		Soft: &Threshold{Limit: Fraction(0.9), Action: Disenroll},
+		Hard: &Threshold{Limit: Fraction(0.93), Action: Disable},
+	}
+}
+
+// Merge returns the policy with unset thresholds taken from base
+func (p *Policy) Merge(base Policy) Policy {
+	if p == nil {
+		return base
+	}
+
+	r := *p
+	if r.Soft == nil {
+		r.Soft = base.Soft
+	}
+	if r.Hard == nil {
+		r.Hard = base.Hard
+	}
+	return r
+}
+
+// Validate the policy, the threshold ordering can only be checked against
+// the limit if the thresholds mix fractions and margins.
+func (p *Policy) Validate(limit uint64) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.Soft != nil {
+		if err := p.Soft.Validate(); err != nil {
+			return fmt.Errorf("soft threshold failed to validate: %w", err)
+		}
+	}
+
+	if p.Hard != nil {
+		if err := p.Hard.Validate(); err != nil {
+			return fmt.Errorf("hard threshold failed to validate: %w", err)
+		}
+	}
+
+	if p.Soft == nil || p.Hard == nil {
+		return nil
+	}
+
+	if strictness[p.Soft.Action] > strictness[p.Hard.Action] {
+		return fmt.Errorf("soft action %q is stricter than hard action %q", p.Soft.Action, p.Hard.Action)
+	}
+
+	s, h := p.Soft.Limit, p.Hard.Limit
+	switch {
+	case s.Margin == 0 && h.Margin == 0:
+		if s.Fraction > h.Fraction {
+			return errors.New("soft threshold is above hard threshold")
+		}
+	case s.Margin != 0 && h.Margin != 0:
+		if s.Margin < h.Margin {
+			return errors.New("soft threshold is above hard threshold")
+		}
+	case limit != 0:
+		if s.Apply(limit) > h.Apply(limit) {
+			return errors.New("soft threshold is above hard threshold")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Wireleap
+
+package netcap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+)
+
+func TestLimit(t *testing.T) {
+	for s, exp := range map[string]Limit{
+		`0.8`:    Fraction(0.8),
+		`"75%"`:  Fraction(0.75),
+		`"10GB"`: Margin(10 * datasize.GB),
+	} {
+		var l Limit
+		if err := json.Unmarshal([]byte(s), &l); err != nil {
+			t.Fatal(err)
+		} else if l != exp {
+			t.Fatalf("%s: expected %+v, got %+v", s, exp, l)
+		}
+	}
+
+	var l Limit
+	if err := json.Unmarshal([]byte(`"lots"`), &l); err == nil {
+		t.Fatal("invalid limit should fail")
+	}
+
+	if v := Fraction(0.5).Apply(1000); v != 500 {
+		t.Fatalf("wrong fraction threshold %d", v)
+	} else if v = Margin(100).Apply(1000); v != 900 {
+		t.Fatalf("wrong margin threshold %d", v)
+	} else if v = Margin(2000).Apply(1000); v != 0 {
+		t.Fatalf("margin above limit should floor to 0, got %d", v)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	p := DefaultPolicy()
+	if err := p.Validate(0); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []Policy{
+		// unknown action
+		{Soft: &Threshold{Limit: Fraction(0.9), Action: "panic"}},
+		// throttle without rate
+		{Soft: &Threshold{Limit: Fraction(0.9), Action: Throttle}},
+		// rate without throttle
+		{Soft: &Threshold{Limit: Fraction(0.9), Action: Disenroll, Rate: 100}},
+		// fraction out of range
+		{Hard: &Threshold{Limit: Fraction(1.5), Action: Disable}},
+		// soft stricter than hard
+		{
+			Soft: &Threshold{Limit: Fraction(0.8), Action: Disable},
+			Hard: &Threshold{Limit: Fraction(0.9), Action: Disenroll},
+		},
+		// soft above hard
+		{
+			Soft: &Threshold{Limit: Fraction(0.95), Action: Disenroll},
+			Hard: &Threshold{Limit: Fraction(0.9), Action: Disable},
+		},
+		// soft margin below hard margin
+		{
+			Soft: &Threshold{Limit: Margin(datasize.GB), Action: Disenroll},
+			Hard: &Threshold{Limit: Margin(2 * datasize.GB), Action: Disable},
+		},
+	}
+
+	for i, p := range invalid {
+		if err := p.Validate(0); err == nil {
+			t.Fatalf("policy %d should fail to validate", i)
+		}
+	}
+
+	// mixed limits are checked against the limit
+	p = Policy{
+		Soft: &Threshold{Limit: Fraction(0.99), Action: Throttle, Rate: datasize.MB},
+		Hard: &Threshold{Limit: Margin(10 * datasize.GB), Action: Disable},
+	}
+
+	if err := p.Validate(0); err != nil {
+		t.Fatal(err)
+	} else if err = p.Validate(uint64(100 * datasize.GB)); err == nil {
+		t.Fatal("soft threshold above hard threshold should fail to validate")
+	}
+}
+
+func TestPolicyMerge(t *testing.T) {
+	var p *Policy
+	if m := p.Merge(DefaultPolicy()); m.Soft.Action != Disenroll || m.Hard.Action != Disable {
+		t.Fatal("nil policy should merge into base")
+	}
+
+	p = &Policy{Soft: &Threshold{Limit: Fraction(0.5), Action: Throttle, Rate: datasize.MB}}
+	if m := p.Merge(DefaultPolicy()); m.Soft.Action != Throttle || m.Hard.Action != Disable {
+		t.Fatal("unset thresholds should be taken from base")
+	}
+}
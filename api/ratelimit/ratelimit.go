@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Wireleap
+
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by many connections
+// A zero rate disables the limiter.
+type Limiter struct {
+	lock   sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing rate bytes per second
+func New(rate uint64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(rate)
+	return l
+}
+
+// SetRate updates the allowed bytes per second, 0 disables the limiter
+func (l *Limiter) SetRate(rate uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if float64(rate) == l.rate {
+		return
+	}
+
+	// allow a burst of one second worth of data
+	l.rate = float64(rate)
+	l.tokens = l.rate
+	l.last = time.Now()
+}
+
+// Rate returns the allowed bytes per second, 0 if disabled
+func (l *Limiter) Rate() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return uint64(l.rate)
+}
+
+// Reserve n bytes, returns how long to wait before using them
+func (l *Limiter) reserve(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WaitN blocks until n bytes are allowed or ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	d := l.reserve(n)
+	if d == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Throttled ReadWriteCloser, reads are limited by every limiter
+type rwc struct {
+	io.ReadWriteCloser
+	ctx context.Context
+	ls  []*Limiter
+}
+
+// NewRWC returns a ReadWriteCloser whose reads are limited by ls
+func NewRWC(ctx context.Context, c io.ReadWriteCloser, ls ...*Limiter) io.ReadWriteCloser {
+	return &rwc{ReadWriteCloser: c, ctx: ctx, ls: ls}
+}
+
+func (r *rwc) Read(p []byte) (n int, err error) {
+	n, err = r.ReadWriteCloser.Read(p)
+
+	for _, l := range r.ls {
+		if werr := l.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return
+}
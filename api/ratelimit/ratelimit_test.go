@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Wireleap
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(0)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		l.WaitN(ctx, 1<<20)
+	}
+
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("disabled limiter should not wait")
+	}
+
+	l.SetRate(10000)
+
+	// burst
+	if d := l.reserve(10000); d != 0 {
+		t.Fatalf("burst should be allowed, waiting %s", d)
+	}
+
+	// 50ms worth of data
+	if d := l.reserve(500); d < 40*time.Millisecond || d > 60*time.Millisecond {
+		t.Fatalf("unexpected wait %s", d)
+	}
+
+	if l.Rate() != 10000 {
+		t.Fatal("wrong rate")
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := New(1)
+	l.reserve(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.WaitN(ctx, 1000); err == nil {
+		t.Fatal("wait should be cancelled")
+	}
+}
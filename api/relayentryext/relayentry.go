@@ -3,9 +3,13 @@
 package relayentryext
 
 import (
+	"fmt"
+
 	"github.com/wireleap/common/api/relayentry"
 
 	"github.com/c2h5oh/datasize"
+
+	"github.com/wireleap/relay/api/netcap"
 )
 
 type T struct {
@@ -13,4 +17,29 @@ type T struct {
 	relayentry.T
 	// Network usage limit
 	NetUsage datasize.ByteSize `json:"network_usage_limit,omitempty"`
+	// Network usage limit thresholds, defaults to network_usage.policy
+	NetUsagePolicy *netcap.Policy `json:"network_usage_policy,omitempty"`
+	// Network usage limits within network_usage.windows, by window name
+	NetUsageWindows map[string]datasize.ByteSize `json:"network_usage_window_limits,omitempty"`
+	// Network usage pacing, defaults to network_usage.pacing
+	NetUsagePacing *bool `json:"network_usage_pacing,omitempty"`
+	// Priority while the global limit is reached, higher is kept longer
+	Priority int `json:"priority,omitempty"`
+	// Network usage reserved out of the global limit
+	NetUsageReserved datasize.ByteSize `json:"network_usage_reserved,omitempty"`
+	// Bandwidth share under congestion relative to the other contracts,
+	// defaults to 1
+	FairQueueWeight int `json:"fair_queue_weight,omitempty"`
+}
+
+// Validate the relay entry and its network usage policy
+func (t *T) Validate() error {
+	if err := t.T.Validate(); err != nil {
+		return err
+	} else if err = t.NetUsagePolicy.Validate(uint64(t.NetUsage)); err != nil {
+		return fmt.Errorf("network_usage_policy failed to validate: %w", err)
+	} else if t.FairQueueWeight < 0 {
+		return fmt.Errorf("fair_queue_weight cannot be negative")
+	}
+	return nil
 }
@@ -13,6 +13,8 @@ import (
 	"github.com/wireleap/common/api/texturl"
 
 	"github.com/blang/semver"
+
+	"github.com/wireleap/relay/api/netcap"
 )
 
 func TestValidate(t *testing.T) {
@@ -79,4 +81,22 @@ func TestValidate(t *testing.T) {
 	if err = r.Validate(); err == nil {
 		t.Fatal(err)
 	}
+
+	// Should fail with an invalid network usage policy
+	r = T{
+		T: relayentry.T{
+			Role:     "fronting",
+			Addr:     texturl.URLMustParse("wireleap://wireleap.com"),
+			Pubkey:   jsonb.PK(pk),
+			Versions: vs,
+		},
+		NetUsage: datasize.ByteSize(50),
+		NetUsagePolicy: &netcap.Policy{
+			Soft: &netcap.Threshold{Limit: netcap.Fraction(0.9), Action: netcap.Throttle},
+		},
+	}
+
+	if err = r.Validate(); err == nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,306 @@
+// Copyright (c) 2022 Wireleap
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Server is an upstream DNS server
+type Server struct {
+	// Network is "udp", "tcp" or "https".
+	Network string
+	// Addr is the host:port of UDP and TCP servers or the URL of
+	// DNS-over-HTTPS servers.
+	Addr string
+}
+
+func (s Server) String() string {
+	if s.Network == "https" {
+		return s.Addr
+	}
+	return s.Network + "://" + s.Addr
+}
+
+// ParseServer parses udp://host[:port], tcp://host[:port] and https:// URLs
+// of DNS-over-HTTPS servers.
+func ParseServer(s string) (Server, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Server{}, err
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" || u.Path != "" {
+			return Server{}, fmt.Errorf("invalid %s server '%s'", u.Scheme, s)
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "53")
+		}
+		return Server{Network: u.Scheme, Addr: addr}, nil
+	case "https":
+		if u.Host == "" {
+			return Server{}, fmt.Errorf("invalid DNS-over-HTTPS server '%s'", s)
+		}
+		return Server{Network: u.Scheme, Addr: u.String()}, nil
+	default:
+		return Server{}, fmt.Errorf("unsupported DNS server scheme '%s' in '%s'", u.Scheme, s)
+	}
+}
+
+// Response of a server to a question
+type answer struct {
+	ips []net.IP
+	ttl time.Duration
+	// no such domain
+	nxdomain bool
+}
+
+const maxMessageLen = 65535
+
+// Builds a recursive query for name, name being fully qualified
+func newQuery(name string, typ dnsmessage.Type) (id uint16, b []byte, err error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return
+	}
+
+	// random IDs make spoofed answers harder to match
+	var r [2]byte
+	if _, err = rand.Read(r[:]); err != nil {
+		return
+	}
+	id = binary.BigEndian.Uint16(r[:])
+
+	// the first 2 bytes are reserved for the TCP length prefix
+	bd := dnsmessage.NewBuilder(make([]byte, 2, 514), dnsmessage.Header{ID: id, RecursionDesired: true})
+	bd.EnableCompression()
+	if err = bd.StartQuestions(); err != nil {
+		return
+	}
+	if err = bd.Question(dnsmessage.Question{Name: n, Type: typ, Class: dnsmessage.ClassINET}); err != nil {
+		return
+	}
+	b, err = bd.Finish()
+	return
+}
+
+// Sends a query to a server, falling back to TCP for truncated UDP answers
+func (s Server) exchange(ctx context.Context, c *http.Client, name string, typ dnsmessage.Type) (a answer, err error) {
+	id, q, err := newQuery(name, typ)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	switch s.Network {
+	case "udp":
+		b, err = exchangeUDP(ctx, s.Addr, id, q[2:])
+		if err == nil && truncated(b) {
+			b, err = exchangeTCP(ctx, s.Addr, q)
+		}
+	case "tcp":
+		b, err = exchangeTCP(ctx, s.Addr, q)
+	case "https":
+		b, err = exchangeHTTPS(ctx, c, s.Addr, q[2:])
+	default:
+		err = fmt.Errorf("unsupported network %s", s.Network)
+	}
+	if err != nil {
+		return
+	}
+	return parse(b, id, typ, s.Network == "https")
+}
+
+func truncated(b []byte) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	return err == nil && h.Truncated
+}
+
+func exchangeUDP(ctx context.Context, addr string, id uint16, q []byte) ([]byte, error) {
+	c, err := (&net.Dialer{}).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	if _, err = c.Write(q); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, maxMessageLen)
+	for {
+		n, err := c.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		// ignore stray answers to previous queries
+		if n >= 2 && binary.BigEndian.Uint16(b) == id {
+			return b[:n], nil
+		}
+	}
+}
+
+func exchangeTCP(ctx context.Context, addr string, q []byte) ([]byte, error) {
+	c, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+
+	binary.BigEndian.PutUint16(q, uint16(len(q)-2))
+	if _, err = c.Write(q); err != nil {
+		return nil, err
+	}
+
+	var l [2]byte
+	if _, err = io.ReadFull(c, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err = io.ReadFull(c, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Sends an RFC 8484 DNS-over-HTTPS query
+func exchangeHTTPS(ctx context.Context, c *http.Client, u string, q []byte) ([]byte, error) {
+	// the ID is zeroed for HTTP caches
+	q = append([]byte{0, 0}, q[2:]...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(q))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS server replied %s", res.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, maxMessageLen))
+}
+
+var (
+	errServerFailure = errors.New("server failure")
+	errRefused       = errors.New("query refused")
+)
+
+// Parses the answer to a query of type typ, the ID is not checked for
+// DNS-over-HTTPS answers
+func parse(b []byte, id uint16, typ dnsmessage.Type, https bool) (a answer, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case !h.Response || (!https && h.ID != id):
+		return a, errors.New("mismatched answer")
+	case h.RCode == dnsmessage.RCodeNameError:
+		a.nxdomain = true
+	case h.RCode == dnsmessage.RCodeServerFailure:
+		return a, errServerFailure
+	case h.RCode == dnsmessage.RCodeRefused:
+		return a, errRefused
+	case h.RCode != dnsmessage.RCodeSuccess:
+		return a, fmt.Errorf("unexpected response code %s", h.RCode)
+	}
+
+	if err = p.SkipAllQuestions(); err != nil {
+		return
+	}
+
+	// answers include the CNAME chain, the addresses are those of its end
+	ttl := ^uint32(0)
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return a, err
+		}
+
+		switch {
+		case rh.Type == typ && typ == dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return a, err
+			}
+			a.ips = append(a.ips, net.IP(r.A[:]))
+		case rh.Type == typ && typ == dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return a, err
+			}
+			a.ips = append(a.ips, net.IP(r.AAAA[:]))
+		default:
+			if err = p.SkipAnswer(); err != nil {
+				return a, err
+			}
+		}
+		if rh.TTL < ttl {
+			ttl = rh.TTL
+		}
+	}
+
+	// negative answers are cached for the SOA minimum, RFC 2308
+	if len(a.ips) == 0 {
+		ttl = 0
+		for {
+			rh, err := p.AuthorityHeader()
+			if err != nil {
+				break
+			}
+			if rh.Type != dnsmessage.TypeSOA {
+				if p.SkipAuthority() != nil {
+					break
+				}
+				continue
+			}
+			r, err := p.SOAResource()
+			if err != nil {
+				break
+			}
+			ttl = rh.TTL
+			if r.MinTTL < ttl {
+				ttl = r.MinTTL
+			}
+			break
+		}
+	}
+
+	a.ttl = time.Duration(ttl) * time.Second
+	return a, nil
+}
@@ -0,0 +1,466 @@
+// Copyright (c) 2022 Wireleap
+
+// Package resolver resolves the hostnames of the targets, through the
+// configured DNS servers or the system resolver, and dials the resolved
+// addresses happy eyeballs style (RFC 8305).
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolution errors, wrapped by the errors of Lookup and Dial
+var (
+	ErrNotFound  = errors.New("host not found")
+	ErrTimeout   = errors.New("host resolution timed out")
+	ErrServer    = errors.New("host resolution failed")
+	ErrForbidden = errors.New("resolved addresses not allowed")
+)
+
+// Error kinds of the statistics
+const (
+	KindNotFound  = "not_found"
+	KindTimeout   = "timeout"
+	KindServer    = "server"
+	KindForbidden = "forbidden"
+)
+
+// Kinds lists the error kinds.
+var Kinds = []string{KindNotFound, KindTimeout, KindServer, KindForbidden}
+
+// Address families which can be preferred
+const (
+	PreferIPv4 = "ipv4"
+	PreferIPv6 = "ipv6"
+)
+
+// DefaultFallbackDelay is the fallback delay used when none is set, as in
+// net.Dialer.
+const DefaultFallbackDelay = 300 * time.Millisecond
+
+// Options of a resolver
+type Options struct {
+	// Servers are the DNS servers, tried in turn. The system resolver is
+	// used if there are none.
+	Servers []Server
+	// Timeout is the time allowed to a server to answer.
+	Timeout time.Duration
+	// Prefer is the address family dialed first, PreferIPv4 or PreferIPv6.
+	// If unset it is the family of the first resolved address.
+	Prefer string
+	// FallbackDelay is the time the preferred family is dialed alone.
+	FallbackDelay time.Duration
+	// CacheSize is the maximum number of cached answers of the servers,
+	// the cache is disabled if it is 0.
+	CacheSize int
+	// MinTTL and MaxTTL bound the time answers are cached, MaxTTL is not
+	// enforced if it is 0.
+	MinTTL, MaxTTL time.Duration
+	// NegativeTTL is the time negative answers are cached when their
+	// server does not tell.
+	NegativeTTL time.Duration
+	// DialTimeout is the time allowed to a dial, resolution included.
+	DialTimeout time.Duration
+	// Allow returns whether a resolved address can be dialed, every
+	// address can if it is nil.
+	Allow func(net.IP) bool
+	// HTTPClient sends the DNS-over-HTTPS queries, http.DefaultClient is
+	// used if it is nil.
+	HTTPClient *http.Client
+}
+
+// Cache key
+type key struct {
+	name string
+	typ  dnsmessage.Type
+}
+
+// Cached answer
+type entry struct {
+	answer
+	expires time.Time
+}
+
+// T is a resolver
+type T struct {
+	o Options
+
+	mu    sync.Mutex
+	cache map[key]entry
+
+	queries, hits uint64
+	// errors, by index in Kinds
+	errs []uint64
+
+	// overridable by tests
+	now  func() time.Time
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// New returns a resolver.
+func New(o Options) *T {
+	if o.FallbackDelay == 0 {
+		o.FallbackDelay = DefaultFallbackDelay
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	return &T{
+		o:     o,
+		cache: map[key]entry{},
+		errs:  make([]uint64, len(Kinds)),
+		now:   time.Now,
+		dial:  (&net.Dialer{}).DialContext,
+	}
+}
+
+// Lookup returns the addresses of host.
+func (r *T) Lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	var (
+		ips []net.IP
+		err error
+	)
+	if len(r.o.Servers) == 0 {
+		ips, err = r.lookupSystem(ctx, host)
+	} else {
+		ips, err = r.lookupServers(ctx, host)
+	}
+	if err != nil {
+		r.fail(err)
+	}
+	return ips, err
+}
+
+// Resolves host with the system resolver
+func (r *T) lookupSystem(ctx context.Context, host string) ([]net.IP, error) {
+	atomic.AddUint64(&r.queries, 1)
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, dnsErr.Err)
+		case isTimeout(err):
+			return nil, fmt.Errorf("%w: %s", ErrTimeout, err)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrServer, err)
+		}
+	}
+
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// Resolves host with the servers, IPv6 addresses first
+func (r *T) lookupServers(ctx context.Context, host string) ([]net.IP, error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+
+	type result struct {
+		answer
+		err error
+	}
+	var a, aaaa result
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.answer, a.err = r.query(ctx, host, dnsmessage.TypeA)
+	}()
+	go func() {
+		defer wg.Done()
+		aaaa.answer, aaaa.err = r.query(ctx, host, dnsmessage.TypeAAAA)
+	}()
+	wg.Wait()
+
+	// the answers may be shared with the cache
+	ips := make([]net.IP, 0, len(a.ips)+len(aaaa.ips))
+	ips = append(append(ips, aaaa.ips...), a.ips...)
+	switch {
+	case len(ips) > 0:
+		return ips, nil
+	case a.nxdomain || aaaa.nxdomain:
+		return nil, fmt.Errorf("%w: no such domain", ErrNotFound)
+	case a.err != nil:
+		return nil, a.err
+	case aaaa.err != nil:
+		return nil, aaaa.err
+	default:
+		return nil, fmt.Errorf("%w: no addresses", ErrNotFound)
+	}
+}
+
+// Answers a question from the cache or the servers
+func (r *T) query(ctx context.Context, name string, typ dnsmessage.Type) (answer, error) {
+	k := key{name, typ}
+	r.mu.Lock()
+	e, ok := r.cache[k]
+	if ok && r.now().Before(e.expires) {
+		r.mu.Unlock()
+		atomic.AddUint64(&r.hits, 1)
+		return e.answer, nil
+	}
+	r.mu.Unlock()
+
+	atomic.AddUint64(&r.queries, 1)
+
+	var err error
+	for _, s := range r.o.Servers {
+		qctx, cancel := ctx, context.CancelFunc(func() {})
+		if r.o.Timeout > 0 {
+			qctx, cancel = context.WithTimeout(ctx, r.o.Timeout)
+		}
+		var a answer
+		a, err = s.exchange(qctx, r.o.HTTPClient, name, typ)
+		cancel()
+
+		if err == nil {
+			r.store(k, a)
+			return a, nil
+		}
+		err = fmt.Errorf("%s: %w", s, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if isTimeout(err) {
+		return answer{}, fmt.Errorf("%w: %s", ErrTimeout, err)
+	}
+	return answer{}, fmt.Errorf("%w: %s", ErrServer, err)
+}
+
+// Caches an answer for its TTL within the bounds
+func (r *T) store(k key, a answer) {
+	ttl := a.ttl
+	if len(a.ips) == 0 && ttl == 0 {
+		ttl = r.o.NegativeTTL
+	}
+	if ttl < r.o.MinTTL {
+		ttl = r.o.MinTTL
+	}
+	if r.o.MaxTTL > 0 && ttl > r.o.MaxTTL {
+		ttl = r.o.MaxTTL
+	}
+	if r.o.CacheSize <= 0 || ttl <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if len(r.cache) >= r.o.CacheSize {
+		for k, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	// still full, any entry goes
+	for k := range r.cache {
+		if len(r.cache) < r.o.CacheSize {
+			break
+		}
+		delete(r.cache, k)
+	}
+	r.cache[k] = entry{answer: a, expires: now.Add(ttl)}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// Counts an error by kind
+func (r *T) fail(err error) {
+	for i, e := range []error{ErrNotFound, ErrTimeout, ErrServer, ErrForbidden} {
+		if errors.Is(err, e) {
+			atomic.AddUint64(&r.errs[i], 1)
+		}
+	}
+}
+
+// Dial resolves the host of address and dials its allowed addresses,
+// those of the preferred family first.
+func (r *T) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.o.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.o.DialTimeout)
+		defer cancel()
+	}
+
+	ips, err := r.Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []net.IP
+	for _, ip := range ips {
+		if r.o.Allow == nil || r.o.Allow(ip) {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		err = fmt.Errorf("%w: refusing to dial", ErrForbidden)
+		r.fail(err)
+		return nil, err
+	}
+
+	primaries, fallbacks := partition(allowed, r.o.Prefer)
+	return r.dialParallel(ctx, network, port, primaries, fallbacks)
+}
+
+// Splits the addresses between the preferred family and the other
+func partition(ips []net.IP, prefer string) (primaries, fallbacks []net.IP) {
+	v4 := ips[0].To4() != nil
+	switch prefer {
+	case PreferIPv4:
+		v4 = true
+	case PreferIPv6:
+		v4 = false
+	}
+
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(primaries) == 0 {
+		primaries, fallbacks = fallbacks, nil
+	}
+	return
+}
+
+// Dials the primaries, and the fallbacks once the primaries failed or
+// after the fallback delay
+func (r *T) dialParallel(ctx context.Context, network, port string, primaries, fallbacks []net.IP) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return r.dialSerial(ctx, network, port, primaries)
+	}
+
+	type result struct {
+		c       net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan result)
+	returned := make(chan struct{})
+	defer close(returned)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	race := func(ips []net.IP, primary bool) {
+		c, err := r.dialSerial(ctx, network, port, ips)
+		select {
+		case results <- result{c, err, primary}:
+		case <-returned:
+			if c != nil {
+				c.Close()
+			}
+		}
+	}
+	go race(primaries, true)
+
+	t := time.NewTimer(r.o.FallbackDelay)
+	defer t.Stop()
+
+	var (
+		perr    error
+		pending = 1
+		started bool
+	)
+	for {
+		select {
+		case <-t.C:
+			go race(fallbacks, false)
+			pending, started = pending+1, true
+		case res := <-results:
+			if res.err == nil {
+				return res.c, nil
+			}
+			pending--
+			if res.primary {
+				perr = res.err
+			} else if perr == nil {
+				perr = res.err
+			}
+
+			if !started {
+				// no need to wait any longer
+				t.Stop()
+				go race(fallbacks, false)
+				pending, started = pending+1, true
+			} else if pending == 0 {
+				return nil, perr
+			}
+		}
+	}
+}
+
+// Dials the addresses in turn
+func (r *T) dialSerial(ctx context.Context, network, port string, ips []net.IP) (c net.Conn, err error) {
+	var first error
+	for _, ip := range ips {
+		if c, err = r.dial(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return
+		}
+		if first == nil {
+			first = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, first
+}
+
+// Stats are the resolver statistics, counters are totals since the start
+type Stats struct {
+	// Queries are the questions sent to the servers or the system resolver.
+	Queries      uint64            `json:"queries"`
+	CacheHits    uint64            `json:"cache_hits"`
+	CacheEntries int               `json:"cache_entries"`
+	Errors       map[string]uint64 `json:"errors"`
+}
+
+// Stats returns the resolver statistics.
+func (r *T) Stats() (s Stats) {
+	r.mu.Lock()
+	s.CacheEntries = len(r.cache)
+	r.mu.Unlock()
+
+	s.Queries, s.CacheHits = atomic.LoadUint64(&r.queries), atomic.LoadUint64(&r.hits)
+	s.Errors = make(map[string]uint64, len(Kinds))
+	for i, k := range Kinds {
+		s.Errors[k] = atomic.LoadUint64(&r.errs[i])
+	}
+	return
+}
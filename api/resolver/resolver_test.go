@@ -0,0 +1,336 @@
+// Copyright (c) 2022 Wireleap
+
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Answers a question with a response code and addresses
+type handler func(q dnsmessage.Question) (dnsmessage.RCode, []net.IP)
+
+// Builds the answer to a query, truncated to no records if trunc is set
+func reply(t *testing.T, h handler, b []byte, trunc bool) []byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	q := m.Questions[0]
+	rcode, ips := h(q)
+	res := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: m.ID, Response: true, RCode: rcode, Truncated: trunc},
+		Questions: m.Questions,
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+
+	if rcode == dnsmessage.RCodeNameError {
+		rh.Type = dnsmessage.TypeSOA
+		res.Authorities = append(res.Authorities, dnsmessage.Resource{Header: rh, Body: &dnsmessage.SOAResource{
+			NS: q.Name, MBox: q.Name, MinTTL: 30,
+		}})
+	}
+	for _, ip := range ips {
+		switch {
+		case trunc:
+		case ip.To4() != nil && q.Type == dnsmessage.TypeA:
+			r := &dnsmessage.AResource{}
+			copy(r.A[:], ip.To4())
+			res.Answers = append(res.Answers, dnsmessage.Resource{Header: rh, Body: r})
+		case ip.To4() == nil && q.Type == dnsmessage.TypeAAAA:
+			r := &dnsmessage.AAAAResource{}
+			copy(r.AAAA[:], ip)
+			res.Answers = append(res.Answers, dnsmessage.Resource{Header: rh, Body: r})
+		}
+	}
+
+	out, err := res.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return out
+}
+
+// Serves h over UDP and TCP on the same port, UDP answers are truncated
+// if trunc is set. The number of queries is counted in n.
+func serveDNS(t *testing.T, h handler, trunc bool, n *uint64) Server {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close(); l.Close() })
+
+	go func() {
+		b := make([]byte, maxMessageLen)
+		for {
+			k, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			atomic.AddUint64(n, 1)
+			pc.WriteTo(reply(t, h, b[:k], trunc), addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+
+				var lb [2]byte
+				if _, err := io.ReadFull(c, lb[:]); err != nil {
+					return
+				}
+				b := make([]byte, binary.BigEndian.Uint16(lb[:]))
+				if _, err := io.ReadFull(c, b); err != nil {
+					return
+				}
+				atomic.AddUint64(n, 1)
+
+				out := reply(t, h, b, false)
+				binary.BigEndian.PutUint16(lb[:], uint16(len(out)))
+				c.Write(append(lb[:], out...))
+			}()
+		}
+	}()
+	return Server{Network: "udp", Addr: pc.LocalAddr().String()}
+}
+
+var (
+	ip4 = net.ParseIP("192.0.2.1")
+	ip6 = net.ParseIP("2001:db8::1")
+)
+
+func example(q dnsmessage.Question) (dnsmessage.RCode, []net.IP) {
+	if q.Name.String() != "example.org." {
+		return dnsmessage.RCodeNameError, nil
+	}
+	return dnsmessage.RCodeSuccess, []net.IP{ip4, ip6}
+}
+
+func TestParseServer(t *testing.T) {
+	for s, exp := range map[string]Server{
+		"udp://192.0.2.53":                     {"udp", "192.0.2.53:53"},
+		"tcp://[2001:db8::53]:5353":            {"tcp", "[2001:db8::53]:5353"},
+		"https://dns.example.org/dns-query":    {"https", "https://dns.example.org/dns-query"},
+		"https://dns.example.org:8443/resolve": {"https", "https://dns.example.org:8443/resolve"},
+	} {
+		if s2, err := ParseServer(s); err != nil {
+			t.Error(err)
+		} else if s2 != exp {
+			t.Errorf("%s: expected %+v, got %+v", s, exp, s2)
+		}
+	}
+
+	for _, s := range []string{"192.0.2.53", "tls://192.0.2.53", "udp://192.0.2.53/path", "https://"} {
+		if _, err := ParseServer(s); err == nil {
+			t.Errorf("%s should fail to parse", s)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	var n uint64
+	s := serveDNS(t, example, false, &n)
+
+	r := New(Options{Servers: []Server{s}, Timeout: time.Second, CacheSize: 16})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	ips, err := r.Lookup(context.Background(), "example.org")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ips) != 2 || !ips[0].Equal(ip6) || !ips[1].Equal(ip4) {
+		t.Fatalf("unexpected addresses %v", ips)
+	}
+
+	// answered from the cache until the TTL expires
+	r.Lookup(context.Background(), "example.org")
+	if got := atomic.LoadUint64(&n); got != 2 {
+		t.Fatalf("expected 2 queries, got %d", got)
+	}
+	now = now.Add(time.Minute)
+	r.Lookup(context.Background(), "example.org")
+	if got := atomic.LoadUint64(&n); got != 4 {
+		t.Fatalf("expired answers should be queried again, got %d queries", got)
+	}
+
+	// negative answers are cached for the SOA minimum
+	if _, err = r.Lookup(context.Background(), "missing.example.org"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	r.Lookup(context.Background(), "missing.example.org")
+	if got := atomic.LoadUint64(&n); got != 6 {
+		t.Fatalf("negative answers should be cached, got %d queries", got)
+	}
+
+	st := r.Stats()
+	if st.Queries != 6 || st.CacheHits != 4 || st.CacheEntries != 4 || st.Errors[KindNotFound] != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var n uint64
+	s := serveDNS(t, example, true, &n)
+
+	ips, err := New(Options{Servers: []Server{s}, Timeout: time.Second}).Lookup(context.Background(), "example.org")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ips) != 2 {
+		t.Fatalf("truncated answers should be queried over TCP, got %v", ips)
+	} else if got := atomic.LoadUint64(&n); got != 4 {
+		t.Fatalf("expected 4 queries, got %d", got)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	var n1, n2 uint64
+	failing := serveDNS(t, func(dnsmessage.Question) (dnsmessage.RCode, []net.IP) {
+		return dnsmessage.RCodeServerFailure, nil
+	}, false, &n1)
+	ok := serveDNS(t, example, false, &n2)
+	ok.Network = "tcp"
+
+	if _, err := New(Options{Servers: []Server{failing, ok}}).Lookup(context.Background(), "example.org"); err != nil {
+		t.Fatalf("the next server should be queried: %s", err)
+	}
+
+	r := New(Options{Servers: []Server{failing}})
+	if _, err := r.Lookup(context.Background(), "example.org"); !errors.Is(err, ErrServer) {
+		t.Fatalf("expected server error, got %v", err)
+	}
+
+	// a server which never answers
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	r = New(Options{Servers: []Server{{"udp", pc.LocalAddr().String()}}, Timeout: 20 * time.Millisecond})
+	if _, err = r.Lookup(context.Background(), "example.org"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if st := r.Stats(); st.Errors[KindTimeout] != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/dns-message" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(reply(t, example, b, false))
+	}))
+	defer srv.Close()
+
+	s, err := ParseServer(srv.URL + "/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ips, err := New(Options{Servers: []Server{s}, HTTPClient: srv.Client()}).Lookup(context.Background(), "example.org")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ips) != 2 {
+		t.Fatalf("unexpected addresses %v", ips)
+	}
+}
+
+// Dial function failing or blocking for some addresses
+type fakeDialer map[string]error
+
+var errBlock = errors.New("block")
+
+func (f fakeDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch err := f[addr]; err {
+	case nil:
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	case errBlock:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, err
+	}
+}
+
+func TestDial(t *testing.T) {
+	var n uint64
+	s := serveDNS(t, example, false, &n)
+
+	dial := func(o Options, f fakeDialer) (time.Duration, error) {
+		o.Servers = []Server{s}
+		r := New(o)
+		r.dial = f.dial
+
+		start := time.Now()
+		c, err := r.Dial(context.Background(), "tcp", "example.org:80")
+		if c != nil {
+			c.Close()
+		}
+		return time.Since(start), err
+	}
+
+	v6 := net.JoinHostPort(ip6.String(), "80")
+	v4 := net.JoinHostPort(ip4.String(), "80")
+
+	// the fallback is dialed after the delay
+	o := Options{FallbackDelay: 50 * time.Millisecond, DialTimeout: time.Second}
+	if d, err := dial(o, fakeDialer{v6: errBlock}); err != nil {
+		t.Fatal(err)
+	} else if d < 50*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("fallback dialed after %s", d)
+	}
+
+	// or as soon as the preferred family failed
+	o.FallbackDelay = time.Second
+	if d, err := dial(o, fakeDialer{v6: errors.New("unreachable")}); err != nil {
+		t.Fatal(err)
+	} else if d > 500*time.Millisecond {
+		t.Fatalf("fallback dialed after %s", d)
+	}
+
+	// the preferred family goes first
+	o.Prefer, o.DialTimeout = PreferIPv4, 100*time.Millisecond
+	if _, err := dial(o, fakeDialer{v6: errors.New("unreachable"), v4: errBlock}); err == nil {
+		t.Fatal("IPv4 should be dialed first")
+	}
+
+	// addresses are filtered
+	o = Options{Allow: func(ip net.IP) bool { return ip.To4() != nil }}
+	if _, err := dial(o, fakeDialer{v6: errors.New("unreachable")}); err != nil {
+		t.Fatal(err)
+	}
+	o.Allow = func(net.IP) bool { return false }
+	if _, err := dial(o, fakeDialer{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden error, got %v", err)
+	}
+}
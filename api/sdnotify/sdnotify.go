@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Wireleap
+
+// Package sdnotify implements the systemd service notification protocol,
+// see sd_notify(3). All the functions are no-ops when the process is not
+// supervised by systemd.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends a state notification to the service manager, sent is false
+// if $NOTIFY_SOCKET is not set.
+func Notify(state string) (sent bool, err error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+
+	if addr[0] == '@' {
+		// abstract namespace socket
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured by the service
+// manager, ok is false if the watchdog is disabled for this process.
+// Notifications should be sent at half the returned interval.
+func WatchdogInterval() (d time.Duration, ok bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	if s := os.Getenv("WATCHDOG_PID"); s != "" {
+		if pid, err := strconv.Atoi(s); err != nil || pid != os.Getpid() {
+			return 0, false
+		}
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Wireleap
+
+package sdnotify
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatal("notify without socket should be a no-op")
+	}
+
+	tmpd, err := ioutil.TempDir("", "wlrelay-sdnotify.*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpd)
+
+	path := filepath.Join(tmpd, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("could not notify: %v", err)
+	}
+
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b[:n]) != Ready {
+		t.Fatalf("unexpected notification %q", b[:n])
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	if d, ok := WatchdogInterval(); !ok || d != 30*time.Second {
+		t.Fatalf("unexpected watchdog interval %s", d)
+	}
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+
+	if _, ok := WatchdogInterval(); ok {
+		t.Fatal("watchdog for another pid should be disabled")
+	}
+}
@@ -17,6 +17,9 @@ type ConnCounter struct {
 	in     uint64
 	out    uint64
 	parent *ContractCounter
+	// stripe holding the counter and position in it, guarded by the stripe
+	st   *stripe
+	slot int
 }
 
 // NewConnCounter returns a new counter for a connection
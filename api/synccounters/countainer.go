@@ -4,69 +4,109 @@ package synccounters
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
-type container struct {
+// CNTSTRIPES is the number of stripes of a container
+const CNTSTRIPES = 16
+
+// stripe is a locked slice of children, children know their slot so they
+// can be removed without a scan
+type stripe struct {
 	cts []*ConnCounter
 	mu  sync.RWMutex
 }
 
-func newContainer(size int) *container {
-	return &container{
-		cts: make([]*ConnCounter, 0, size),
-	}
-}
-
-func (cnt *container) _delete(i int) (res bool) {
-	if res = cnt.cts != nil; !res {
+func (st *stripe) _delete(i int) (res bool) {
+	if res = st.cts != nil; !res {
 		// pass
-	} else if l := len(cnt.cts); i < l {
-		cnt.cts[i] = cnt.cts[l-1]
-		cnt.cts = cnt.cts[:l-1]
+	} else if l := len(st.cts); i < l {
+		st.cts[i] = st.cts[l-1]
+		st.cts[i].slot = i
+		st.cts[l-1] = nil
+		st.cts = st.cts[:l-1]
 	} else {
 		res = false
 	}
 	return
 }
 
-func (cnt *container) delete(cc *ConnCounter) (value *ConnCounter, ok bool) {
-	cnt.mu.Lock()
-	defer cnt.mu.Unlock()
+func (st *stripe) delete(cc *ConnCounter) (value *ConnCounter, ok bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
-	for i, t := range cnt.cts {
-		if t == cc {
-			value, ok = t, cnt._delete(i)
-			break
-		}
+	if cc.slot < len(st.cts) && st.cts[cc.slot] == cc {
+		value, ok = cc, st._delete(cc.slot)
 	}
+	return
+}
+
+// container spreads children over stripes, so that connections opening and
+// closing only contend within their stripe
+type container struct {
+	stripes []stripe
+	next    uint32
+}
 
+func newContainer(size int) *container {
+	cnt := &container{stripes: make([]stripe, CNTSTRIPES)}
+	for i := range cnt.stripes {
+		cnt.stripes[i].cts = make([]*ConnCounter, 0, size/CNTSTRIPES+1)
+	}
+	return cnt
+}
+
+func (cnt *container) len() (n int) {
+	for i := range cnt.stripes {
+		st := &cnt.stripes[i]
+		st.mu.RLock()
+		n += len(st.cts)
+		st.mu.RUnlock()
+	}
 	return
 }
 
+func (cnt *container) delete(cc *ConnCounter) (value *ConnCounter, ok bool) {
+	if cc.st == nil {
+		return
+	}
+	return cc.st.delete(cc)
+}
+
 func (cnt *container) readLoop(fn func(int, *ConnCounter) bool) (value *ConnCounter, interrupt bool) {
 	var i int
 
-	cnt.mu.RLock()
-	defer cnt.mu.RUnlock()
+	for j := range cnt.stripes {
+		st := &cnt.stripes[j]
+
+		st.mu.RLock()
+		for _, value = range st.cts {
+			if interrupt = fn(i, value); interrupt {
+				break
+			}
+			i++
+		}
+		st.mu.RUnlock()
 
-	for i, value = range cnt.cts {
-		if interrupt = fn(i, value); interrupt {
-			break
+		if interrupt {
+			return
 		}
 	}
 	return
 }
 
 func (cnt *container) create(parent *ContractCounter) (child *ConnCounter) {
-	if cnt.cts == nil {
+	if cnt.stripes == nil {
 		return
 	}
 
 	child = NewConnCounter(parent)
+	st := &cnt.stripes[atomic.AddUint32(&cnt.next, 1)%uint32(len(cnt.stripes))]
 
-	cnt.mu.Lock()
-	defer cnt.mu.Unlock()
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
-	cnt.cts = append(cnt.cts, child)
+	child.st, child.slot = st, len(st.cts)
+	st.cts = append(st.cts, child)
 	return
 }
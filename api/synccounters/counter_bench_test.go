@@ -0,0 +1,127 @@
+// Copyright (c) 2022 Wireleap
+
+package synccounters
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// listContainer is the previous single slice container, kept as a baseline
+type listContainer struct {
+	cts []*ConnCounter
+	mu  sync.RWMutex
+}
+
+func (cnt *listContainer) create() (child *ConnCounter) {
+	child = NewConnCounter(nil)
+
+	cnt.mu.Lock()
+	defer cnt.mu.Unlock()
+
+	cnt.cts = append(cnt.cts, child)
+	return
+}
+
+func (cnt *listContainer) delete(cc *ConnCounter) {
+	cnt.mu.Lock()
+	defer cnt.mu.Unlock()
+
+	for i, t := range cnt.cts {
+		if t == cc {
+			l := len(cnt.cts)
+			cnt.cts[i] = cnt.cts[l-1]
+			cnt.cts = cnt.cts[:l-1]
+			break
+		}
+	}
+}
+
+func (cnt *listContainer) sum() uint64 {
+	fn, sum := containerSum()
+
+	cnt.mu.RLock()
+	defer cnt.mu.RUnlock()
+
+	for i, cc := range cnt.cts {
+		fn(i, cc)
+	}
+	return *sum
+}
+
+var tunnels = []int{100, 10000}
+
+// Tunnels opening and closing while others are open
+func BenchmarkChurn(b *testing.B) {
+	for _, n := range tunnels {
+		b.Run(fmt.Sprintf("list/tunnels=%d", n), func(b *testing.B) {
+			cnt := &listContainer{}
+			for i := 0; i < n; i++ {
+				cnt.create()
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cnt.delete(cnt.create())
+				}
+			})
+		})
+
+		b.Run(fmt.Sprintf("striped/tunnels=%d", n), func(b *testing.B) {
+			ctc := NewContractCounter()
+			for i := 0; i < n; i++ {
+				ctc.NewChild()
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					ctc.NewChild().Close()
+				}
+			})
+		})
+	}
+}
+
+// Usage being read while tunnels open and close
+func BenchmarkSum(b *testing.B) {
+	for _, n := range tunnels {
+		b.Run(fmt.Sprintf("list/tunnels=%d", n), func(b *testing.B) {
+			cnt := &listContainer{}
+			for i := 0; i < n; i++ {
+				cnt.create()
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%10 == 0 {
+						cnt.sum()
+					} else {
+						cnt.delete(cnt.create())
+					}
+				}
+			})
+		})
+
+		b.Run(fmt.Sprintf("striped/tunnels=%d", n), func(b *testing.B) {
+			ctc := NewContractCounter()
+			for i := 0; i < n; i++ {
+				ctc.NewChild()
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%10 == 0 {
+						ctc.Sum()
+					} else {
+						ctc.NewChild().Close()
+					}
+				}
+			})
+		})
+	}
+}
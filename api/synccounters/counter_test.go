@@ -41,7 +41,7 @@ func TestCounter(t *testing.T) {
 	counter := NewContractCounter()
 
 	// Countainer length
-	assertEquals(t, 0, len(counter.cnt.cts), "Container length must be 0")
+	assertEquals(t, 0, counter.cnt.len(), "Container length must be 0")
 
 	// Init & Sum
 	assertEquals(t, uint64(0), counter.Sum(), "Sum() must be 0")
@@ -72,7 +72,7 @@ func TestConnCounter(t *testing.T) {
 	child := counter.NewChild()
 
 	// Countainer length
-	assertEquals(t, 1, len(counter.cnt.cts), "Container length must be 1")
+	assertEquals(t, 1, counter.cnt.len(), "Container length must be 1")
 
 	// Child Sum
 	assertEquals(t, uint64(0), child.Sum(), "Sum() must be 0")
@@ -109,7 +109,7 @@ func TestConnCounter(t *testing.T) {
 	assertEqualErrs(t, nil, child.Close(), "Close shouldn't return an error")
 
 	// Countainer length
-	assertEquals(t, 0, len(counter.cnt.cts), "Container length must be 0")
+	assertEquals(t, 0, counter.cnt.len(), "Container length must be 0")
 
 	// Parent Sum
 	assertEquals(t, uint64(5), counter.Sum(), "Sum() must be 5")
@@ -151,15 +151,17 @@ func TestContainerInterrupt(t *testing.T) {
 
 func TestContainerOutOfBounds(t *testing.T) {
 	cnt := newContainer(1)
-	cnt.create(nil)
+	child := cnt.create(nil)
 
-	assertEquals(t, false, cnt._delete(2), "_delete must break")
+	// past the children of the stripe holding the counter
+	assertEquals(t, false, child.st._delete(len(child.st.cts)), "_delete must break")
+	assertEquals(t, 1, cnt.len(), "counter must be kept")
 }
 
 func TestContainerNotInit(t *testing.T) {
 	cnt := container{}
 
-	assertEquals(t, false, cnt._delete(0), "_delete must break")
+	assertEquals(t, false, (&stripe{})._delete(0), "_delete must break")
 
 	child := cnt.create(nil)
 	assertEquals(t, nil, child, "create must break")
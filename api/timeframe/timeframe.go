@@ -0,0 +1,212 @@
+// Copyright (c) 2022 Wireleap
+
+// Package timeframe describes network usage periods, either a fixed
+// duration or a calendar schedule, and daily time windows.
+package timeframe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// embed the timezone database for hosts missing it
+	_ "time/tzdata"
+
+	"github.com/wireleap/common/api/duration"
+)
+
+// Calendar schedule periods
+const (
+	Monthly = "month"
+	Weekly  = "week"
+	Daily   = "day"
+)
+
+// T is a network usage period, rolled forward from the period start by
+// Duration, or aligned to the Calendar schedule if set.
+type T struct {
+	Duration time.Duration
+	Calendar *Calendar
+}
+
+// IsZero returns if no period is defined
+func (t T) IsZero() bool {
+	return t.Duration == 0 && t.Calendar == nil
+}
+
+// UnmarshalJSON accepts a duration ("30d") or a calendar schedule object.
+func (t *T) UnmarshalJSON(b []byte) error {
+	var d duration.T
+	if err := json.Unmarshal(b, &d); err == nil {
+		*t = T{Duration: time.Duration(d)}
+		return nil
+	}
+
+	c := &Calendar{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("invalid timeframe %s: expected a duration or a calendar schedule", b)
+	}
+	*t = T{Calendar: c}
+	return nil
+}
+
+func (t T) MarshalJSON() ([]byte, error) {
+	if t.Calendar != nil {
+		return json.Marshal(t.Calendar)
+	}
+	return json.Marshal(duration.T(t.Duration))
+}
+
+// Validate the period
+func (t *T) Validate() error {
+	if t.Calendar != nil {
+		return t.Calendar.Validate()
+	} else if t.Duration < 0 {
+		return errors.New("duration cannot be negative")
+	}
+	return nil
+}
+
+func (t T) String() string {
+	if t.Calendar != nil {
+		return t.Calendar.String()
+	}
+	return t.Duration.String()
+}
+
+// Calendar schedule, resetting every month on Day, every week on Weekday or
+// every day, at Time in Timezone.
+type Calendar struct {
+	// Every is one of month, week or day.
+	Every string `json:"every"`
+	// Day of the month, 1-31, the last day of shorter months is used.
+	Day int `json:"day,omitempty"`
+	// Weekday, "monday" to "sunday".
+	Weekday string `json:"weekday,omitempty"`
+	// Time of the day, "HH:MM" (default: "00:00").
+	Time string `json:"time,omitempty"`
+	// Timezone is an IANA timezone name (default: "UTC").
+	Timezone string `json:"timezone,omitempty"`
+
+	loc          *time.Location
+	weekday      time.Weekday
+	hour, minute int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func (c *Calendar) UnmarshalJSON(b []byte) error {
+	type calendar Calendar
+	if err := json.Unmarshal(b, (*calendar)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate the schedule, it has to be called before using it
+func (c *Calendar) Validate() (err error) {
+	switch c.Every {
+	case Monthly:
+		if c.Day < 1 || c.Day > 31 {
+			return fmt.Errorf("'day' %d has to be in [1, 31]", c.Day)
+		} else if c.Weekday != "" {
+			return errors.New("'weekday' is only valid weekly")
+		}
+	case Weekly:
+		var ok bool
+		if c.weekday, ok = weekdays[strings.ToLower(c.Weekday)]; !ok {
+			return fmt.Errorf("unknown 'weekday' %q", c.Weekday)
+		} else if c.Day != 0 {
+			return errors.New("'day' is only valid monthly")
+		}
+	case Daily:
+		if c.Day != 0 || c.Weekday != "" {
+			return errors.New("'day' and 'weekday' are not valid daily")
+		}
+	default:
+		return fmt.Errorf("unknown 'every' %q, expected %s, %s or %s", c.Every, Monthly, Weekly, Daily)
+	}
+
+	c.hour, c.minute = 0, 0
+	if c.Time != "" {
+		var hm time.Time
+		if hm, err = time.Parse("15:04", c.Time); err != nil {
+			return fmt.Errorf("invalid 'time' %q, expected HH:MM", c.Time)
+		}
+		c.hour, c.minute = hm.Hour(), hm.Minute()
+	}
+
+	if c.loc, err = time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("invalid 'timezone' %q: %w", c.Timezone, err)
+	}
+	return nil
+}
+
+// Next returns the first period boundary strictly after t
+func (c *Calendar) Next(t time.Time) time.Time {
+	lt := t.In(c.loc)
+	y, m, d := lt.Date()
+
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, c.hour, c.minute, 0, 0, c.loc)
+	}
+
+	switch c.Every {
+	case Monthly:
+		for i := 0; ; i++ {
+			// time.Date normalizes overflowing months
+			first := at(y, m+time.Month(i), 1)
+			day := c.Day
+			if last := daysIn(first.Year(), first.Month()); day > last {
+				day = last
+			}
+			if n := at(first.Year(), first.Month(), day); n.After(t) {
+				return n
+			}
+		}
+	case Weekly:
+		d += (int(c.weekday) - int(lt.Weekday()) + 7) % 7
+		if n := at(y, m, d); n.After(t) {
+			return n
+		}
+		return at(y, m, d+7)
+	default:
+		if n := at(y, m, d); n.After(t) {
+			return n
+		}
+		return at(y, m, d+1)
+	}
+}
+
+func (c *Calendar) String() string {
+	tm, tz := c.Time, c.Timezone
+	if tm == "" {
+		tm = "00:00"
+	}
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	switch c.Every {
+	case Monthly:
+		return fmt.Sprintf("monthly on day %d at %s %s", c.Day, tm, tz)
+	case Weekly:
+		return fmt.Sprintf("weekly on %s at %s %s", c.Weekday, tm, tz)
+	}
+	return fmt.Sprintf("daily at %s %s", tm, tz)
+}
+
+// Returns the number of days of a month
+func daysIn(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
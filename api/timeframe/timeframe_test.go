@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Wireleap
+
+package timeframe
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, s string) T {
+	var tf T
+	if err := json.Unmarshal([]byte(s), &tf); err != nil {
+		t.Fatal(err)
+	}
+	return tf
+}
+
+func date(t *testing.T, s string) time.Time {
+	d, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestUnmarshal(t *testing.T) {
+	if tf := parse(t, `"30d"`); tf.Duration != 30*24*time.Hour || tf.Calendar != nil {
+		t.Fatalf("wrong duration timeframe %+v", tf)
+	}
+
+	if tf := parse(t, `{"every": "week", "weekday": "Monday"}`); tf.Calendar == nil {
+		t.Fatal("calendar timeframe expected")
+	}
+
+	for _, s := range []string{
+		`{"every": "year"}`,
+		`{"every": "month"}`,
+		`{"every": "month", "day": 32}`,
+		`{"every": "week", "weekday": "someday"}`,
+		`{"every": "day", "day": 1}`,
+		`{"every": "day", "time": "25:00"}`,
+		`{"every": "day", "timezone": "Mars/Olympus_Mons"}`,
+	} {
+		var tf T
+		if err := json.Unmarshal([]byte(s), &tf); err == nil {
+			t.Fatalf("%s should fail to validate", s)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	for _, c := range []struct {
+		tf, from, next string
+	}{
+		// monthly, shorter months use their last day
+		{`{"every": "month", "day": 31}`, "2022-01-31T00:00:00Z", "2022-02-28T00:00:00Z"},
+		{`{"every": "month", "day": 31}`, "2022-02-28T00:00:00Z", "2022-03-31T00:00:00Z"},
+		{`{"every": "month", "day": 1}`, "2022-12-15T10:00:00Z", "2023-01-01T00:00:00Z"},
+		// boundaries are exclusive
+		{`{"every": "month", "day": 15, "time": "12:00"}`, "2022-03-15T11:59:00Z", "2022-03-15T12:00:00Z"},
+		{`{"every": "month", "day": 15, "time": "12:00"}`, "2022-03-15T12:00:00Z", "2022-04-15T12:00:00Z"},
+		// weekly
+		{`{"every": "week", "weekday": "monday"}`, "2022-08-31T00:00:00Z", "2022-09-05T00:00:00Z"},
+		{`{"every": "week", "weekday": "wednesday", "time": "08:00"}`, "2022-08-31T08:00:00Z", "2022-09-07T08:00:00Z"},
+		// daily in a timezone
+		{`{"every": "day", "time": "06:30", "timezone": "Europe/Paris"}`, "2022-08-31T05:00:00Z", "2022-09-01T04:30:00Z"},
+		// across DST changes, local time is kept
+		{`{"every": "day", "timezone": "Europe/Paris"}`, "2022-03-26T23:00:00Z", "2022-03-27T22:00:00Z"},
+		{`{"every": "month", "day": 1, "timezone": "America/New_York"}`, "2022-10-01T04:00:00Z", "2022-11-01T04:00:00Z"},
+		{`{"every": "month", "day": 1, "timezone": "America/New_York"}`, "2022-11-01T04:00:00Z", "2022-12-01T05:00:00Z"},
+	} {
+		tf := parse(t, c.tf)
+		if n := tf.Calendar.Next(date(t, c.from)); !n.Equal(date(t, c.next)) {
+			t.Fatalf("%s from %s: expected %s, got %s", c.tf, c.from, c.next, n.UTC())
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := Window{Start: "22:00", End: "06:00", Weekdays: []string{"friday"}, Timezone: "Europe/Paris"}
+	if err := w.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for s, exp := range map[string]bool{
+		// friday 21:59 and 22:00 Paris time (UTC+2)
+		"2022-09-02T19:59:00Z": false,
+		"2022-09-02T20:00:00Z": true,
+		// saturday morning, window started on friday
+		"2022-09-03T03:59:00Z": true,
+		"2022-09-03T04:00:00Z": false,
+		// saturday night
+		"2022-09-03T21:00:00Z": false,
+		// thursday night, window started on wednesday
+		"2022-09-01T02:00:00Z": false,
+	} {
+		if w.Contains(date(t, s)) != exp {
+			t.Fatalf("%s: expected %v", s, exp)
+		}
+	}
+
+	for _, w := range []Window{
+		{Start: "08:00", End: "08:00"},
+		{Start: "8h", End: "09:00"},
+		{Start: "08:00", End: "09:00", Weekdays: []string{"someday"}},
+	} {
+		if err := w.Validate(); err == nil {
+			t.Fatalf("%+v should fail to validate", w)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Wireleap
+
+package timeframe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time window, from Start to End on Weekdays in Timezone.
+// A window ending before it starts spans midnight, it belongs to the
+// weekday it starts on.
+type Window struct {
+	// Start of the window, "HH:MM".
+	Start string `json:"start"`
+	// End of the window, "HH:MM".
+	End string `json:"end"`
+	// Weekdays of the window, "monday" to "sunday" (default: every day).
+	Weekdays []string `json:"weekdays,omitempty"`
+	// Timezone is an IANA timezone name (default: "UTC").
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// parsed window
+type window struct {
+	start, end time.Duration
+	days       map[time.Weekday]bool
+	loc        *time.Location
+}
+
+func (w *Window) parse() (p window, err error) {
+	clock := func(s string) (time.Duration, error) {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+
+	if p.start, err = clock(w.Start); err != nil {
+		return
+	} else if p.end, err = clock(w.End); err != nil {
+		return
+	} else if p.start == p.end {
+		err = errors.New("'start' and 'end' cannot be equal")
+		return
+	}
+
+	if len(w.Weekdays) > 0 {
+		p.days = make(map[time.Weekday]bool, len(w.Weekdays))
+		for _, s := range w.Weekdays {
+			d, ok := weekdays[strings.ToLower(s)]
+			if !ok {
+				err = fmt.Errorf("unknown weekday %q", s)
+				return
+			}
+			p.days[d] = true
+		}
+	}
+
+	if p.loc, err = time.LoadLocation(w.Timezone); err != nil {
+		err = fmt.Errorf("invalid 'timezone' %q: %w", w.Timezone, err)
+	}
+	return
+}
+
+// Validate the window
+func (w *Window) Validate() error {
+	_, err := w.parse()
+	return err
+}
+
+// Contains returns if t is within the window, false if the window is invalid
+func (w *Window) Contains(t time.Time) bool {
+	p, err := w.parse()
+	if err != nil {
+		return false
+	}
+
+	lt := t.In(p.loc)
+	y, m, d := lt.Date()
+
+	// check the window starting today, and the one starting yesterday
+	for _, day := range []int{d, d - 1} {
+		midnight := time.Date(y, m, day, 0, 0, 0, 0, p.loc)
+		if p.days != nil && !p.days[midnight.Weekday()] {
+			continue
+		}
+
+		start, end := p.at(midnight, p.start), p.at(midnight, p.end)
+		if p.end < p.start {
+			end = p.at(time.Date(y, m, day+1, 0, 0, 0, 0, p.loc), p.end)
+		}
+
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the local time of the day d after midnight, wall clock based to
+// follow DST changes
+func (p window) at(midnight time.Time, d time.Duration) time.Time {
+	y, m, day := midnight.Date()
+	return time.Date(y, m, day, int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, p.loc)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2022 Wireleap
+
+// Package tlspolicy configures the TLS settings of the relay listener and
+// of the outgoing relay dials.
+package tlspolicy
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wireleap/common/api/duration"
+)
+
+// Minimum TLS versions by name
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Curves by name, both "P256" and "P-256" are accepted
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// DefaultMinVersion is the minimum TLS version if unset
+const DefaultMinVersion = "1.3"
+
+// T is the TLS policy of one side of the connections, zero values use the
+// DefaultMinVersion and the crypto/tls defaults.
+type T struct {
+	// MinVersion is the minimum TLS version, "1.2" or "1.3".
+	MinVersion string `json:"min_version,omitempty"`
+	// CipherSuites are the allowed TLS 1.2 cipher suites, by IANA name.
+	// TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+	// CurvePreferences are the key exchange curves, by preference.
+	CurvePreferences []string `json:"curve_preferences,omitempty"`
+}
+
+// Server is the TLS policy of the relay listener
+type Server struct {
+	T
+	// SessionTicketRotation is the session ticket keys rotation interval,
+	// tickets stay valid for two intervals. 0 uses the crypto/tls default.
+	SessionTicketRotation duration.T `json:"session_ticket_rotation,omitempty"`
+}
+
+func (t T) minVersion() (uint16, error) {
+	v := t.MinVersion
+	if v == "" {
+		v = DefaultMinVersion
+	}
+
+	if id, ok := versions[v]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unsupported min_version %q, expected \"1.2\" or \"1.3\"", t.MinVersion)
+}
+
+func (t T) cipherSuites() (ids []uint16, err error) {
+	if len(t.CipherSuites) == 0 {
+		return
+	}
+
+	if v, _ := t.minVersion(); v == tls.VersionTLS13 {
+		return nil, errors.New("cipher_suites require min_version \"1.2\", TLS 1.3 cipher suites are not configurable")
+	}
+
+	secure := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		secure[cs.Name] = cs.ID
+	}
+
+	for _, name := range t.CipherSuites {
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+func (t T) curves() (ids []tls.CurveID, err error) {
+	for _, name := range t.CurvePreferences {
+		id, ok := curves[strings.ToUpper(strings.Replace(name, "-", "", 1))]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q, expected X25519, P256, P384 or P521", name)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+// Validate the policy
+func (t T) Validate() (err error) {
+	if _, err = t.minVersion(); err != nil {
+		return
+	} else if _, err = t.cipherSuites(); err != nil {
+		return
+	}
+	_, err = t.curves()
+	return
+}
+
+// Apply sets the policy on a tls.Config, replacing its previous settings
+func (t T) Apply(tc *tls.Config) (err error) {
+	if tc.MinVersion, err = t.minVersion(); err != nil {
+		return
+	} else if tc.CipherSuites, err = t.cipherSuites(); err != nil {
+		return
+	}
+	tc.CurvePreferences, err = t.curves()
+	return
+}
+
+func list(names []string) string {
+	if len(names) == 0 {
+		return "default"
+	}
+	return strings.Join(names, ",")
+}
+
+// String returns the effective policy
+func (t T) String() string {
+	v := t.MinVersion
+	if v == "" {
+		v = DefaultMinVersion
+	}
+
+	cs := list(t.CipherSuites)
+	if v == "1.3" {
+		cs = "TLS 1.3 suites"
+	}
+	return fmt.Sprintf("min_version=%s cipher_suites=%s curve_preferences=%s", v, cs, list(t.CurvePreferences))
+}
+
+// Validate the server policy
+func (s Server) Validate() error {
+	if s.SessionTicketRotation < 0 {
+		return errors.New("session_ticket_rotation cannot be negative")
+	} else if err := s.T.Validate(); err != nil {
+		return err
+	}
+
+	if len(s.CipherSuites) == 0 {
+		return nil
+	}
+
+	// required by HTTP/2, see RFC 7540 section 9.2.2
+	for _, name := range s.CipherSuites {
+		if strings.HasPrefix(name, "TLS_ECDHE_") && strings.HasSuffix(name, "_WITH_AES_128_GCM_SHA256") {
+			return nil
+		}
+	}
+	return errors.New("cipher_suites require an ECDHE AES_128_GCM_SHA256 cipher suite for HTTP/2")
+}
+
+// String returns the effective server policy
+func (s Server) String() string {
+	r := "default"
+	if s.SessionTicketRotation > 0 {
+		r = time.Duration(s.SessionTicketRotation).String()
+	}
+	return fmt.Sprintf("%s session_ticket_rotation=%s", s.T, r)
+}
+
+// Apply sets the server policy on a tls.Config and starts rotating its
+// session ticket keys if needed. The returned function stops the rotation.
+func (s Server) Apply(tc *tls.Config) (stop func(), err error) {
+	stop = func() {}
+	if err = s.T.Apply(tc); err != nil || s.SessionTicketRotation == 0 {
+		return
+	}
+	return RotateTickets(tc, time.Duration(s.SessionTicketRotation))
+}
+
+func newTicketKey() (k [32]byte, err error) {
+	_, err = rand.Read(k[:])
+	return
+}
+
+// RotateTickets replaces the session ticket key of tc at every interval,
+// the previous key is kept to resume the sessions it issued.
+func RotateTickets(tc *tls.Config, every time.Duration) (stop func(), err error) {
+	cur, err := newTicketKey()
+	if err != nil {
+		return
+	}
+	tc.SetSessionTicketKeys([][32]byte{cur})
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				k, err := newTicketKey()
+				if err != nil {
+					continue
+				}
+				tc.SetSessionTicketKeys([][32]byte{k, cur})
+				cur = k
+			}
+		}
+	}()
+	return func() { close(done) }, nil
+}
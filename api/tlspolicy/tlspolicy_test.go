@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Wireleap
+
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	var s Server
+	err := json.Unmarshal([]byte(`{
+		"min_version": "1.2",
+		"cipher_suites": ["TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"],
+		"curve_preferences": ["X25519", "P-256"],
+		"session_ticket_rotation": "12h"
+	}`), &s)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &tls.Config{}
+	if err = s.T.Apply(tc); err != nil {
+		t.Fatal(err)
+	} else if tc.MinVersion != tls.VersionTLS12 || len(tc.CipherSuites) != 2 || len(tc.CurvePreferences) != 2 || tc.CurvePreferences[1] != tls.CurveP256 {
+		t.Fatalf("policy not applied %+v", tc)
+	}
+
+	const want = "min_version=1.2 cipher_suites=TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 curve_preferences=X25519,P-256 session_ticket_rotation=12h0m0s"
+	if s.String() != want {
+		t.Fatalf("wrong effective settings %q", s)
+	}
+
+	s.CipherSuites = s.CipherSuites[:1]
+	if err = s.Validate(); err == nil {
+		t.Fatal("server cipher suites without an HTTP/2 cipher suite should fail to validate")
+	}
+
+	// Defaults replace the previous settings
+	if err = (T{}).Apply(tc); err != nil {
+		t.Fatal(err)
+	} else if tc.MinVersion != tls.VersionTLS13 || tc.CipherSuites != nil || tc.CurvePreferences != nil {
+		t.Fatalf("defaults not applied %+v", tc)
+	}
+
+	for _, bad := range []T{
+		{MinVersion: "1.0"},
+		{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}},
+		{MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CurvePreferences: []string{"P224"}},
+	} {
+		if err = bad.Validate(); err == nil {
+			t.Fatalf("%+v should fail to validate", bad)
+		}
+	}
+}
+
+func TestRotateTickets(t *testing.T) {
+	tc := &tls.Config{}
+	stop, err := RotateTickets(tc, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stop()
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"sort"
+	"time"
+
+	"github.com/wireleap/common/api/texturl"
+
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/relaylib"
+)
+
+// Contract detail
+type contractDetail struct {
+	Id              string               `json:"id"`
+	Contract        string               `json:"contract"`
+	Directory       string               `json:"directory"`
+	Addr            *texturl.URL         `json:"address"`
+	Role            string               `json:"role"`
+	Status          relaylib.RelayFlags  `json:"status"`
+	LastBeat        *int64               `json:"last_heartbeat"`
+	LastError       *relaylib.RelayError `json:"last_error"`
+	NetCap          *uint64              `json:"network_cap"`
+	NetUsage        uint64               `json:"network_usage"`
+	NetUsageWindows map[string]uint64    `json:"network_usage_windows,omitempty"`
+	NetRate         uint64               `json:"network_rate"`
+	TimeToCap       *int64               `json:"time_to_cap"`
+	Paced           bool                 `json:"paced,omitempty"`
+	Priority        int                  `json:"priority,omitempty"`
+	Reserved        *uint64              `json:"network_reserved,omitempty"`
+	Shed            bool                 `json:"shed,omitempty"`
+	Override        *override            `json:"override,omitempty"`
+	PendingST       *int                 `json:"pending_sharetokens,omitempty"`
+}
+
+// Estimate the time left (millis) until usage reaches limit, at the average
+// rate observed since the beginning of the period
+func timeToCap(usage, limit uint64, elapsed time.Duration) *int64 {
+	var ttc int64
+	if usage >= limit {
+		// already reached
+	} else if usage == 0 || elapsed <= 0 {
+		// no rate available
+		return nil
+	} else {
+		rate := float64(usage) / float64(elapsed)
+		ttc = int64(time.Duration(float64(limit-usage)/rate) / time.Millisecond)
+	}
+	return &ttc
+}
+
+// Returns the detail of every contract, sorted by id
+func (m *Manager) ContractDetails() []contractDetail {
+	crs := m.Controller.Status()
+	ovs := m.Overrides()
+	netUsage, _ := m.netUsage()
+	capUsage, _ := m.capUsage()
+	nc := m.capsCfg()
+	caps, _ := nc.Caps()
+	priorities := nc.contractPriorities()
+	reserved := nc.contractReserved()
+
+	var elapsed time.Duration
+	if enabled, since := m.statsEnabled(); enabled {
+		elapsed = time.Since(epoch.FromEpochMillis(since))
+	}
+
+	cds := make([]contractDetail, 0, len(crs))
+	for cid, rs := range crs {
+		cd := contractDetail{
+			Id:        cid,
+			Contract:  rs.Contract,
+			Directory: rs.Directory,
+			Addr:      rs.Addr,
+			Role:      rs.Role,
+			Status:    rs.Flags,
+			LastError: rs.LastError,
+			NetUsage:  netUsage[cid],
+		}
+
+		cd.NetUsageWindows = m.contractWindowUsage(cid)
+		rate := m.forecast.rate(cid)
+		cd.NetRate, cd.Paced = uint64(rate), m.forecast.isPaced(cid)
+		cd.Priority, cd.Shed = priorities[cid], m.shed(cid)
+
+		if r, ok := reserved[cid]; ok {
+			cd.Reserved = &r
+		}
+
+		if rs.LastBeat != 0 {
+			lb := rs.LastBeat
+			cd.LastBeat = &lb
+		}
+
+		if c, ok := caps[cid]; ok {
+			nc := c.limit
+			cd.NetCap = &nc
+			if rate > 0 {
+				cd.TimeToCap = eta(capUsage[cid], c.soft, rate)
+			} else {
+				cd.TimeToCap = timeToCap(capUsage[cid], c.soft, elapsed)
+			}
+		}
+
+		if o, ok := ovs[cid]; ok {
+			cd.Override = &o
+		}
+
+		if m.PendingST != nil {
+			n := m.PendingST(cid)
+			cd.PendingST = &n
+		}
+
+		cds = append(cds, cd)
+	}
+
+	sort.Slice(cds, func(i, j int) bool { return cds[i].Id < cds[j].Id })
+	return cds
+}
+
+// Returns the detail of a single contract
+func (m *Manager) ContractDetail(contractId string) (contractDetail, bool) {
+	for _, cd := range m.ContractDetails() {
+		if cd.Id == contractId {
+			return cd, true
+		}
+	}
+	return contractDetail{}, false
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeToCap(t *testing.T) {
+	if ttc := timeToCap(0, 100, time.Hour); ttc != nil {
+		t.Fatal("No usage shouldn't provide an estimation")
+	}
+
+	if ttc := timeToCap(10, 100, 0); ttc != nil {
+		t.Fatal("No elapsed time shouldn't provide an estimation")
+	}
+
+	if ttc := timeToCap(100, 100, time.Hour); ttc == nil || *ttc != 0 {
+		t.Fatal("Reached cap should estimate 0")
+	}
+
+	ttc := timeToCap(25, 100, time.Hour)
+	if ttc == nil || *ttc != int64(3*time.Hour/time.Millisecond) {
+		t.Fatalf("Unexpected estimation: %v", ttc)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// Time constant of the usage rate moving average
+const rateTau = 5 * time.Minute
+
+// Network usage rates and pacing state
+type forecast struct {
+	lock sync.Mutex
+	at   time.Time
+	// usage at the last update, by contract, "" is global
+	last map[string]uint64
+	// usage rates (bytes per second), by contract, "" is global
+	rates map[string]float64
+	// contracts being paced
+	paced map[string]bool
+}
+
+// Update the usage rates with the current usage, by contract
+func (f *forecast) update(usage map[string]uint64, now time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var sum uint64
+	cur := make(map[string]uint64, len(usage)+1)
+	for k, u := range usage {
+		cur[k] = u
+		sum += u
+	}
+	cur[""] = sum
+
+	dt := now.Sub(f.at).Seconds()
+	if f.at.IsZero() || dt <= 0 {
+		f.at, f.last = now, cur
+		return
+	}
+
+	// exponentially weighted moving average, weighted by elapsed time
+	alpha := 1 - math.Exp(-dt/rateTau.Seconds())
+
+	rates := make(map[string]float64, len(cur))
+	for k, u := range cur {
+		delta := u
+		if prev := f.last[k]; u >= prev {
+			delta = u - prev
+		} // else counters were reset
+
+		r := float64(delta) / dt
+		if prev, ok := f.rates[k]; ok {
+			r = prev + alpha*(r-prev)
+		}
+		rates[k] = r
+	}
+
+	f.at, f.last, f.rates = now, cur, rates
+}
+
+// Returns the usage rate of a contract, or the global one if contractId is
+// empty, in bytes per second
+func (f *forecast) rate(contractId string) float64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.rates[contractId]
+}
+
+// Forget the pacing state, once a new period starts
+func (f *forecast) reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.paced = nil
+}
+
+// Returns if a contract is being paced
+func (f *forecast) isPaced(contractId string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.paced[contractId]
+}
+
+// Estimate the time left (millis) until usage reaches limit at rate
+func eta(usage, limit uint64, rate float64) *int64 {
+	var ttc int64
+	if usage >= limit {
+		// already reached
+	} else if rate <= 0 {
+		// no rate available
+		return nil
+	} else {
+		ttc = int64(time.Duration(float64(limit-usage)/rate*float64(time.Second)) / time.Millisecond)
+	}
+	return &ttc
+}
+
+// Returns if pacing applies to a contract
+func (n netCapsCfg) paced(contractId string) bool {
+	if p, ok := n.contractPacing()[contractId]; ok {
+		return p
+	}
+	return n.pacing
+}
+
+// Returns the pacing rate of a contract so its soft cap lasts until the end
+// of the period, 0 if not paced. Pacing starts once the usage rate forecasts
+// the cap to be reached before the end of the period, and lasts until it.
+func (m *Manager) pace(contractId string, c cap, usage uint64, now time.Time) uint64 {
+	f := &m.forecast
+
+	left := m.periodEnd().Sub(now).Seconds()
+	if c.limit == 0 || !m.capsCfg().paced(contractId) || usage >= c.soft || left <= 0 {
+		f.lock.Lock()
+		delete(f.paced, contractId)
+		f.lock.Unlock()
+		return 0
+	}
+
+	target := float64(c.soft-usage) / left
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.paced[contractId] {
+		if f.rates[contractId] <= target {
+			// cap lasts until the end of the period
+			return 0
+		}
+
+		if f.paced == nil {
+			f.paced = map[string]bool{}
+		}
+		f.paced[contractId] = true
+		log.Printf("Network Cap: Pacing contract %s to %s/s", contractId, datasize.ByteSize(target).HR())
+	}
+
+	if target < 1 {
+		return 1
+	}
+	return uint64(target)
+}
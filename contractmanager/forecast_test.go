@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wireleap/relay/api/netcap"
+)
+
+func TestForecast(t *testing.T) {
+	f := &forecast{}
+	now := time.Now()
+
+	f.update(map[string]uint64{"ct1": 1000}, now)
+	if f.rate("ct1") != 0 {
+		t.Fatal("first update should not provide a rate")
+	}
+
+	f.update(map[string]uint64{"ct1": 2000, "ct2": 500}, now.Add(10*time.Second))
+	if f.rate("ct1") != 100 || f.rate("ct2") != 50 || f.rate("") != 150 {
+		t.Fatalf("unexpected rates %v", f.rates)
+	}
+
+	// rates are smoothed
+	f.update(map[string]uint64{"ct1": 2000, "ct2": 500}, now.Add(20*time.Second))
+	if r := f.rate("ct1"); r <= 90 || r >= 100 {
+		t.Fatalf("unexpected smoothed rate %f", r)
+	}
+
+	// counters reset
+	f.update(map[string]uint64{"ct1": 100, "ct2": 0}, now.Add(30*time.Second))
+	if r := f.rate("ct1"); r <= 0 || r >= 100 {
+		t.Fatalf("unexpected rate after reset %f", r)
+	}
+}
+
+func TestETA(t *testing.T) {
+	if ttc := eta(100, 1000, 0); ttc != nil {
+		t.Fatal("No rate shouldn't provide an estimation")
+	}
+
+	if ttc := eta(1000, 1000, 10); ttc == nil || *ttc != 0 {
+		t.Fatal("Reached cap should estimate 0")
+	}
+
+	if ttc := eta(100, 1000, 10); ttc == nil || *ttc != 90000 {
+		t.Fatalf("Unexpected estimation: %v", ttc)
+	}
+}
+
+func TestPace(t *testing.T) {
+	m := &Manager{}
+	m.netCaps = newNCCfg()
+	m.netCaps.pacing = true
+
+	now := time.Now()
+	m.netFns.nextReset = now.Add(1000 * time.Second)
+	c := newCap(20000, netcap.Policy{Hard: &netcap.Threshold{Limit: netcap.Fraction(1), Action: netcap.Disable}})
+
+	// 10000 bytes left for 1000s, at 5 bytes/s
+	m.forecast.rates = map[string]float64{"ct1": 5}
+	if r := m.pace("ct1", c, 10000, now); r != 0 || m.forecast.isPaced("ct1") {
+		t.Fatal("cap lasting until the end of the period shouldn't be paced")
+	}
+
+	// at 50 bytes/s
+	m.forecast.rates["ct1"] = 50
+	if r := m.pace("ct1", c, 10000, now); r != 10 || !m.forecast.isPaced("ct1") {
+		t.Fatalf("unexpected pacing rate %d", r)
+	}
+
+	// pacing lasts once the rate is lowered
+	m.forecast.rates["ct1"] = 10
+	if r := m.pace("ct1", c, 15000, now.Add(500*time.Second)); r != 10 {
+		t.Fatalf("unexpected pacing rate %d", r)
+	}
+
+	// disabled for the contract
+	m.netCaps.contractPacing = func() map[string]bool { return map[string]bool{"ct1": false} }
+	if r := m.pace("ct1", c, 15000, now); r != 0 || m.forecast.isPaced("ct1") {
+		t.Fatal("pacing disabled for the contract")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/relaycfg"
+)
+
+const (
+	// Timeout of the listener check
+	listenerTimeout = 2 * time.Second
+	// Disk check file, relative to the relay home
+	healthFile = ".health"
+	// Time a disk check result is reused by readiness probes
+	diskCheckInterval = 30 * time.Second
+)
+
+// Health check result
+type healthCheck struct {
+	Name   string `json:"name"`
+	Ok     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Health status, Ok if every check passed
+type HealthStatus struct {
+	Ok     bool          `json:"ok"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// Health configuration holder
+type health struct {
+	lock sync.Mutex
+	addr string
+	cfg  relaycfg.Health
+	// last disk check, guarded by diskLock
+	diskLock sync.Mutex
+	diskAt   time.Time
+	diskErr  error
+}
+
+func (hs *HealthStatus) add(name string, err error) {
+	hc := healthCheck{Name: name, Ok: err == nil}
+	if err != nil {
+		hc.Detail = err.Error()
+	}
+
+	hs.Checks = append(hs.Checks, hc)
+	hs.Ok = hs.Ok && hc.Ok
+}
+
+// Returns the health configuration
+func (m *Manager) healthCfg() (string, relaycfg.Health) {
+	m.health.lock.Lock()
+	defer m.health.lock.Unlock()
+
+	return m.health.addr, m.health.cfg
+}
+
+// Check the wireleap:// listener completes a TLS handshake
+func checkListener(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	d := &net.Dialer{Timeout: listenerTimeout}
+	conn, err := tls.DialWithDialer(d, "tcp", net.JoinHostPort(host, port), &tls.Config{
+		// only the listener availability is checked
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Check enrolled contracts and heartbeats
+func (m *Manager) checkEnrolled(cfg relaycfg.Health) (enrolled, beats error) {
+	maxAge := time.Duration(cfg.MaxHeartbeatAge)
+	now := epoch.EpochMillis()
+
+	n := 0
+	for cid, rs := range m.Controller.Status() {
+		if !rs.Flags.Enrolled {
+			continue
+		}
+		n++
+
+		if maxAge == 0 || beats != nil {
+			continue
+		} else if age := time.Duration(now-rs.LastBeat) * time.Millisecond; age > maxAge {
+			beats = fmt.Errorf("last heartbeat of contract %s is %s old", cid, age.Truncate(time.Second))
+		}
+	}
+
+	if n < cfg.MinEnrolled {
+		enrolled = fmt.Errorf("%d contracts enrolled, %d required", n, cfg.MinEnrolled)
+	}
+	return
+}
+
+// Check the global network cap
+func (m *Manager) checkGlobalCap() error {
+	_, global := m.capsCfg().Caps()
+	if global.limit == 0 {
+		return nil
+	}
+
+	_, sum := m.capUsage()
+	if sum >= global.hard && global.blocks(hardCap) {
+		return errors.New("global network cap reached")
+	}
+	return nil
+}
+
+// Check the relay home is writable, the result is reused for
+// diskCheckInterval so that frequent probes do not write every time
+func (m *Manager) checkDisk() error {
+	m.health.diskLock.Lock()
+	defer m.health.diskLock.Unlock()
+
+	if now := time.Now(); m.health.diskAt.IsZero() || now.Sub(m.health.diskAt) >= diskCheckInterval {
+		m.health.diskErr = m.fm.Set(epoch.EpochMillis(), healthFile)
+		if m.health.diskErr == nil {
+			m.health.diskErr = m.fm.Del(healthFile)
+		}
+		m.health.diskAt = now
+	}
+	return m.health.diskErr
+}
+
+// Returns the relay liveness: the wireleap:// listener is accepting connections
+func (m *Manager) Liveness() HealthStatus {
+	addr, _ := m.healthCfg()
+
+	hs := HealthStatus{Ok: true}
+	hs.add("listener", checkListener(addr))
+	return hs
+}
+
+// Returns the relay readiness according to the configured criteria
+func (m *Manager) Readiness() HealthStatus {
+	addr, cfg := m.healthCfg()
+
+	hs := HealthStatus{Ok: true}
+	hs.add("listener", checkListener(addr))
+
+	if !m.Controller.Started() {
+		hs.add("controller", fmt.Errorf("controller not started"))
+	}
+
+	enrolled, beats := m.checkEnrolled(cfg)
+	hs.add("enrolled", enrolled)
+
+	if cfg.MaxHeartbeatAge != 0 {
+		hs.add("heartbeat", beats)
+	}
+
+	if !cfg.AllowCapReached {
+		hs.add("network_cap", m.checkGlobalCap())
+	}
+
+	if !cfg.SkipDiskCheck {
+		hs.add("disk", m.checkDisk())
+	}
+	return hs
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/wireleap/common/cli/fsdir"
+)
+
+func TestHealthStatus(t *testing.T) {
+	hs := HealthStatus{Ok: true}
+	hs.add("listener", nil)
+
+	if !hs.Ok || len(hs.Checks) != 1 {
+		t.Fatal("passing check should keep status ok")
+	}
+
+	hs.add("disk", errors.New("read-only file system"))
+
+	if hs.Ok {
+		t.Fatal("failed check should fail status")
+	} else if c := hs.Checks[1]; c.Ok || c.Detail != "read-only file system" {
+		t.Fatalf("unexpected check %+v", c)
+	}
+}
+
+func TestCheckListener(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+
+	addr := srv.Listener.Addr().String()
+
+	if err := checkListener(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Close()
+
+	if err := checkListener(addr); err == nil {
+		t.Fatal("closed listener should fail the check")
+	}
+}
+
+func TestCheckDisk(t *testing.T) {
+	fm, err := fsdir.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{fm: fm}
+	if err = m.checkDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the result is reused within the interval
+	if err = os.RemoveAll(fm.Path()); err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(fm.Path(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.checkDisk(); err != nil {
+		t.Fatal("disk check should be cached")
+	}
+
+	m.health.diskAt = m.health.diskAt.Add(-diskCheckInterval)
+	if err = m.checkDisk(); err == nil {
+		t.Fatal("unwritable home should fail the check once the interval elapsed")
+	}
+}
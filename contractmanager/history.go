@@ -0,0 +1,29 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"errors"
+
+	"github.com/wireleap/relay/relaystats/nustore"
+)
+
+// ErrNoArchive is returned when network_usage.archive_dir is not set
+var ErrNoArchive = errors.New("network usage archive is not configured")
+
+// Returns the archived network usage periods matching the query, with their
+// aggregated usage
+func (m *Manager) UsageHistory(q nustore.Query) (h nustore.History, err error) {
+	cfg := m.statsCfg()
+
+	if !cfg.Archive() {
+		err = ErrNoArchive
+		return
+	}
+
+	archive, err := nustore.New(m.fm.Path(*cfg.archiveDir))
+	if err != nil {
+		return
+	}
+	return archive.History(q)
+}
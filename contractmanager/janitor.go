@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/api/events"
+	"github.com/wireleap/relay/api/janitor"
+	"github.com/wireleap/relay/relaycfg"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// Interval between archive janitor runs
+const janitorInterval = time.Hour
+
+// Archive directory and its retention policy
+type archiveRetention struct {
+	name   string
+	dir    string
+	policy janitor.Policy
+}
+
+// Archive janitor config holder
+type archiveJanitor struct {
+	lock     sync.Mutex
+	archives []archiveRetention
+}
+
+// Returns the janitor policy of a retention config
+func retentionPolicy(r relaycfg.Retention) janitor.Policy {
+	return janitor.Policy{
+		MaxAge:       time.Duration(r.MaxAge),
+		MaxCount:     r.MaxCount,
+		MaxSize:      r.MaxSize.Bytes(),
+		CompactAfter: time.Duration(r.CompactAfter),
+	}
+}
+
+// Returns the archives with a retention policy
+func loadArchives(fm fsdir.T, c *relaycfg.C) (as []archiveRetention) {
+	if p := retentionPolicy(c.ArchiveRetention); c.ArchiveDir != nil && p.Enabled() {
+		as = append(as, archiveRetention{"sharetokens", fm.Path(*c.ArchiveDir), p})
+	}
+
+	if p := retentionPolicy(c.NetUsage.ArchiveRetention); c.NetUsage.ArchiveDir != nil && p.Enabled() {
+		as = append(as, archiveRetention{"network_usage", fm.Path(*c.NetUsage.ArchiveDir), p})
+	}
+	return
+}
+
+// Apply the retention policies, report what was removed
+func (m *Manager) cleanArchives() {
+	m.janitor.lock.Lock()
+	as := m.janitor.archives
+	m.janitor.lock.Unlock()
+
+	for _, a := range as {
+		r, err := janitor.Run(a.dir, a.policy, time.Now())
+		if err != nil {
+			log.Printf("could not clean %s archive: %s", a.name, err)
+		}
+
+		if r.Removed == 0 && r.Compacted == 0 {
+			continue
+		}
+
+		log.Printf(
+			"Archive janitor: removed %d files (%s) and compacted %d files from %s archive",
+			r.Removed, datasize.ByteSize(r.RemovedBytes).HR(), r.Compacted, a.name,
+		)
+		m.Events.Emit(events.ArchiveCleaned, "", map[string]interface{}{
+			"archive":       a.name,
+			"removed":       r.Removed,
+			"removed_bytes": r.RemovedBytes,
+			"compacted":     r.Compacted,
+		})
+	}
+}
+
+// Run the archive janitor periodically
+func (m *Manager) runJanitor() {
+	m.cleanArchives()
+
+	t := time.NewTicker(janitorInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			m.cleanArchives()
+		case <-m.done:
+			return
+		}
+	}
+}
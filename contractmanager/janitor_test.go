@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wireleap/common/api/duration"
+	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/api/events"
+	"github.com/wireleap/relay/relaycfg"
+)
+
+func TestCleanArchives(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "wltest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	dir := "archive/netstats"
+	c := relaycfg.Defaults()
+	c.NetUsage.ArchiveDir = &dir
+	c.NetUsage.ArchiveRetention = relaycfg.Retention{MaxAge: duration.T(24 * time.Hour)}
+
+	fm := fsdir.T(tmpd)
+	m := &Manager{Events: events.New(8)}
+	m.janitor.archives = loadArchives(fm, &c)
+	if len(m.janitor.archives) != 1 {
+		t.Fatal("sharetoken archive without retention should be skipped")
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"relay-1.json", "relay-2.json"} {
+		p := filepath.Join(fm.Path(dir), name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		ioutil.WriteFile(p, []byte("{}"), 0644)
+		os.Chtimes(p, old, old)
+	}
+	ioutil.WriteFile(filepath.Join(fm.Path(dir), "relay-3.json"), []byte("{}"), 0644)
+
+	m.cleanArchives()
+
+	if fis, _ := ioutil.ReadDir(fm.Path(dir)); len(fis) != 1 {
+		t.Fatalf("old files should be removed, %d left", len(fis))
+	}
+
+	if evs, _, cancel := m.Events.Subscribe(0); len(evs) != 1 || evs[0].Type != events.ArchiveCleaned {
+		t.Fatalf("cleaning should be reported %v", evs)
+	} else {
+		cancel()
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"log"
+	"time"
+
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaystats/file"
+	"github.com/wireleap/relay/relaystats/journal"
+)
+
+// Interval between network usage journal entries
+const journalInterval = 5 * time.Second
+
+// Network usage journal, the counter deltas since the stats were stored
+type usageJournal struct {
+	j *journal.T
+	// last journaled counters, by contract
+	last map[string]uint64
+}
+
+// Open the journal, deltas are accounted from the current counters (lock held)
+func (m *Manager) openJournal() {
+	if m.journal.j == nil {
+		j, err := journal.Open(m.fm.Path(filenames.StatsJournal))
+		if err != nil {
+			log.Printf("could not open network usage journal: %s", err)
+			return
+		}
+		m.journal.j = j
+	}
+	m.journal.last, _ = m.netUsage()
+}
+
+// Close the journal (lock held)
+func (m *Manager) closeJournal() {
+	if m.journal.j != nil {
+		m.journal.j.Close()
+		m.journal.j = nil
+	}
+}
+
+// Append the counter deltas to the journal
+func (m *Manager) writeJournal() {
+	m.netFns.lock.Lock()
+	defer m.netFns.lock.Unlock()
+
+	if m.journal.j == nil {
+		return
+	}
+
+	usage, _ := m.netUsage()
+	delta := make(map[string]uint64)
+	for ct, u := range usage {
+		if l := m.journal.last[ct]; u > l {
+			delta[ct] = u - l
+		}
+	}
+
+	if len(delta) == 0 {
+		return
+	}
+
+	ns := &m.NetStats.Active
+	e := journal.Entry{Seq: ns.JournalSeq + 1, At: epoch.EpochMillis(), Since: ns.CreatedAt, Usage: delta}
+	if err := m.journal.j.Append(e); err != nil {
+		log.Printf("could not write network usage journal: %s", err)
+		return
+	}
+	m.journal.last, ns.JournalSeq = usage, e.Seq
+}
+
+// Truncate the journal once the stats are stored, the stored entries are
+// skipped on replay if it fails (lock held)
+func (m *Manager) truncateJournal(fns *file.NetStats) {
+	if m.journal.j == nil {
+		return
+	} else if err := m.journal.j.Truncate(); err != nil {
+		log.Printf("could not truncate network usage journal: %s", err)
+		return
+	}
+
+	m.journal.last = make(map[string]uint64, len(fns.ContractStats))
+	for ct, cs := range fns.ContractStats {
+		if cs != nil {
+			m.journal.last[ct] = cs.NetworkBytes
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/api/atomicfile"
+	"github.com/wireleap/relay/api/synccounters"
+	"github.com/wireleap/relay/api/timeframe"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaylib"
+	"github.com/wireleap/relay/relaystats"
+)
+
+func TestJournal(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "wltest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	fm, err := fsdir.New(tmpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Controller: relaylib.NewController(nil, nil), fm: fm}
+	nsCfg := netStatsCfg{writeInterval: time.Hour, timeframe: timeframe.T{Duration: time.Hour}}
+
+	if err = m.reloadNetUsage(nsCfg, newNCCfg()); err != nil {
+		t.Fatal(err)
+	}
+	m.setNetUsageFns()
+	defer m.unsetNetUsageFns()
+
+	add := func(ct string, n uint64) {
+		c := m.NetStats.Active.ContractStats.GetOrInit(ct)
+		c.Add(n)
+		c.Close()
+	}
+
+	add("ct1", 100)
+	m.netFns.storeStats()
+
+	add("ct1", 10)
+	m.writeJournal()
+	add("ct2", 20)
+	m.writeJournal()
+
+	// Crash before the next store
+	ns, _, err := loadStats(fm, []string{"ct1", "ct2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := func(ns relaystats.NetStats) map[string]uint64 {
+		u := map[string]uint64{}
+		ns.ContractStats.Range(func(ct string, c *synccounters.ContractCounter) bool {
+			u[ct] = c.Sum()
+			return true
+		})
+		return u
+	}
+
+	if u := usage(ns); u["ct1"] != 110 || u["ct2"] != 20 {
+		t.Fatalf("journal should be replayed %v", u)
+	}
+
+	// Stored stats truncate the journal
+	m.netFns.storeStats()
+	m.writeJournal()
+
+	if ns, _, err = loadStats(fm, []string{"ct1", "ct2"}); err != nil {
+		t.Fatal(err)
+	} else if u := usage(ns); u["ct1"] != 110 {
+		t.Fatalf("journal should not be replayed twice %v", u)
+	}
+
+	// Crash after storing the stats, before truncating the journal
+	add("ct1", 30)
+	m.writeJournal()
+
+	m.netFns.lock.Lock()
+	fns, err := saveStats(m.NetStats.Active, m.Controller.Contracts(), nil)
+	if err == nil {
+		err = atomicfile.SetIndented(fm, fns, filenames.Stats)
+	}
+	m.netFns.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ns, _, err = loadStats(fm, []string{"ct1", "ct2"}); err != nil {
+		t.Fatal(err)
+	} else if u := usage(ns); u["ct1"] != 140 {
+		t.Fatalf("stored entries should not be replayed %v", u)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaycfg"
+	"github.com/wireleap/relay/relaylib"
+)
+
+const maintenanceInterval = 10 * time.Second
+
+// Maintenance mode persisted state
+// All the contracts are affected if Contracts is empty.
+type maintenanceState struct {
+	Enabled   bool     `json:"enabled"`
+	Contracts []string `json:"contracts,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+	Since     int64    `json:"since,omitempty"`
+}
+
+// Contract Manager Maintenance Window Status
+type maintenanceWindow struct {
+	Start     int64    `json:"start"`
+	End       int64    `json:"end"`
+	Contracts []string `json:"contracts,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+// Contract Manager Maintenance Status
+type maintenanceStatus struct {
+	maintenanceState
+	Active  []string            `json:"active_contracts"`
+	Windows []maintenanceWindow `json:"windows,omitempty"`
+}
+
+// Maintenance mode holder
+type maintenance struct {
+	lock    sync.Mutex
+	state   maintenanceState
+	windows []relaycfg.MaintenanceWindow
+}
+
+// Returns if a contract is affected by a maintenance state
+func (s maintenanceState) covers(contractId string) bool {
+	if !s.Enabled {
+		return false
+	} else if len(s.Contracts) == 0 {
+		return true
+	}
+
+	for _, ct := range s.Contracts {
+		if ct == contractId {
+			return true
+		}
+	}
+	return false
+}
+
+// Load maintenance state from file
+func loadMaintenance(m *Manager) (err error) {
+	var st maintenanceState
+	if err = m.fm.Get(&st, filenames.Maintenance); err == nil {
+		m.maintenance.state = st
+
+		if st.Enabled {
+			log.Printf("maintenance mode is enabled since %s", epoch.FromEpochMillis(st.Since))
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// Resolve contract ids or service contract URLs into contract ids
+func (m *Manager) resolveContracts(cts []string) ([]string, error) {
+	scs := m.Controller.SCS()
+
+	urls := make(map[string]string, len(scs))
+	for id, url := range scs {
+		urls[url] = id
+	}
+
+	ids := make([]string, 0, len(cts))
+	for _, ct := range cts {
+		if _, ok := scs[ct]; ok {
+			ids = append(ids, ct)
+		} else if id, ok := urls[ct]; ok {
+			ids = append(ids, id)
+		} else {
+			return nil, fmt.Errorf("%w: %s", relaylib.ErrContractNotFound, ct)
+		}
+	}
+	return ids, nil
+}
+
+// Returns the contracts under maintenance right now, either set manually
+// or by a scheduled window
+func (m *Manager) maintenanceSet() map[string]bool {
+	m.maintenance.lock.Lock()
+	st, windows := m.maintenance.state, m.maintenance.windows
+	m.maintenance.lock.Unlock()
+
+	scs := m.Controller.SCS()
+	now := time.Now()
+
+	res := make(map[string]bool, len(scs))
+	for id, url := range scs {
+		if st.covers(id) {
+			res[id] = true
+			continue
+		}
+
+		for _, w := range windows {
+			if now.Before(w.Start) || !now.Before(w.End) {
+				continue
+			} else if len(w.Contracts) == 0 {
+				res[id] = true
+			}
+
+			for _, sc := range w.Contracts {
+				if sc.String() == url {
+					res[id] = true
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Apply maintenance mode to the controller
+// Contracts entering maintenance are disenrolled, live tunnels are left to drain.
+// Contracts leaving maintenance are reenrolled unless a network cap applies.
+func (m *Manager) applyMaintenance() {
+	held := m.maintenanceSet()
+	relaystatus := m.Controller.Status()
+
+	reenroll := false
+	for cid, rs := range relaystatus {
+		on := held[cid]
+		if on == rs.Flags.Maintenance {
+			continue
+		}
+
+		if err := m.Controller.SetMaintenance(cid, on); err != nil {
+			log.Printf("Error while setting maintenance mode, %s", err.Error())
+			continue
+		}
+
+		if !on {
+			log.Printf("Maintenance: Leaving maintenance mode on contract %s", cid)
+			reenroll = true
+		} else if !m.Controller.Started() || !rs.Flags.Enrolled {
+			log.Printf("Maintenance: Entering maintenance mode on contract %s", cid)
+		} else if err := m.Controller.Disenroll(cid); err != nil {
+			log.Printf("Error while disenrolling, %s", err.Error())
+		} else {
+			log.Printf("Maintenance: Disenrolling from contract %s", cid)
+		}
+	}
+
+	if reenroll {
+		m.reenroll()
+	}
+}
+
+// Apply scheduled maintenance windows periodically
+func (m *Manager) runMaintenance() {
+	t := time.NewTicker(maintenanceInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			m.applyMaintenance()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Returns the state with the contracts ids added to or removed from
+// maintenance, all the contracts if ids is empty
+func (s maintenanceState) with(on bool, ids []string, all map[string]string) maintenanceState {
+	switch {
+	case len(ids) == 0:
+		s.Contracts = nil
+		s.Enabled = on
+	case on && s.Enabled && len(s.Contracts) == 0:
+		// all the contracts are already affected
+	case on:
+		if !s.Enabled {
+			s.Contracts = nil
+		}
+
+		set := make(map[string]bool, len(s.Contracts)+len(ids))
+		for _, ct := range s.Contracts {
+			set[ct] = true
+		}
+		for _, ct := range ids {
+			set[ct] = true
+		}
+		s.Contracts = sortedKeys(set)
+		s.Enabled = true
+	case s.Enabled:
+		set := make(map[string]bool, len(all))
+		if len(s.Contracts) == 0 {
+			for id := range all {
+				set[id] = true
+			}
+		} else {
+			for _, ct := range s.Contracts {
+				set[ct] = true
+			}
+		}
+
+		for _, ct := range ids {
+			delete(set, ct)
+		}
+		s.Contracts = sortedKeys(set)
+		s.Enabled = len(s.Contracts) > 0
+	}
+
+	if !s.Enabled {
+		s.Contracts, s.Reason, s.Since = nil, "", 0
+	}
+	return s
+}
+
+// Returns the sorted keys of a set
+func sortedKeys(set map[string]bool) []string {
+	r := make([]string, 0, len(set))
+	for k := range set {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Enable or disable maintenance mode
+// Contracts can be referenced by id or by service contract URL, they are
+// added to or removed from the contracts already under maintenance. All the
+// contracts are affected if none is provided.
+func (m *Manager) SetMaintenance(on bool, contracts []string, reason string) (ms maintenanceStatus, err error) {
+	ids, err := m.resolveContracts(contracts)
+	if err != nil {
+		return
+	}
+
+	m.maintenance.lock.Lock()
+	prev := m.maintenance.state
+	st := prev.with(on, ids, m.Controller.SCS())
+
+	if st.Enabled {
+		if reason != "" || !prev.Enabled {
+			st.Reason = reason
+		}
+		if !prev.Enabled {
+			st.Since = epoch.EpochMillis()
+		}
+	}
+
+	// the state only changes once stored
+	if err = m.fm.Set(st, filenames.Maintenance); err == nil {
+		m.maintenance.state = st
+	}
+	m.maintenance.lock.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("could not store maintenance state: %w", err)
+		return
+	}
+
+	m.applyMaintenance()
+	return m.MaintenanceStatus(), nil
+}
+
+// Returns the current maintenance status
+func (m *Manager) MaintenanceStatus() (ms maintenanceStatus) {
+	held := m.maintenanceSet()
+
+	m.maintenance.lock.Lock()
+	defer m.maintenance.lock.Unlock()
+
+	ms.maintenanceState = m.maintenance.state
+	ms.Active = make([]string, 0, len(held))
+	for cid := range held {
+		ms.Active = append(ms.Active, cid)
+	}
+	sort.Strings(ms.Active)
+
+	if len(m.maintenance.windows) == 0 {
+		return
+	}
+
+	urls := m.Controller.SCS()
+	ids := make(map[string]string, len(urls))
+	for id, url := range urls {
+		ids[url] = id
+	}
+
+	now := time.Now()
+	for _, w := range m.maintenance.windows {
+		if !now.Before(w.End) {
+			// skip past windows
+			continue
+		}
+
+		mw := maintenanceWindow{
+			Start:  epoch.ToEpochMillis(w.Start),
+			End:    epoch.ToEpochMillis(w.End),
+			Reason: w.Reason,
+		}
+
+		for _, sc := range w.Contracts {
+			mw.Contracts = append(mw.Contracts, ids[sc.String()])
+		}
+		ms.Windows = append(ms.Windows, mw)
+	}
+	return
+}
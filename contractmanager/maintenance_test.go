@@ -0,0 +1,261 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wireleap/common/api/client"
+	"github.com/wireleap/common/api/contractinfo"
+	"github.com/wireleap/common/api/jsonb"
+	"github.com/wireleap/common/api/relayentry"
+	"github.com/wireleap/common/api/signer"
+	"github.com/wireleap/common/api/texturl"
+	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/api/relayentryext"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaycfg"
+	"github.com/wireleap/relay/relaylib"
+)
+
+// Returns a manager with a contract loaded per host, served by a mock client,
+// and the contract ids by host
+func maintenanceManager(t *testing.T, hosts ...string) (*Manager, map[string]string) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]string, len(hosts))
+	infos := make(map[string]*contractinfo.T, len(hosts))
+	cfg := relaycfg.C{Contracts: map[texturl.URL]*relayentryext.T{}}
+
+	for _, host := range hosts {
+		pub, _, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids[host] = jsonb.PK(pub).String()
+		infos[host] = &contractinfo.T{
+			Pubkey:    jsonb.PK(pub),
+			Directory: contractinfo.Directory{Endpoint: texturl.URLMustParse("https://dir." + host)},
+		}
+		cfg.Contracts[*texturl.URLMustParse("https://" + host)] = &relayentryext.T{
+			T: relayentry.T{Role: "backing", Addr: texturl.URLMustParse("wireleap://relay.example.com:13490")},
+		}
+	}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := infos[r.Host]; ok && r.URL.Path == "/info" {
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+		http.NotFound(w, r)
+	})
+
+	fm, err := fsdir.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Controller: relaylib.NewController(client.NewMock(signer.New(priv), h), nil), fm: fm}
+	if err = m.Controller.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	return m, ids
+}
+
+// Returns the contracts flagged in maintenance by the controller
+func flagged(m *Manager) map[string]bool {
+	res := map[string]bool{}
+	for cid, rs := range m.Controller.Status() {
+		if rs.Flags.Maintenance {
+			res[cid] = true
+		}
+	}
+	return res
+}
+
+func TestMaintenanceCovers(t *testing.T) {
+	st := maintenanceState{}
+
+	if st.covers("ct1") {
+		t.Fatal("Disabled maintenance shouldn't cover any contract")
+	}
+
+	st.Enabled = true
+
+	if !st.covers("ct1") || !st.covers("ct2") {
+		t.Fatal("Maintenance without contracts should cover every contract")
+	}
+
+	st.Contracts = []string{"ct1"}
+
+	if !st.covers("ct1") {
+		t.Fatal("Maintenance should cover listed contract")
+	} else if st.covers("ct2") {
+		t.Fatal("Maintenance shouldn't cover unlisted contract")
+	}
+}
+
+func TestMaintenanceWith(t *testing.T) {
+	all := map[string]string{"a": "https://a", "b": "https://b", "c": "https://c"}
+	st := maintenanceState{}
+
+	if st = st.with(true, []string{"b"}, all); !st.Enabled || !reflect.DeepEqual(st.Contracts, []string{"b"}) {
+		t.Fatalf("contract should be under maintenance %+v", st)
+	}
+
+	if st = st.with(true, []string{"a"}, all); !reflect.DeepEqual(st.Contracts, []string{"a", "b"}) {
+		t.Fatalf("contract should be added %+v", st)
+	}
+
+	if st = st.with(false, []string{"b"}, all); !st.Enabled || !reflect.DeepEqual(st.Contracts, []string{"a"}) {
+		t.Fatalf("contract should be removed %+v", st)
+	}
+
+	if st = st.with(false, []string{"a"}, all); st.Enabled || st.Contracts != nil {
+		t.Fatalf("maintenance should be disabled without contracts %+v", st)
+	}
+
+	if st = st.with(true, nil, all); !st.Enabled || st.Contracts != nil {
+		t.Fatalf("every contract should be under maintenance %+v", st)
+	}
+
+	if st = st.with(true, []string{"a"}, all); !st.Enabled || st.Contracts != nil {
+		t.Fatalf("every contract should stay under maintenance %+v", st)
+	}
+
+	if st = st.with(false, []string{"a"}, all); !reflect.DeepEqual(st.Contracts, []string{"b", "c"}) {
+		t.Fatalf("other contracts should stay under maintenance %+v", st)
+	}
+
+	if st = st.with(false, nil, all); st.Enabled || st.Contracts != nil {
+		t.Fatalf("maintenance should be disabled %+v", st)
+	}
+}
+
+func TestSetMaintenance(t *testing.T) {
+	m, ids := maintenanceManager(t, "a.example.com", "b.example.com")
+	a, b := ids["a.example.com"], ids["b.example.com"]
+
+	// contracts by id or service contract URL are added
+	if _, err := m.SetMaintenance(true, []string{a}, "upgrade"); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := m.SetMaintenance(true, []string{"https://b.example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	both := []string{a, b}
+	if b < a {
+		both = []string{b, a}
+	}
+
+	if !reflect.DeepEqual(ms.Active, both) || ms.Reason != "upgrade" {
+		t.Fatalf("both contracts should be under maintenance %+v", ms)
+	} else if f := flagged(m); !f[a] || !f[b] {
+		t.Fatalf("both contracts should be flagged %+v", f)
+	}
+
+	// enrollment is skipped while in maintenance
+	st := m.Controller.Status()
+	if !m.held(a, st[a].Flags) || !m.held(b, st[b].Flags) {
+		t.Fatal("contracts in maintenance should be held")
+	}
+
+	if ms, err = m.SetMaintenance(false, []string{a}, ""); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ms.Active, []string{b}) {
+		t.Fatalf("only the other contract should be under maintenance %+v", ms)
+	} else if f := flagged(m); f[a] || !f[b] {
+		t.Fatalf("only the other contract should be flagged %+v", f)
+	}
+
+	st = m.Controller.Status()
+	if m.held(a, st[a].Flags) {
+		t.Fatal("contract out of maintenance should not be held")
+	}
+
+	if _, err = m.SetMaintenance(true, []string{"https://unknown.example.com"}, ""); err == nil {
+		t.Fatal("unknown contract should be refused")
+	}
+
+	// the state survives restarts
+	restarted := &Manager{Controller: m.Controller, fm: m.fm}
+	if err = loadMaintenance(restarted); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(restarted.maintenance.state, m.maintenance.state) {
+		t.Fatalf("maintenance state should be restored %+v", restarted.maintenance.state)
+	}
+
+	if ms, err = m.SetMaintenance(false, nil, ""); err != nil {
+		t.Fatal(err)
+	} else if ms.Enabled || len(ms.Active) != 0 || len(flagged(m)) != 0 {
+		t.Fatalf("maintenance should be disabled %+v", ms)
+	}
+}
+
+func TestSetMaintenanceStoreError(t *testing.T) {
+	m, ids := maintenanceManager(t, "a.example.com")
+
+	// the state file cannot be written over a directory
+	if err := os.MkdirAll(m.fm.Path(filenames.Maintenance, "blocker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.SetMaintenance(true, nil, "upgrade"); err == nil {
+		t.Fatal("unstored maintenance state should fail")
+	} else if m.maintenance.state.Enabled || len(flagged(m)) != 0 {
+		t.Fatalf("unstored maintenance state should not apply %+v", m.maintenance.state)
+	} else if st := m.Controller.Status(); m.held(ids["a.example.com"], st[ids["a.example.com"]].Flags) {
+		t.Fatal("contract should not be held")
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	m, ids := maintenanceManager(t, "a.example.com", "b.example.com")
+	now := time.Now()
+
+	m.maintenance.windows = []relaycfg.MaintenanceWindow{{
+		Start:     now.Add(-time.Minute),
+		End:       now.Add(time.Minute),
+		Contracts: []texturl.URL{*texturl.URLMustParse("https://a.example.com")},
+	}, {
+		Start: now.Add(time.Hour),
+		End:   now.Add(2 * time.Hour),
+	}, {
+		Start: now.Add(-2 * time.Hour),
+		End:   now.Add(-time.Hour),
+	}}
+	m.applyMaintenance()
+
+	if f := flagged(m); !f[ids["a.example.com"]] || f[ids["b.example.com"]] {
+		t.Fatalf("only the contract of the open window should be flagged %+v", f)
+	}
+
+	ms := m.MaintenanceStatus()
+	if ms.Enabled || !reflect.DeepEqual(ms.Active, []string{ids["a.example.com"]}) {
+		t.Fatalf("window should not enable the manual maintenance %+v", ms)
+	} else if len(ms.Windows) != 2 || !reflect.DeepEqual(ms.Windows[0].Contracts, []string{ids["a.example.com"]}) {
+		t.Fatalf("past windows should be skipped %+v", ms.Windows)
+	}
+
+	// closed window
+	m.maintenance.windows = m.maintenance.windows[1:]
+	m.applyMaintenance()
+
+	if f := flagged(m); len(f) != 0 {
+		t.Fatalf("no contract should be flagged out of windows %+v", f)
+	}
+}
@@ -10,8 +10,13 @@ import (
 	"github.com/wireleap/common/api/texturl"
 	"github.com/wireleap/common/cli/fsdir"
 	"github.com/wireleap/common/cli/upgrade"
+	"github.com/wireleap/relay/api/atomicfile"
 	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/api/events"
+	"github.com/wireleap/relay/api/map_counter"
+	"github.com/wireleap/relay/api/netcap"
 	"github.com/wireleap/relay/api/synccounters"
+	"github.com/wireleap/relay/api/timeframe"
 	"github.com/wireleap/relay/filenames"
 	"github.com/wireleap/relay/relaycfg"
 	"github.com/wireleap/relay/relaylib"
@@ -23,27 +28,28 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	netCapSoftLimit = 0.9
-	netCapHardLimit = 0.93
-)
-
 const (
 	okCap = iota
 	softCap
 	hardCap
 )
 
+var capNames = map[int]string{softCap: "soft", hardCap: "hard"}
+
+// Number of events replayed to API REST subscribers
+const eventsBacklog = 256
+
 var ErrMissingConf = errors.New("missing configuration: wireleap:// listening is not enabled")
 
 // Network usage config holder
 type netStatsCfg struct {
 	archiveDir    *string
 	writeInterval time.Duration
-	timeframe     time.Duration
+	timeframe     timeframe.T
 }
 
 // Load clean network usage config from file
@@ -53,9 +59,9 @@ func loadNSCfg(c *relaycfg.C) netStatsCfg {
 		writeInterval = time.Duration(*c.NetUsage.WriteInterval)
 	}
 
-	timeframe := time.Duration(c.NetUsage.Timeframe)
+	timeframe := c.NetUsage.Timeframe
 
-	if timeframe != time.Duration(0) {
+	if !timeframe.IsZero() {
 		if writeInterval == time.Duration(0) {
 			log.Println("network usage measurement is disabled, write_interval must be set")
 			return netStatsCfg{}
@@ -73,7 +79,7 @@ func loadNSCfg(c *relaycfg.C) netStatsCfg {
 
 // Returns if network usage telemetry is enabled
 func (n netStatsCfg) Enabled() bool {
-	return n.timeframe != time.Duration(0)
+	return !n.timeframe.IsZero()
 }
 
 // Returns if network usage telemetry archive is enabled
@@ -81,16 +87,93 @@ func (n netStatsCfg) Archive() bool {
 	return n.archiveDir != nil
 }
 
+// Returns if o measures the network usage the same way
+func (n netStatsCfg) equal(o netStatsCfg) bool {
+	return n.writeInterval == o.writeInterval && sameJSON(n.archiveDir, o.archiveDir) && sameJSON(n.timeframe, o.timeframe)
+}
+
 // Network Caps
 type cap struct {
-	soft uint64
-	hard uint64
+	limit  uint64
+	soft   uint64
+	hard   uint64
+	policy netcap.Policy
+}
+
+// Returns the cap of a limit according to a policy
+// Without soft threshold, the soft cap matches the hard cap.
+func newCap(limit uint64, p netcap.Policy) (c cap) {
+	c.limit, c.policy = limit, p
+	if p.Hard != nil {
+		c.hard = p.Hard.Limit.Apply(limit)
+	} else {
+		c.hard = limit
+	}
+
+	if p.Soft != nil {
+		c.soft = p.Soft.Limit.Apply(limit)
+	} else {
+		c.soft = c.hard
+	}
+	return
+}
+
+// Returns the threshold of a cap level
+func (c cap) threshold(level int) *netcap.Threshold {
+	switch level {
+	case softCap:
+		return c.policy.Soft
+	case hardCap:
+		return c.policy.Hard
+	}
+	return nil
+}
+
+// Returns the action of a cap level
+func (c cap) action(level int) string {
+	if t := c.threshold(level); t != nil {
+		return t.Action
+	}
+	return ""
+}
+
+// Returns if the action of a cap level prevents the enrollment
+func (c cap) blocks(level int) bool {
+	a := c.action(level)
+	return a == netcap.Disenroll || a == netcap.Disable
+}
+
+// Returns the throttling rate of a cap level, 0 if not throttled
+// Throttling of the soft level applies to the hard level too.
+func (c cap) rate(level int) uint64 {
+	for l := level; l > okCap; l-- {
+		if t := c.threshold(l); t != nil && t.Action == netcap.Throttle {
+			return t.Rate.Bytes()
+		}
+	}
+	return 0
 }
 
 // Network usage limiter config holder
 type netCapsCfg struct {
-	contractCaps func() map[string]uint64
-	globalCap    uint64
+	contractCaps     func() map[string]uint64
+	contractPolicies func() map[string]*netcap.Policy
+	contractWindows  func() map[string]map[string]uint64
+	contractPacing   func() map[string]bool
+	// contractPriorities and contractReserved apply to the global cap
+	contractPriorities func() map[string]int
+	contractReserved   func() map[string]uint64
+	globalCap          uint64
+	// globalPolicy applies to the global cap
+	globalPolicy netcap.Policy
+	// policy applies to the contract caps
+	policy netcap.Policy
+	// windows replace the caps during their time window
+	windows []relaycfg.UsageWindow
+	// pacing applies to the contract caps
+	pacing bool
+	// priorityLevels shed lower priority contracts as the global usage grows
+	priorityLevels []relaycfg.PriorityLevel
 }
 
 // Returns network usage limtter clean config
@@ -99,61 +182,93 @@ func newNCCfg() netCapsCfg {
 		contractCaps: func() map[string]uint64 {
 			return map[string]uint64{}
 		},
+		contractPolicies: func() map[string]*netcap.Policy {
+			return map[string]*netcap.Policy{}
+		},
+		contractWindows: func() map[string]map[string]uint64 {
+			return map[string]map[string]uint64{}
+		},
+		contractPacing: func() map[string]bool {
+			return map[string]bool{}
+		},
+		contractPriorities: func() map[string]int {
+			return map[string]int{}
+		},
+		contractReserved: func() map[string]uint64 {
+			return map[string]uint64{}
+		},
+		globalPolicy: globalPolicy(nil),
+		policy:       netcap.DefaultPolicy(),
 	}
 }
 
+// Returns the global cap policy, the global cap has no soft threshold by default
+func globalPolicy(p *netcap.Policy) netcap.Policy {
+	return p.Merge(netcap.Policy{Hard: netcap.DefaultPolicy().Hard})
+}
+
 // Partially load clean network usage limiter config from file
 func (cfg *netCapsCfg) loadNCCfg(c *relaycfg.C) {
 	cfg.globalCap = uint64(c.NetUsage.GlobalLimit)
+	cfg.globalPolicy = globalPolicy(c.NetUsage.Policy)
+	cfg.policy = c.NetUsage.Policy.Merge(netcap.DefaultPolicy())
+	cfg.windows = c.NetUsage.Windows
+	cfg.pacing = c.NetUsage.Pacing
+	cfg.priorityLevels = c.NetUsage.PriorityLevels
+}
+
+// Returns if o limits the network usage the same way, the contract limits
+// are read from the controller
+func (n netCapsCfg) equal(o netCapsCfg) bool {
+	return n.globalCap == o.globalCap && n.pacing == o.pacing &&
+		sameJSON(n.globalPolicy, o.globalPolicy) && sameJSON(n.policy, o.policy) &&
+		sameJSON(n.windows, o.windows) && sameJSON(n.priorityLevels, o.priorityLevels)
 }
 
 // Returns if network usage limiter is enabled
 func (n netCapsCfg) Enabled() bool {
-	return n.globalCap != 0 || len(n.contractCaps()) > 0
+	return n.globalCap != 0 || len(n.windows) > 0 || (n.contractCaps != nil && len(n.contractCaps()) > 0)
 }
 
-// Retuns new cap calculation
-func (_ netCapsCfg) capCalc(orig, factor *float64) uint64 {
-	return uint64(*orig * *factor)
-}
+// Retuns new map and global soft+hard cap calculations, the limits of the
+// active network usage window replace the regular ones
+func (n netCapsCfg) Caps() (resCaps map[string]cap, resGlobal cap) {
+	w := n.window(time.Now())
 
-// Retuns new map and global cap calculations
-func (n netCapsCfg) xCap(factor float64) (resCaps map[string]uint64, resGlobal uint64) {
-	contractCaps := n.contractCaps()
+	globalCap := n.globalCap
+	if w != nil && w.Unmetered {
+		globalCap = 0
+	} else if w != nil && w.GlobalLimit != 0 {
+		globalCap = w.GlobalLimit.Bytes()
+	}
+	resGlobal = newCap(globalCap, n.globalPolicy)
 
-	resGlobal = uint64(float64(n.globalCap) * factor)
+	contractCaps := n.contractCaps()
+	policies := n.contractPolicies()
 
-	resCaps = make(map[string]uint64, len(contractCaps))
-	for k, u := range contractCaps {
-		resCaps[k] = uint64(float64(u) * factor)
-	}
-	return
-}
+	if w != nil {
+		windowCaps := n.contractWindows()
+		limits := make(map[string]uint64, len(contractCaps))
 
-// Retuns new map and global soft cap calculations
-func (n netCapsCfg) SoftCap() (map[string]uint64, uint64) {
-	return n.xCap(netCapSoftLimit)
-}
+		for k, u := range contractCaps {
+			limits[k] = u
+		}
 
-// Retuns new map and global hard cap calculations
-func (n netCapsCfg) HardCap() (map[string]uint64, uint64) {
-	return n.xCap(netCapHardLimit)
-}
+		for k, wcs := range windowCaps {
+			if u, ok := wcs[w.Name]; ok {
+				limits[k] = u
+			}
+		}
 
-// Retuns new map and global soft+hard cap calculations
-func (n netCapsCfg) Caps() (resCaps map[string]cap, resGlobal cap) {
-	resGlobal = cap{
-		soft: uint64(float64(n.globalCap) * netCapSoftLimit),
-		hard: uint64(float64(n.globalCap) * netCapHardLimit),
+		if w.Unmetered {
+			limits = map[string]uint64{}
+		}
+		contractCaps = limits
 	}
 
-	contractCaps := n.contractCaps()
-
 	resCaps = make(map[string]cap, len(contractCaps))
 	for k, u := range contractCaps {
-		soft := uint64(float64(u) * netCapSoftLimit) // softFactor
-		hard := uint64(float64(u) * netCapHardLimit) // hardFactor
-		resCaps[k] = cap{soft, hard}
+		resCaps[k] = newCap(u, policies[k].Merge(n.policy))
 	}
 	return
 }
@@ -170,16 +285,28 @@ func (n netStats) Enabled() bool {
 	return n.cfg.Enabled() && n.Active.Enabled()
 }
 
-func (n netStats) GetNextReset() (time.Time, bool) {
-	cap_duration := time.Duration(n.cfg.timeframe)
-	return n.Active.GetNextReset(cap_duration)
+// Returns time next Reset in the future, the start of the current period,
+// and if NS should have been already reset
+func (n netStats) GetNextReset() (next, since time.Time, reset bool) {
+	if c := n.cfg.timeframe.Calendar; c != nil {
+		return n.Active.GetNextScheduledReset(c)
+	}
+
+	cap_duration := n.cfg.timeframe.Duration
+	next, reset = n.Active.GetNextReset(cap_duration)
+	return next, next.Add(-cap_duration), reset
 }
 
 // Contract Manager functions
 type netFns struct {
 	lock           sync.Mutex
+	stop           chan struct{}
+	wg             sync.WaitGroup
+	metered        int32
+	capped         int32
 	storeStats     func()
-	getReachedCaps func() (bool, map[string]int)
+	writeJournal   func()
+	getReachedCaps func() (globalCaps, map[string]int)
 	checkStats     func()
 	resetStats     func(time.Time)
 	nextReset      time.Time
@@ -187,40 +314,69 @@ type netFns struct {
 
 // Contract Manager Network Status
 type networkUsage struct {
-	Since *int64  `json:"timeframe_since"`
-	Until *int64  `json:"timeframe_until"`
-	Cap   *uint64 `json:"cap"`
-	Usage *uint64 `json:"usage"`
+	Since     *int64            `json:"timeframe_since"`
+	Until     *int64            `json:"timeframe_until"`
+	Cap       *uint64           `json:"cap"`
+	Usage     *uint64           `json:"usage"`
+	Window    string            `json:"window,omitempty"`
+	Windows   map[string]uint64 `json:"windows,omitempty"`
+	Rate      *uint64           `json:"rate,omitempty"`
+	TimeToCap *int64            `json:"time_to_cap,omitempty"`
 }
 
 // Contract Manager Status
 type managerStatus struct {
-	ControllerStarted bool          `json:"controller_started"`
-	Network           *networkUsage `json:"network_usage"`
-	RelayStatus       []relayStatus `json:"relay_status"`
+	ControllerStarted bool               `json:"controller_started"`
+	Network           *networkUsage      `json:"network_usage"`
+	Maintenance       *maintenanceStatus `json:"maintenance,omitempty"`
+	RelayStatus       []relayStatus      `json:"relay_status"`
 }
 
 // Relay status extended
 type relayStatus struct {
-	Id       string              `json:"id"`
-	Addr     *texturl.URL        `json:"address"`
-	Role     string              `json:"role"`
-	Status   relaylib.RelayFlags `json:"status"`
-	NetCap   *uint64             `json:"network_cap"`
-	NetUsage uint64              `json:"network_usage"`
+	Id        string              `json:"id"`
+	Addr      *texturl.URL        `json:"address"`
+	Role      string              `json:"role"`
+	Status    relaylib.RelayFlags `json:"status"`
+	NetCap    *uint64             `json:"network_cap"`
+	NetUsage  uint64              `json:"network_usage"`
+	NetRate   uint64              `json:"network_rate"`
+	TimeToCap *int64              `json:"time_to_cap"`
+	Paced     bool                `json:"paced,omitempty"`
+	Priority  int                 `json:"priority,omitempty"`
+	Reserved  *uint64             `json:"network_reserved,omitempty"`
+	Shed      bool                `json:"shed,omitempty"`
+	Override  *override           `json:"override,omitempty"`
 }
 
 // Contract Manager
 type Manager struct {
-	Controller  *relaylib.Controller
+	Controller *relaylib.Controller
+	// PendingST returns the number of sharetokens pending submission, by contractId
+	PendingST func(string) int
+	// Events receives the relay events
+	Events      *events.Bus
 	pubkey      string
 	autoupgrade bool
 	upgradecfg  *upgrade.Config
 	upgradechan chan *status.T
 	NetStats    netStats
 	netCaps     netCapsCfg
+	// cfgLock guards NetStats and netCaps, swapped on reload with
+	// netFns.lock held too
+	cfgLock     sync.RWMutex
 	netFns      netFns
+	maintenance maintenance
+	overrides   overrides
+	health      health
+	throttles   throttles
+	windows     usageWindows
+	forecast    forecast
+	shedding    shedding
+	journal     usageJournal
+	janitor     archiveJanitor
 	fm          fsdir.T
+	done        chan struct{}
 	stopOnce    sync.Once
 }
 
@@ -232,6 +388,9 @@ func NewManager(fm fsdir.T, c *relaycfg.C, pubkey string, cl *client.Client) (m
 	callback := make(chan *status.T)
 	controller := relaylib.NewController(cl, callback)
 
+	ev := events.New(eventsBacklog)
+	controller.Events = ev
+
 	if err = controller.Load(c); err != nil {
 		return
 	}
@@ -250,10 +409,16 @@ func NewManager(fm fsdir.T, c *relaycfg.C, pubkey string, cl *client.Client) (m
 		// Load NetworkCap Cfg
 		nc.loadNCCfg(c)
 		nc.contractCaps = controller.NetCap
+		nc.contractPolicies = controller.NetCapPolicy
+		nc.contractWindows = controller.NetCapWindows
+		nc.contractPacing = controller.NetCapPacing
+		nc.contractPriorities = controller.NetCapPriority
+		nc.contractReserved = controller.NetCapReserved
 	}
 
 	m = &Manager{
 		Controller:  controller,
+		Events:      ev,
 		pubkey:      pubkey, // jsonb.PK(pk).String()
 		autoupgrade: c.AutoUpgrade,
 		upgradecfg:  upgrade.NewConfig(fm, "wireleap-relay", false),
@@ -261,32 +426,74 @@ func NewManager(fm fsdir.T, c *relaycfg.C, pubkey string, cl *client.Client) (m
 		NetStats:    ns,
 		netCaps:     nc,
 		fm:          fm,
+		done:        make(chan struct{}),
+	}
+
+	m.health.addr, m.health.cfg = *c.Address, c.Health
+	m.janitor.archives = loadArchives(fm, c)
+	m.maintenance.windows = c.Maintenance.Windows
+	if err = loadMaintenance(m); err != nil {
+		err = fmt.Errorf("could not load maintenance state: %w", err)
+	} else if err = loadOverrides(m); err != nil {
+		err = fmt.Errorf("could not load contract overrides: %w", err)
 	}
 	return
 }
 
 func (m *Manager) setNetStats() {
-	m.netFns.storeStats = func() {
+	storeStats := func() {
 		m.netFns.lock.Lock()
 		defer m.netFns.lock.Unlock()
 
-		if fns, err := saveStats(m.NetStats.Active, m.Controller.Contracts(), m.NetStats.legacy); err != nil {
-			log.Print(err)
-		} else if errS := m.fm.SetIndented(fns, filenames.Stats); errS != nil {
-			log.Fatalf("could not store network usage file: %s", errS)
-		}
+		m.accountWindows()
+		m.saveStats()
+	}
+
+	m.netFns.lock.Lock()
+	m.netFns.storeStats = storeStats
+	m.netFns.writeJournal = m.writeJournal
+	m.netFns.lock.Unlock()
+}
+
+// Returns a network usage routine, nil if it is not running
+func (m *Manager) netFn(fn *func()) func() {
+	m.netFns.lock.Lock()
+	defer m.netFns.lock.Unlock()
+	return *fn
+}
+
+// Returns the contracts caps status, from the current routine
+func (m *Manager) reachedCaps() (globalCaps, map[string]int) {
+	m.netFns.lock.Lock()
+	f := m.netFns.getReachedCaps
+	m.netFns.lock.Unlock()
+	return f()
+}
+
+// Returns the end of the current network usage period
+func (m *Manager) periodEnd() time.Time {
+	m.netFns.lock.Lock()
+	defer m.netFns.lock.Unlock()
+	return m.netFns.nextReset
+}
+
+// Store the stats file atomically, then truncate the journal (lock held)
+func (m *Manager) saveStats() {
+	if fns, err := saveStats(m.NetStats.Active, m.Controller.Contracts(), m.NetStats.legacy); err != nil {
+		log.Print(err)
+	} else if errS := atomicfile.SetIndented(m.fm, fns, filenames.Stats); errS != nil {
+		log.Fatalf("could not store network usage file: %s", errS)
+	} else {
+		m.truncateJournal(fns)
 	}
 }
 
 func (m *Manager) setReachedCaps() {
-	m.netFns.getReachedCaps = func() (globalCap bool, reachedCaps map[string]int) {
+	getReachedCaps := func() (global globalCaps, reachedCaps map[string]int) {
 
 		// gather external data sources
 		contracts := m.Controller.Contracts()
-		caps, globalXCap := m.netCaps.Caps()
-
-		// initialise global counter
-		sum := uint64(0)
+		caps, globalXCap := m.capsCfg().Caps()
 
 		// Init result map
 		reachedCaps = make(map[string]int, len(contracts))
@@ -297,42 +504,29 @@ func (m *Manager) setReachedCaps() {
 		m.netFns.lock.Lock()
 		defer m.netFns.lock.Unlock()
 
-		f := func(contract string, contractBytes *synccounters.ContractCounter) bool {
-			if contractBytes == nil {
-				log.Printf("Contract metric %s returned nil value", contract)
-				// To check, maybe it's better to abort returning false
-				return true
-			}
-
-			i := contractBytes.Sum()
-
-			if m.netCaps.globalCap != 0 {
-				sum = sum + i
-			}
+		m.accountWindows()
+		usage, sum := m._capUsage()
 
-			if ct_cap, ok := caps[contract]; !ok {
-				// pass
-			} else if i > ct_cap.hard {
+		for contract, ct_cap := range caps {
+			if i := usage[contract]; i > ct_cap.hard {
 				reachedCaps[contract] = hardCap
 			} else if i > ct_cap.soft {
 				reachedCaps[contract] = softCap
 			}
-			return true
-		}
-
-		m.NetStats.Active.ContractStats.Range(f)
-
-		if m.netCaps.globalCap != 0 {
-			globalCap = sum >= globalXCap.hard
 		}
 
+		global = m.netCaps.globalCaps(globalXCap, usage, sum)
 		return
 	}
+
+	m.netFns.lock.Lock()
+	m.netFns.getReachedCaps = getReachedCaps
+	m.netFns.lock.Unlock()
 }
 
 func (m *Manager) setReachedCapsMock() {
-	m.netFns.getReachedCaps = func() (globalCap bool, reachedCaps map[string]int) {
-		globalCap = false
+	getReachedCaps := func() (global globalCaps, reachedCaps map[string]int) {
+		global.exempt = map[string]bool{}
 
 		// gather external data sources
 		contracts := m.Controller.Contracts()
@@ -345,75 +539,137 @@ func (m *Manager) setReachedCapsMock() {
 
 		return
 	}
+
+	m.netFns.lock.Lock()
+	m.netFns.getReachedCaps = getReachedCaps
+	m.netFns.lock.Unlock()
+}
+
+// Apply the action of a reached cap to a contract
+func (m *Manager) applyCap(cid string, rs relaylib.RelayStatus, capName, action string) {
+	disable := action == netcap.Disable && !rs.Flags.NetCapReached
+
+	if rs.Flags.Enrolled || disable {
+		m.Events.Emit(events.NetCapReached, cid, map[string]string{"cap": capName, "action": action})
+	}
+
+	if rs.Flags.Enrolled {
+		if err := m.Controller.Disenroll(cid); err != nil {
+			log.Printf("Error while disenrolling, %s", err.Error())
+		} else {
+			log.Printf("Network Cap: Disenrolling from contract %s", cid)
+		}
+	}
+
+	if disable {
+		if err := m.Controller.Disable(cid); err != nil {
+			log.Printf("Error while disabling, %s", err.Error())
+		} else {
+			log.Printf("Network Cap: Disabling from contract %s", cid)
+		}
+	}
 }
 
 func (m *Manager) setCheckStats() {
-	m.netFns.checkStats = func() {
+	checkStats := func() {
 		// Retrieve current net cap status
-		globalCap, reachedCaps := m.netFns.getReachedCaps()
+		global, reachedCaps := m.reachedCaps()
+		caps, globalXCap := m.capsCfg().Caps()
+		priorities := m.capsCfg().contractPriorities()
 
 		relaystatus := m.Controller.Status()
 
-		if globalCap {
-			// Disenrolling all
-			for cid, rs := range relaystatus {
-				if rs.Flags.Enrolled {
-					if err := m.Controller.Disenroll(cid); err != nil {
-						log.Printf("Error while disenrolling, %s", err.Error())
-					} else {
-						log.Printf("Network Cap: Disenrolling from contract %s", cid)
-					}
-				}
+		// Track usage rates
+		now := time.Now()
+		total, _ := m.netUsage()
+		m.forecast.update(total, now)
+		usage, _ := m.capUsage()
 
-				if !rs.Flags.NetCapReached {
-					if err := m.Controller.Disable(cid); err != nil {
-						log.Printf("Error while disabling, %s", err.Error())
-					} else {
-						log.Printf("Network Cap: Disabling from contract %s", cid)
-					}
-				}
-			}
-			return
-		} else {
-			// Disenrolling relays
-			for cid, capType := range reachedCaps {
-				if capType == okCap {
-					// No cap reached
-					continue
-				}
+		rate, capName := globalXCap.rate(global.level), "global"
+		if wr := m.capsCfg().windowRate(now); wr != 0 && (rate == 0 || wr < rate) {
+			// network usage window bandwidth limit
+			rate, capName = wr, "window"
+		}
+		m.setThrottle("", capName, rate)
 
-				// At least softCap was reached
-				rs := relaystatus[cid]
-				if rs.Flags.Enrolled {
-					if err := m.Controller.Disenroll(cid); err != nil {
-						log.Printf("Error while disenrolling, %s", err.Error())
-					} else {
-						log.Printf("Network Cap: Disenrolling from contract %s", cid)
-					}
-				}
+		// Disenrolling relays
+		for cid, capType := range reachedCaps {
+			ct_cap := caps[cid]
 
-				if !rs.Flags.NetCapReached && capType == hardCap {
-					if err := m.Controller.Disable(cid); err != nil {
-						log.Printf("Error while disabling, %s", err.Error())
-					} else {
-						log.Printf("Network Cap: Disabling from contract %s", cid)
-					}
-				}
+			if m.pinned(cid) {
+				// Manual action takes precedence
+				m.setThrottle(cid, "", 0)
+				m.setShed(cid, priorities[cid], false)
+				delete(relaystatus, cid)
+				continue
+			}
+
+			// Global cap reached, lower priority contracts go first
+			capName, action := global.action(cid, priorities[cid], globalXCap)
+			m.setShed(cid, priorities[cid], capName == "priority")
+			if action != "" {
+				m.applyCap(cid, relaystatus[cid], capName, action)
 				delete(relaystatus, cid)
+				continue
+			}
+
+			rate, capName := ct_cap.rate(capType), capNames[capType]
+			if pr := m.pace(cid, ct_cap, usage[cid], now); pr != 0 && (rate == 0 || pr < rate) {
+				// quota has to last until the end of the period
+				rate, capName = pr, "pacing"
 			}
+			m.setThrottle(cid, capName, rate)
+
+			if !ct_cap.blocks(capType) {
+				// No cap reached or throttled, relay stays enrolled
+				continue
+			}
+
+			// At least softCap was reached
+			m.applyCap(cid, relaystatus[cid], capNames[capType], ct_cap.action(capType))
+			delete(relaystatus, cid)
 		}
 
 		// Enrolling relays
 		for cid, rs := range relaystatus {
-			if !rs.Flags.Enrolled {
+			if m.held(cid, rs.Flags) {
+				// Relay will be reenrolled once maintenance or manual action ends
+				continue
+			} else if !rs.Flags.Enrolled {
 				if err := m.Controller.Enroll(cid); err != nil {
 					log.Printf("Error while reenrolling, %s", err.Error())
 				} else {
 					log.Printf("Network Cap: Reenrolling on contract %s", cid)
+					m.Events.Emit(events.NetCapReleased, cid, nil)
 				}
 			}
 		}
 	}
+
+	m.netFns.lock.Lock()
+	m.netFns.checkStats = checkStats
+	m.netFns.lock.Unlock()
+}
+
+// Reenroll contracts no longer held by maintenance or manual actions
+func (m *Manager) reenroll() {
+	if !m.Controller.Started() {
+		return
+	} else if f := m.netFn(&m.netFns.checkStats); f != nil {
+		// netcap logic takes care of the reenrollment
+		f()
+		return
+	}
+
+	for cid, rs := range m.Controller.Status() {
+		if rs.Flags.Enrolled || m.held(cid, rs.Flags) {
+			continue
+		} else if err := m.Controller.Enroll(cid); err != nil {
+			log.Printf("Error while reenrolling, %s", err.Error())
+		} else {
+			log.Printf("Reenrolling on contract %s", cid)
+		}
+	}
 }
 
 func (m *Manager) setResetStats() {
@@ -422,13 +678,13 @@ func (m *Manager) setResetStats() {
 		err     error
 	)
 
-	if !m.NetStats.cfg.Archive() {
+	if cfg := m.statsCfg(); !cfg.Archive() {
 		// pass
-	} else if archive, err = nustore.New(m.fm.Path(*m.NetStats.cfg.archiveDir)); err != nil {
+	} else if archive, err = nustore.New(m.fm.Path(*cfg.archiveDir)); err != nil {
 		log.Fatalf("could not initialize network usage archive: %s", err)
 	}
 
-	m.netFns.resetStats = func(t time.Time) {
+	resetStats := func(t time.Time) {
 		m.netFns.lock.Lock()
 		defer m.netFns.lock.Unlock()
 
@@ -439,8 +695,17 @@ func (m *Manager) setResetStats() {
 			cts[ct] = rs.Flags.Enrolled
 		}
 
+		// Account the last window usage of the period
+		m.accountWindows()
+		m.windows.last = map[string]uint64{}
+
+		m.cfgLock.Lock()
+		windows := m.NetStats.Active.ResetWindows()
 		since := m.NetStats.Active.CreatedAt
-		if r, ok := m.NetStats.Active.ResetWithDate(t); !ok {
+		r, ok := m.NetStats.Active.ResetWithDate(t)
+		m.cfgLock.Unlock()
+
+		if !ok {
 			log.Fatalf("could not reset network usage stats")
 		} else if archive != nil {
 			// Append legacy Stats if not nil, then reset
@@ -452,22 +717,48 @@ func (m *Manager) setResetStats() {
 			}
 
 			// Create record
-			f := nustore.NewArchiveFile(m.pubkey, cts, r, since, m.NetStats.Active.CreatedAt)
+			f := nustore.NewArchiveFile(m.pubkey, cts, r, windows, since, m.NetStats.Active.CreatedAt)
 
 			// Store record
 			if err := archive.Add(f); err != nil {
 				log.Fatalf("could not store network usage archive: %s", err)
 			}
+
+			m.Events.Emit(events.StatsArchived, "", map[string]int64{"since": since, "until": m.NetStats.Active.CreatedAt})
 		}
+
+		m.Events.Emit(events.StatsReset, "", map[string]int64{"since": m.NetStats.Active.CreatedAt})
+
+		// New period, manual enrollments are subject to netcap again
+		m.clearOverrides(ActionEnroll)
+		m.forecast.reset()
+
+		// Journal entries are relative to the stored period
+		m.saveStats()
 	}
+
+	m.netFns.lock.Lock()
+	m.netFns.resetStats = resetStats
+	m.netFns.lock.Unlock()
 }
 
 func (m *Manager) setNetUsageFns() {
-	if m.NetStats.Enabled() {
+	if enabled, _ := m.statsEnabled(); enabled {
+		defer atomic.StoreInt32(&m.netFns.metered, 1)
+		if m.capsCfg().Enabled() {
+			defer atomic.StoreInt32(&m.netFns.capped, 1)
+		}
+
+		// Start accounting windows usage and journaling from the loaded counters
+		m.netFns.lock.Lock()
+		m.accountWindows()
+		m.openJournal()
+		m.netFns.lock.Unlock()
+
 		m.setNetStats()
 		m.setResetStats()
 
-		if m.netCaps.Enabled() {
+		if m.capsCfg().Enabled() {
 			m.setCheckStats()
 			m.setReachedCaps()
 		} else {
@@ -479,64 +770,246 @@ func (m *Manager) setNetUsageFns() {
 }
 
 func (m *Manager) unsetNetUsageFns() {
+	atomic.StoreInt32(&m.netFns.metered, 0)
+	m.clearNetUsageFns()
+}
+
+// Clear the network usage routines, new tunnels are metered as before
+func (m *Manager) clearNetUsageFns() {
+	atomic.StoreInt32(&m.netFns.capped, 0)
+	m.setReachedCapsMock()
+
+	m.netFns.lock.Lock()
 	m.netFns.checkStats = nil
 	m.netFns.storeStats = nil
+	m.netFns.writeJournal = nil
 	m.netFns.resetStats = nil
-	m.setReachedCapsMock()
+	m.closeJournal()
+	m.netFns.lock.Unlock()
 }
 
-func (m *Manager) runNetUsageFns() {
-	if m.NetStats.Enabled() {
-		// Write stats periodically
-		go func() {
-			for range time.Tick(m.NetStats.cfg.writeInterval) {
-				if f := m.netFns.storeStats; f != nil {
+// Returns if new tunnels have to be metered
+func (m *Manager) Metered() bool {
+	return atomic.LoadInt32(&m.netFns.metered) == 1
+}
+
+// Returns if network usage limiter is running
+func (m *Manager) capped() bool {
+	return atomic.LoadInt32(&m.netFns.capped) == 1
+}
+
+// Returns the network usage config
+func (m *Manager) statsCfg() netStatsCfg {
+	m.cfgLock.RLock()
+	defer m.cfgLock.RUnlock()
+	return m.NetStats.cfg
+}
+
+// Returns if network usage telemetry is enabled, and the start of the
+// current period
+func (m *Manager) statsEnabled() (bool, int64) {
+	m.cfgLock.RLock()
+	defer m.cfgLock.RUnlock()
+	return m.NetStats.Enabled(), m.NetStats.Active.CreatedAt
+}
+
+// Returns the network usage limiter config
+func (m *Manager) capsCfg() netCapsCfg {
+	m.cfgLock.RLock()
+	defer m.cfgLock.RUnlock()
+	return m.netCaps
+}
+
+// ContractStats returns the network usage counters of the contracts.
+func (m *Manager) ContractStats() map_counter.Map {
+	m.cfgLock.RLock()
+	defer m.cfgLock.RUnlock()
+	return m.NetStats.Active.ContractStats
+}
+
+// Run the routine held by fn every interval, until it is cleared or the
+// network usage routines are stopped
+func (m *Manager) runEvery(stop chan struct{}, interval time.Duration, fn *func()) {
+	m.netFns.wg.Add(1)
+	go func() {
+		defer m.netFns.wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if f := m.netFn(fn); f != nil {
 					f()
 				} else {
 					return
 				}
 			}
-		}()
+		}
+	}()
+}
+
+func (m *Manager) runNetUsageFns() {
+	if enabled, _ := m.statsEnabled(); enabled {
+		stop := make(chan struct{})
+		m.netFns.stop = stop
+
+		// Write stats periodically, and the journal in between
+		m.runEvery(stop, m.statsCfg().writeInterval, &m.netFns.storeStats)
+		m.runEvery(stop, journalInterval, &m.netFns.writeJournal)
 
 		// Reset stats periodically
-		go func() {
-			var reset_now bool
+		next, since, reset_now := m.NetStats.GetNextReset()
 
-			// Reset now if record is too old
-			if m.netFns.nextReset, reset_now = m.NetStats.GetNextReset(); reset_now {
-				cap_duration := time.Duration(m.NetStats.cfg.timeframe)
-				m.netFns.resetStats(m.netFns.nextReset.Add(-cap_duration))
-			}
+		m.netFns.lock.Lock()
+		m.netFns.nextReset = next
+		resetStats := m.netFns.resetStats
+		m.netFns.lock.Unlock()
+
+		// Reset now if record is too old
+		if reset_now {
+			resetStats(since)
+		}
+
+		m.netFns.wg.Add(1)
+		go func() {
+			defer m.netFns.wg.Done()
 
 			for {
-				<-time.After(m.netFns.nextReset.Sub(time.Now()))
-				if f := m.netFns.resetStats; f != nil {
-					f(m.netFns.nextReset) // updates netstats.CreatedAt
-					m.netFns.nextReset, _ = m.NetStats.GetNextReset()
-
-					// Unleash the netcap
-					if f_c := m.netFns.checkStats; f_c != nil {
-						f_c()
-					}
-				} else {
+				t := time.NewTimer(time.Until(m.periodEnd()))
+				select {
+				case <-stop:
+					t.Stop()
+					return
+				case <-t.C:
+				}
+
+				m.netFns.lock.Lock()
+				f, end := m.netFns.resetStats, m.netFns.nextReset
+				m.netFns.lock.Unlock()
+
+				if f == nil {
 					return
 				}
+
+				f(end) // updates netstats.CreatedAt
+				next, _, _ := m.NetStats.GetNextReset()
+
+				m.netFns.lock.Lock()
+				m.netFns.nextReset = next
+				m.netFns.lock.Unlock()
+
+				// Unleash the netcap
+				if f_c := m.netFn(&m.netFns.checkStats); f_c != nil {
+					f_c()
+				}
 			}
 		}()
 
-		if m.netCaps.Enabled() {
-			go func() {
-				for range time.Tick(10 * time.Second) {
-					if f := m.netFns.checkStats; f != nil {
-						f()
-					} else {
-						return
-					}
-				}
-			}()
+		if m.capsCfg().Enabled() {
+			m.runEvery(stop, 10*time.Second, &m.netFns.checkStats)
+		}
+	}
+}
+
+// Stop the network usage routines, waiting for the running ones
+func (m *Manager) stopNetUsageFns() {
+	if m.netFns.stop != nil {
+		close(m.netFns.stop)
+		m.netFns.wg.Wait()
+		m.netFns.stop = nil
+	}
+}
+
+// Apply a new network usage configuration, starting or stopping the
+// measurement and the limiter without restarting the relay. Current
+// counters are carried over. Nothing changes if the configuration is the
+// same, or if the stats fail to load.
+func (m *Manager) reloadNetUsage(nsCfg netStatsCfg, nc netCapsCfg) (err error) {
+	m.cfgLock.RLock()
+	same := nsCfg.equal(m.NetStats.cfg) && nc.equal(m.netCaps)
+	load := nsCfg.Enabled() && !m.NetStats.Active.Enabled()
+	m.cfgLock.RUnlock()
+
+	// contract limits are read from the controller, they may enable or
+	// disable the limiter on their own
+	same = same && (!m.Metered() || m.capped() == nc.Enabled())
+
+	if same {
+		return
+	}
+
+	var (
+		active relaystats.NetStats
+		legacy map[string]uint64
+	)
+
+	if load {
+		// First time enabled, load previous stats if any
+		if active, legacy, err = loadStats(m.fm, m.Controller.Contracts()); err != nil {
+			err = fmt.Errorf("could not load network usage stats: %w", err)
+			return
 		}
 	}
 
+	wasMetered, wasCapped := m.Metered(), m.capped()
+
+	m.stopNetUsageFns()
+
+	if wasMetered {
+		// Store stats before switching
+		if f := m.netFn(&m.netFns.storeStats); f != nil {
+			f()
+		}
+	}
+
+	if wasMetered && nsCfg.Enabled() && m.Controller.Started() {
+		// New tunnels are metered while switching
+		m.clearNetUsageFns()
+	} else {
+		m.unsetNetUsageFns()
+	}
+
+	m.netFns.lock.Lock()
+	m.cfgLock.Lock()
+	m.NetStats.cfg, m.netCaps = nsCfg, nc
+	if load {
+		m.NetStats.Active, m.NetStats.legacy = active, legacy
+	}
+	m.cfgLock.Unlock()
+
+	if !wasMetered {
+		// Windows usage is accounted from the current counters
+		m.windows = usageWindows{}
+	}
+	m.netFns.lock.Unlock()
+
+	if !m.Controller.Started() {
+		return
+	}
+
+	m.setNetUsageFns()
+	m.runNetUsageFns()
+
+	if wasMetered != m.Metered() {
+		log.Printf("Network usage measurement enabled: %v", m.Metered())
+	}
+
+	if wasCapped != m.capped() {
+		log.Printf("Network usage limiter enabled: %v", m.capped())
+	}
+
+	if wasCapped && !m.capped() {
+		// Release throttled and capped contracts
+		m.releaseThrottles()
+		m.reenroll()
+	} else if f := m.netFn(&m.netFns.checkStats); f != nil {
+		f()
+	}
+	return
 }
 
 func (m *Manager) upgradeRunloop() {
@@ -552,15 +1025,28 @@ func (m *Manager) Start() error {
 
 	go m.upgradeRunloop()
 
+	// Flag contracts in maintenance before enrolling
+	m.applyMaintenance()
+	go m.runMaintenance()
+	go m.runJanitor()
+
 	// Prepare controller start
 	contracts := []string{}
-	globalCap, reachedCaps := m.netFns.getReachedCaps()
-
-	if !globalCap {
-		for contract, capType := range reachedCaps {
-			if capType == okCap {
-				contracts = append(contracts, contract)
-			}
+	global, reachedCaps := m.reachedCaps()
+	caps, globalXCap := m.capsCfg().Caps()
+	priorities := m.capsCfg().contractPriorities()
+	relaystatus := m.Controller.Status()
+
+	for contract, capType := range reachedCaps {
+		if m.held(contract, relaystatus[contract].Flags) {
+			// skip contracts in maintenance or manually disenrolled
+		} else if ov, ok := m.override(contract); ok && ov.Action == ActionEnroll {
+			// manually enrolled, regardless of netcap
+			contracts = append(contracts, contract)
+		} else if _, action := global.action(contract, priorities[contract], globalXCap); action != "" {
+			// global cap reached
+		} else if !caps[contract].blocks(capType) {
+			contracts = append(contracts, contract)
 		}
 	}
 
@@ -580,18 +1066,21 @@ func (m *Manager) Stop() {
 		log.Println(err.Error())
 	}
 
-	if m.NetStats.Enabled() {
+	m.stopNetUsageFns()
+
+	if enabled, _ := m.statsEnabled(); enabled {
 		// Store starts before exiting
-		if f := m.netFns.storeStats; f != nil {
+		if f := m.netFn(&m.netFns.storeStats); f != nil {
 			f()
 		}
 		m.unsetNetUsageFns()
 	}
 
-	// Close upgrade channel
+	// Close upgrade channel and background routines
 	m.stopOnce.Do(
 		func() {
 			close(m.upgradechan)
+			close(m.done)
 		},
 	)
 
@@ -612,40 +1101,45 @@ func (m *Manager) ReloadCfg(c *relaycfg.C) (err error) {
 		return
 	}
 
+	// Reload maintenance windows
+	m.maintenance.lock.Lock()
+	m.maintenance.windows = c.Maintenance.Windows
+	m.maintenance.lock.Unlock()
+	m.applyMaintenance()
+
+	// Reload readiness criteria
+	m.health.lock.Lock()
+	m.health.cfg = c.Health
+	m.health.lock.Unlock()
+
+	// Reload archives retention
+	m.janitor.lock.Lock()
+	m.janitor.archives = loadArchives(m.fm, c)
+	m.janitor.lock.Unlock()
+
 	// Reload Network usage configuration
-	if nsCfg := loadNSCfg(c); m.NetStats.cfg.Enabled() != nsCfg.Enabled() {
-		log.Println("please, restart the relay to enable or disable netStats")
-	} else {
-		m.NetStats.cfg = nsCfg
-	}
+	nsCfg := loadNSCfg(c)
 
-	if m.NetStats.cfg.Enabled() { // If Network usage is enabled
-		// Reload netCap
-		nc := newNCCfg()
+	nc := newNCCfg()
+	if nsCfg.Enabled() { // If Network usage is enabled
 		nc.loadNCCfg(c)
 		nc.contractCaps = m.Controller.NetCap
-
-		if m.netCaps.Enabled() != nc.Enabled() {
-			log.Println("please, restart the relay to enable or disable netCap")
-		} else if m.netCaps.Enabled() {
-			m.netCaps = nc
-		}
+		nc.contractPolicies = m.Controller.NetCapPolicy
+		nc.contractWindows = m.Controller.NetCapWindows
+		nc.contractPacing = m.Controller.NetCapPacing
+		nc.contractPriorities = m.Controller.NetCapPriority
+		nc.contractReserved = m.Controller.NetCapReserved
 	}
 
+	err = m.reloadNetUsage(nsCfg, nc)
 	return
 }
 
-func (m *Manager) Status() (ms managerStatus) {
-	contractCaps := m.netCaps.contractCaps()
-
-	crs := m.Controller.Status()
-
-	netUsage := map[string]uint64{}
+// Returns the current network usage, by contract and global
+func (m *Manager) netUsage() (netUsage map[string]uint64, sum uint64) {
+	netUsage = map[string]uint64{}
 
-	// initialise global counter
-	sum := uint64(0)
-
-	if m.NetStats.Enabled() {
+	if enabled, _ := m.statsEnabled(); enabled {
 		f := func(contract string, contractBytes *synccounters.ContractCounter) bool {
 			// 1) Check if nB has been initialised, 2) Check is not null, 3) Copy
 			if contractBytes == nil {
@@ -658,8 +1152,23 @@ func (m *Manager) Status() (ms managerStatus) {
 			}
 			return true
 		}
-		m.NetStats.Active.ContractStats.Range(f)
+		m.ContractStats().Range(f)
 	}
+	return
+}
+
+func (m *Manager) Status() (ms managerStatus) {
+	nc := m.capsCfg()
+	contractCaps := nc.contractCaps()
+	priorities := nc.contractPriorities()
+	reserved := nc.contractReserved()
+
+	crs := m.Controller.Status()
+	ovs := m.Overrides()
+
+	netUsage, sum := m.netUsage()
+	capUsage, capSum := m.capUsage()
+	caps, globalXCap := nc.Caps()
 
 	mrs := make([]relayStatus, 0, len(crs))
 	for cid, rs := range crs {
@@ -670,13 +1179,37 @@ func (m *Manager) Status() (ms managerStatus) {
 			nc = &i
 		}
 
+		var ov *override
+		if o, ok := ovs[cid]; ok {
+			ov = &o
+		}
+
+		var res *uint64
+		if r, ok := reserved[cid]; ok {
+			res = &r
+		}
+
+		rate := m.forecast.rate(cid)
+
+		var ttc *int64
+		if c, ok := caps[cid]; ok {
+			ttc = eta(capUsage[cid], c.soft, rate)
+		}
+
 		mrs = append(mrs, relayStatus{
-			Id:       cid,
-			Addr:     rs.Addr,
-			Role:     rs.Role,
-			Status:   rs.Flags,
-			NetCap:   nc,
-			NetUsage: nu,
+			Id:        cid,
+			Addr:      rs.Addr,
+			Role:      rs.Role,
+			Status:    rs.Flags,
+			NetCap:    nc,
+			NetUsage:  nu,
+			NetRate:   uint64(rate),
+			TimeToCap: ttc,
+			Paced:     m.forecast.isPaced(cid),
+			Priority:  priorities[cid],
+			Reserved:  res,
+			Shed:      m.shed(cid),
+			Override:  ov,
 		})
 	}
 
@@ -685,17 +1218,33 @@ func (m *Manager) Status() (ms managerStatus) {
 		RelayStatus:       mrs,
 	}
 
-	if m.NetStats.Enabled() {
-		until := epoch.ToEpochMillis(m.netFns.nextReset)
+	if mst := m.MaintenanceStatus(); mst.Enabled || len(mst.Active) > 0 || len(mst.Windows) > 0 {
+		ms.Maintenance = &mst
+	}
+
+	if enabled, since := m.statsEnabled(); enabled {
+		until := epoch.ToEpochMillis(m.periodEnd())
 
 		ms.Network = &networkUsage{
-			Since: &m.NetStats.Active.CreatedAt,
+			Since: &since,
 			Until: &until,
 			Usage: &sum,
 		}
 
-		if m.netCaps.Enabled() {
-			ms.Network.Cap = &m.netCaps.globalCap
+		if nc.Enabled() {
+			ms.Network.Cap = &nc.globalCap
+
+			rate := m.forecast.rate("")
+			r := uint64(rate)
+			ms.Network.Rate = &r
+
+			if globalXCap.limit != 0 {
+				ms.Network.TimeToCap = eta(capSum, globalXCap.soft, rate)
+			}
+		}
+
+		if len(nc.windows) > 0 {
+			ms.Network.Window, ms.Network.Windows = m.windowUsage()
 		}
 	}
 
@@ -704,9 +1253,9 @@ func (m *Manager) Status() (ms managerStatus) {
 
 // Force stats file storage
 func (m *Manager) StoreStats() {
-	if m.NetStats.Enabled() {
+	if enabled, _ := m.statsEnabled(); enabled {
 		// Store starts before exiting
-		if f := m.netFns.storeStats; f != nil {
+		if f := m.netFn(&m.netFns.storeStats); f != nil {
 			f()
 		}
 	}
@@ -728,12 +1277,14 @@ func (m *Manager) upgradeHandler(st *status.T) {
 				log.Printf("-- error getting changelog: %s --", err)
 			}
 			log.Printf("Upgrading to version %s...", v1s)
+			m.Events.Emit(events.Upgrade, "", map[string]string{"version": v1s, "action": "upgrading"})
 			// upgrade func will attempt rollback in case of failure so no need to do it here
 			if err = m.upgradecfg.Upgrade(upgrade.ExecutorSupervised, version.VERSION, v1); err != nil {
 				log.Printf(
 					"Could not upgrade to new wireleap-relay version %s: %s, skipping update.",
 					v1s, err,
 				)
+				m.Events.Emit(events.Upgrade, "", map[string]string{"version": v1s, "action": "failed", "error": err.Error()})
 				if err = m.upgradecfg.SkipVersion(v1); err != nil {
 					log.Printf(
 						"Could not persist skipped version %s: %s",
@@ -750,6 +1301,7 @@ func (m *Manager) upgradeHandler(st *status.T) {
 					return "this binary was not built with upgrade support"
 				}
 			}())
+			m.Events.Emit(events.Upgrade, "", map[string]string{"version": v1s, "action": "available"})
 			if err = m.upgradecfg.SkipVersion(v1); err != nil {
 				log.Printf(
 					"Could not persist skipped version %s: %s",
@@ -0,0 +1,199 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/api/timeframe"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaylib"
+	"github.com/wireleap/relay/relaystats"
+)
+
+func TestNetUsageFns(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "wltest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	fm, err := fsdir.New(tmpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Controller: relaylib.NewController(nil, nil), fm: fm}
+	nsCfg := netStatsCfg{writeInterval: 10 * time.Millisecond, timeframe: timeframe.T{Duration: time.Hour}}
+
+	// Enabling loads the stats
+	if err = m.reloadNetUsage(nsCfg, newNCCfg()); err != nil {
+		t.Fatal(err)
+	} else if !m.NetStats.Active.Enabled() {
+		t.Fatal("stats should be loaded")
+	} else if m.Metered() {
+		t.Fatal("stats shouldn't be metered before start")
+	}
+
+	m.setNetUsageFns()
+	m.runNetUsageFns()
+
+	if !m.Metered() {
+		t.Fatal("stats should be metered")
+	}
+
+	// The same configuration leaves the routines running
+	stop := m.netFns.stop
+	if err = m.reloadNetUsage(nsCfg, newNCCfg()); err != nil {
+		t.Fatal(err)
+	} else if m.netFns.stop != stop || !m.Metered() {
+		t.Fatal("unchanged configuration should not be reloaded")
+	}
+
+	c := m.ContractStats().GetOrInit("ct1")
+	c.Add(100)
+	c.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	m.stopNetUsageFns()
+
+	fns := relaystats.NewFileNetStats()
+	if err = fm.Get(fns, filenames.Stats); err != nil {
+		t.Fatal(err)
+	} else if cs, ok := fns.Get("ct1"); !ok || cs.NetworkBytes != 100 {
+		t.Fatal("stats should be stored periodically")
+	}
+
+	// Disabling keeps the counters in memory
+	m.unsetNetUsageFns()
+	if err = m.reloadNetUsage(netStatsCfg{}, newNCCfg()); err != nil {
+		t.Fatal(err)
+	} else if m.Metered() {
+		t.Fatal("stats shouldn't be metered")
+	}
+
+	if err = m.reloadNetUsage(nsCfg, newNCCfg()); err != nil {
+		t.Fatal(err)
+	} else if u, _ := m.netUsage(); u["ct1"] != 100 {
+		t.Fatal("counters should be carried over")
+	}
+}
+
+func TestNetUsageContractCaps(t *testing.T) {
+	fm, err := fsdir.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Controller: relaylib.NewController(nil, nil), fm: fm}
+	if err = m.Controller.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Controller.Stop()
+
+	nsCfg := netStatsCfg{writeInterval: time.Minute, timeframe: timeframe.T{Duration: time.Hour}}
+	caps := map[string]uint64{}
+	nc := newNCCfg()
+	nc.contractCaps = func() map[string]uint64 { return caps }
+
+	if err = m.reloadNetUsage(nsCfg, nc); err != nil {
+		t.Fatal(err)
+	} else if !m.Metered() || m.capped() {
+		t.Fatal("stats should be metered without limiter")
+	}
+	defer m.stopNetUsageFns()
+
+	// a lone contract limit enables the limiter
+	caps = map[string]uint64{"ct1": 1 << 30}
+	if err = m.reloadNetUsage(nsCfg, nc); err != nil {
+		t.Fatal(err)
+	} else if !m.capped() {
+		t.Fatal("contract limit should enable the limiter")
+	}
+
+	// and removing it disables the limiter
+	caps = map[string]uint64{}
+	if err = m.reloadNetUsage(nsCfg, nc); err != nil {
+		t.Fatal(err)
+	} else if !m.Metered() || m.capped() {
+		t.Fatal("limiter should be disabled without contract limits")
+	}
+}
+
+func TestNetUsageReloadRace(t *testing.T) {
+	fm, err := fsdir.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Controller: relaylib.NewController(nil, nil), fm: fm}
+	if err = m.Controller.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Controller.Stop()
+
+	nsCfg := netStatsCfg{writeInterval: time.Minute, timeframe: timeframe.T{Duration: time.Hour}}
+	capped := newNCCfg()
+	capped.globalCap = 1 << 30
+
+	if err = m.reloadNetUsage(nsCfg, newNCCfg()); err != nil {
+		t.Fatal(err)
+	}
+
+	// reloads switch the routines read by the REST handlers and the tickers
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.reenroll()
+			m.pace("ct1", newCap(1<<30, capped.policy), 0, time.Now())
+			m.StoreStats()
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		nc := newNCCfg()
+		if i%2 == 1 {
+			nc = capped
+		}
+
+		if err = m.reloadNetUsage(nsCfg, nc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	<-done
+	m.stopNetUsageFns()
+}
+
+func TestNetUsageLoadError(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "wltest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	fm, err := fsdir.New(tmpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(fm.Path(filenames.Stats), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Controller: relaylib.NewController(nil, nil), fm: fm}
+	nsCfg := netStatsCfg{writeInterval: time.Minute, timeframe: timeframe.T{Duration: time.Hour}}
+
+	// The previous configuration is kept
+	if err = m.reloadNetUsage(nsCfg, newNCCfg()); err == nil {
+		t.Fatal("invalid stats should fail to load")
+	} else if m.statsCfg().Enabled() {
+		t.Fatal("configuration should not be switched")
+	}
+}
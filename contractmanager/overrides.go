@@ -0,0 +1,176 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaylib"
+)
+
+// Manual contract actions
+const (
+	ActionEnroll    = "enroll"
+	ActionDisenroll = "disenroll"
+	ActionDisable   = "disable"
+	ActionEnable    = "enable"
+)
+
+var ErrUnknownAction = errors.New("unknown contract action")
+
+// Manual action taken by the operator on a contract
+// Contracts with an override are left alone by the netcap logic:
+// - enroll overrides last until the end of the current network usage period
+// - disenroll and disable overrides last until the contract is enrolled or enabled
+type override struct {
+	Action string `json:"action"`
+	At     int64  `json:"at"`
+}
+
+// Manual overrides holder
+type overrides struct {
+	lock sync.Mutex
+	m    map[string]override
+}
+
+// Load overrides from file
+func loadOverrides(m *Manager) (err error) {
+	ovs := map[string]override{}
+	if err = m.fm.Get(&ovs, filenames.Overrides); err == nil {
+		// Drop overrides of contracts no longer configured
+		scs := m.Controller.SCS()
+		for ct := range ovs {
+			if _, ok := scs[ct]; !ok {
+				delete(ovs, ct)
+			}
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	m.overrides.m = ovs
+	return
+}
+
+// Store overrides to file, lock must be held
+func (m *Manager) storeOverrides() error {
+	return m.fm.Set(m.overrides.m, filenames.Overrides)
+}
+
+// Returns the override of a contract
+func (m *Manager) override(contractId string) (ov override, ok bool) {
+	m.overrides.lock.Lock()
+	defer m.overrides.lock.Unlock()
+
+	ov, ok = m.overrides.m[contractId]
+	return
+}
+
+// Returns the current overrides
+func (m *Manager) Overrides() map[string]override {
+	m.overrides.lock.Lock()
+	defer m.overrides.lock.Unlock()
+
+	ovs := make(map[string]override, len(m.overrides.m))
+	for ct, ov := range m.overrides.m {
+		ovs[ct] = ov
+	}
+	return ovs
+}
+
+// Record or clear (if action is empty) a contract override
+func (m *Manager) setOverride(contractId, action string) (err error) {
+	m.overrides.lock.Lock()
+	defer m.overrides.lock.Unlock()
+
+	if action == "" {
+		delete(m.overrides.m, contractId)
+	} else {
+		m.overrides.m[contractId] = override{Action: action, At: epoch.EpochMillis()}
+	}
+
+	if err = m.storeOverrides(); err != nil {
+		err = fmt.Errorf("could not store contract overrides: %w", err)
+	}
+	return
+}
+
+// Clear all the overrides for a given action
+func (m *Manager) clearOverrides(action string) {
+	m.overrides.lock.Lock()
+	defer m.overrides.lock.Unlock()
+
+	n := len(m.overrides.m)
+	for ct, ov := range m.overrides.m {
+		if ov.Action == action {
+			delete(m.overrides.m, ct)
+			log.Printf("Network Cap: Releasing manual %s on contract %s", action, ct)
+		}
+	}
+
+	if n == len(m.overrides.m) {
+		return
+	} else if err := m.storeOverrides(); err != nil {
+		log.Printf("could not store contract overrides: %s", err)
+	}
+}
+
+// Perform a manual action on a contract and record it
+func (m *Manager) ContractAction(contractId, action string) (err error) {
+	switch action {
+	case ActionEnroll:
+		err = m.Controller.Enroll(contractId)
+	case ActionDisenroll:
+		err = m.Controller.Disenroll(contractId)
+	case ActionDisable:
+		// Disabled contracts must not accept new connections either
+		if rs, ok := m.Controller.Status()[contractId]; ok && rs.Flags.Enrolled {
+			err = m.Controller.Disenroll(contractId)
+		}
+
+		if err == nil {
+			err = m.Controller.Disable(contractId)
+		}
+	case ActionEnable:
+		err = m.Controller.Enable(contractId)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownAction, action)
+	}
+
+	if err != nil {
+		return
+	}
+
+	log.Printf("Manual action: %s on contract %s", action, contractId)
+
+	if action == ActionEnable {
+		// Back to automatic management
+		if err = m.setOverride(contractId, ""); err == nil {
+			m.reenroll()
+		}
+	} else {
+		err = m.setOverride(contractId, action)
+	}
+	return
+}
+
+// Returns if a contract should be left alone by the automatic logic
+func (m *Manager) pinned(contractId string) bool {
+	_, ok := m.override(contractId)
+	return ok
+}
+
+// Returns if a contract should not be enrolled by the automatic logic
+func (m *Manager) held(contractId string, flags relaylib.RelayFlags) bool {
+	if flags.Maintenance {
+		return true
+	}
+
+	ov, ok := m.override(contractId)
+	return ok && ov.Action != ActionEnroll
+}
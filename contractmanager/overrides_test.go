@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaylib"
+)
+
+func TestOverrides(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "cmtest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(tmpd)
+	})
+
+	m := &Manager{fm: fsdir.T(tmpd)}
+	m.overrides.m = map[string]override{}
+
+	if m.pinned("ct1") || m.held("ct1", relaylib.RelayFlags{}) {
+		t.Fatal("Contract without override shouldn't be pinned")
+	}
+
+	if !m.held("ct1", relaylib.RelayFlags{Maintenance: true}) {
+		t.Fatal("Contract in maintenance should be held")
+	}
+
+	if err = m.setOverride("ct1", ActionEnroll); err != nil {
+		t.Fatal(err)
+	} else if err = m.setOverride("ct2", ActionDisable); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.pinned("ct1") || m.held("ct1", relaylib.RelayFlags{}) {
+		t.Fatal("Manually enrolled contract should be pinned but not held")
+	} else if !m.pinned("ct2") || !m.held("ct2", relaylib.RelayFlags{}) {
+		t.Fatal("Manually disabled contract should be pinned and held")
+	}
+
+	// Overrides are persisted
+	ovs := map[string]override{}
+	if err = m.fm.Get(&ovs, filenames.Overrides); err != nil {
+		t.Fatal(err)
+	} else if len(ovs) != 2 {
+		t.Fatal("Overrides should be stored")
+	}
+
+	m.clearOverrides(ActionEnroll)
+
+	if m.pinned("ct1") {
+		t.Fatal("Manual enrollment should have been released")
+	} else if !m.pinned("ct2") {
+		t.Fatal("Manual disable shouldn't have been released")
+	}
+
+	if err = m.setOverride("ct2", ""); err != nil {
+		t.Fatal(err)
+	} else if len(m.Overrides()) != 0 {
+		t.Fatal("Overrides should be empty")
+	}
+}
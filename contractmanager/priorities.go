@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"log"
+	"sync"
+
+	"github.com/wireleap/relay/api/netcap"
+)
+
+// Global cap state of the contracts
+type globalCaps struct {
+	// level reached by the global usage, unused reserved shares included
+	level int
+	// priority is the minimum priority of the contracts kept enrolled
+	priority int
+	// exempt contracts have not used up their reserved share yet
+	exempt map[string]bool
+}
+
+// Returns the global cap state, the unused reserved shares are withheld
+// from the global limit so lower priority contracts are shed first
+func (n netCapsCfg) globalCaps(global cap, usage map[string]uint64, sum uint64) (g globalCaps) {
+	g.exempt = map[string]bool{}
+	if global.limit == 0 {
+		return
+	}
+
+	effective := sum
+	for cid, r := range n.contractReserved() {
+		if u := usage[cid]; u < r {
+			effective += r - u
+			g.exempt[cid] = true
+		}
+	}
+
+	if effective >= global.hard {
+		g.level = hardCap
+	} else if effective >= global.soft {
+		g.level = softCap
+	}
+
+	for _, l := range n.priorityLevels {
+		if effective >= l.Limit.Apply(global.limit) && l.Priority > g.priority {
+			g.priority = l.Priority
+		}
+	}
+	return
+}
+
+// Returns the global cap name and action applying to a contract, if any
+func (g globalCaps) action(cid string, priority int, global cap) (capName, action string) {
+	if g.exempt[cid] {
+		// reserved share left
+		return
+	} else if global.blocks(g.level) {
+		return "global", global.action(g.level)
+	} else if priority < g.priority {
+		return "priority", netcap.Disenroll
+	}
+	return
+}
+
+// Contracts shed by priority
+type shedding struct {
+	lock sync.Mutex
+	m    map[string]bool
+}
+
+// Sets if the contract is shed, logs the transitions
+func (m *Manager) setShed(cid string, priority int, shed bool) {
+	m.shedding.lock.Lock()
+	defer m.shedding.lock.Unlock()
+
+	if m.shedding.m == nil {
+		m.shedding.m = map[string]bool{}
+	}
+
+	if m.shedding.m[cid] == shed {
+		return
+	} else if shed {
+		log.Printf("Network Cap: Shedding contract %s with priority %d", cid, priority)
+		m.shedding.m[cid] = true
+	} else {
+		log.Printf("Network Cap: Contract %s no longer shed", cid)
+		delete(m.shedding.m, cid)
+	}
+}
+
+// Returns if the contract is shed
+func (m *Manager) shed(cid string) bool {
+	m.shedding.lock.Lock()
+	defer m.shedding.lock.Unlock()
+
+	return m.shedding.m[cid]
+}
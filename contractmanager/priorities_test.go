@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"testing"
+
+	"github.com/wireleap/relay/api/netcap"
+	"github.com/wireleap/relay/relaycfg"
+)
+
+func TestGlobalCaps(t *testing.T) {
+	n := newNCCfg()
+	n.contractReserved = func() map[string]uint64 {
+		return map[string]uint64{"ct1": 200}
+	}
+	n.priorityLevels = []relaycfg.PriorityLevel{
+		{Limit: netcap.Fraction(0.5), Priority: 1},
+		{Limit: netcap.Margin(200), Priority: 2},
+	}
+	global := newCap(1000, n.globalPolicy)
+
+	// no global limit
+	g := n.globalCaps(newCap(0, n.globalPolicy), map[string]uint64{}, 5000)
+	if g.level != okCap || g.priority != 0 || len(g.exempt) != 0 {
+		t.Fatalf("uncapped global usage should not shed %+v", g)
+	}
+
+	// 300 used + 150 unused reserved
+	g = n.globalCaps(global, map[string]uint64{"ct1": 50, "ct2": 250}, 300)
+	if g.level != okCap || g.priority != 0 || !g.exempt["ct1"] {
+		t.Fatalf("wrong global caps %+v", g)
+	}
+
+	// 400 used + 100 unused reserved
+	g = n.globalCaps(global, map[string]uint64{"ct1": 100, "ct2": 300}, 400)
+	if g.level != okCap || g.priority != 1 {
+		t.Fatalf("first priority level should be crossed %+v", g)
+	} else if _, a := g.action("ct2", 0, global); a != netcap.Disenroll {
+		t.Fatal("lower priority contract should be disenrolled")
+	} else if _, a = g.action("ct3", 1, global); a != "" {
+		t.Fatal("higher priority contract should stay enrolled")
+	}
+
+	// 900 used + 50 unused reserved
+	g = n.globalCaps(global, map[string]uint64{"ct1": 150, "ct2": 750}, 900)
+	if g.level != hardCap || g.priority != 2 {
+		t.Fatalf("hard cap should be reached %+v", g)
+	} else if name, a := g.action("ct2", 5, global); name != "global" || a != netcap.Disable {
+		t.Fatal("global cap should disable every contract")
+	} else if _, a = g.action("ct1", 0, global); a != "" {
+		t.Fatal("contract with unused reserved share should stay enrolled")
+	}
+
+	// reserved share used up
+	g = n.globalCaps(global, map[string]uint64{"ct1": 250, "ct2": 750}, 1000)
+	if _, a := g.action("ct1", 0, global); a != netcap.Disable {
+		t.Fatal("contract should be disabled once its reserved share is used")
+	}
+}
+
+func TestSetShed(t *testing.T) {
+	m := &Manager{}
+
+	m.setShed("ct", 0, true)
+	if !m.shed("ct") {
+		t.Fatal("contract should be shed")
+	}
+
+	m.setShed("ct", 0, false)
+	if m.shed("ct") {
+		t.Fatal("contract should no longer be shed")
+	}
+}
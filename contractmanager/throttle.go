@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"log"
+	"sync"
+
+	"github.com/c2h5oh/datasize"
+
+	"github.com/wireleap/relay/api/events"
+	"github.com/wireleap/relay/api/ratelimit"
+)
+
+// Bandwidth limiters of throttled contracts
+type throttles struct {
+	lock   sync.Mutex
+	global *ratelimit.Limiter
+	m      map[string]*ratelimit.Limiter
+}
+
+// Returns the limiter of a contract, or the global one if contractId is empty
+func (m *Manager) limiter(contractId string) *ratelimit.Limiter {
+	m.throttles.lock.Lock()
+	defer m.throttles.lock.Unlock()
+
+	if contractId == "" {
+		if m.throttles.global == nil {
+			m.throttles.global = ratelimit.New(0)
+		}
+		return m.throttles.global
+	}
+
+	if m.throttles.m == nil {
+		m.throttles.m = map[string]*ratelimit.Limiter{}
+	}
+
+	l, ok := m.throttles.m[contractId]
+	if !ok {
+		l = ratelimit.New(0)
+		m.throttles.m[contractId] = l
+	}
+	return l
+}
+
+// Returns the limiters applying to a contract connections, nil if network
+// usage caps are disabled
+func (m *Manager) Limiters(contractId string) []*ratelimit.Limiter {
+	if !m.capped() {
+		return nil
+	}
+	return []*ratelimit.Limiter{m.limiter(contractId), m.limiter("")}
+}
+
+// Set the throttling rate of a contract, or the global one if contractId is
+// empty, 0 disables throttling
+func (m *Manager) setThrottle(contractId, capName string, rate uint64) {
+	l := m.limiter(contractId)
+
+	prev := l.Rate()
+	if prev == rate {
+		return
+	}
+
+	l.SetRate(rate)
+
+	name := contractId
+	if name == "" {
+		name = "all contracts"
+	}
+
+	if rate == 0 {
+		log.Printf("Network Cap: Releasing throttling on %s", name)
+		m.Events.Emit(events.NetCapReleased, contractId, map[string]string{"action": "throttle"})
+	} else {
+		log.Printf("Network Cap: Throttling %s to %s/s", name, datasize.ByteSize(rate).HR())
+		if prev == 0 {
+			m.Events.Emit(events.NetCapReached, contractId, map[string]interface{}{"cap": capName, "action": "throttle", "rate": rate})
+		}
+	}
+}
+
+// Release the throttling of every contract
+func (m *Manager) releaseThrottles() {
+	m.throttles.lock.Lock()
+	ids := make([]string, 0, len(m.throttles.m))
+	for id := range m.throttles.m {
+		ids = append(ids, id)
+	}
+	m.throttles.lock.Unlock()
+
+	m.setThrottle("", "", 0)
+	for _, id := range ids {
+		m.setThrottle(id, "", 0)
+	}
+}
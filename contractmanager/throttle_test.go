@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+
+	"github.com/wireleap/relay/api/netcap"
+)
+
+func TestCap(t *testing.T) {
+	c := newCap(1000, netcap.DefaultPolicy())
+	if c.soft != 900 || c.hard != 930 {
+		t.Fatalf("wrong default cap %+v", c)
+	} else if c.blocks(okCap) || !c.blocks(softCap) || !c.blocks(hardCap) {
+		t.Fatal("default policy should block from the soft cap")
+	} else if c.action(hardCap) != netcap.Disable {
+		t.Fatal("default policy should disable at the hard cap")
+	}
+
+	// global cap, hard threshold only
+	c = newCap(1000, globalPolicy(nil))
+	if c.soft != c.hard {
+		t.Fatalf("soft cap should match hard cap %+v", c)
+	}
+
+	c = newCap(1000, netcap.Policy{
+		Soft: &netcap.Threshold{Limit: netcap.Fraction(0.5), Action: netcap.Throttle, Rate: datasize.KB},
+		Hard: &netcap.Threshold{Limit: netcap.Margin(100), Action: netcap.Disenroll},
+	})
+
+	if c.soft != 500 || c.hard != 900 {
+		t.Fatalf("wrong cap %+v", c)
+	} else if c.blocks(softCap) || !c.blocks(hardCap) {
+		t.Fatal("throttling should not block")
+	} else if c.rate(okCap) != 0 || c.rate(softCap) != 1024 || c.rate(hardCap) != 1024 {
+		t.Fatal("soft throttling should apply to the hard cap")
+	}
+}
+
+func TestSetThrottle(t *testing.T) {
+	m := &Manager{}
+
+	m.setThrottle("ct", "soft", 1024)
+	if r := m.limiter("ct").Rate(); r != 1024 {
+		t.Fatalf("wrong rate %d", r)
+	} else if r = m.limiter("").Rate(); r != 0 {
+		t.Fatal("global limiter should not be throttled")
+	}
+
+	m.setThrottle("ct", "", 0)
+	if r := m.limiter("ct").Rate(); r != 0 {
+		t.Fatal("throttling should be released")
+	}
+}
@@ -3,6 +3,8 @@
 package contractmanager
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -10,14 +12,23 @@ import (
 
 	"github.com/wireleap/common/cli/fsdir"
 
+	"github.com/wireleap/relay/api/atomicfile"
 	"github.com/wireleap/relay/api/map_counter"
 	"github.com/wireleap/relay/filenames"
 	"github.com/wireleap/relay/relaystats"
 	"github.com/wireleap/relay/relaystats/file"
+	"github.com/wireleap/relay/relaystats/journal"
 )
 
 type void struct{}
 
+// Returns if a and b have the same JSON encoding
+func sameJSON(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
 // Strip legacy statistics from storage file
 func filterInactive(sfile *file.NetStats, contractIds []string) map[string]uint64 {
 	contractIdSet := make(map[string]void, len(contractIds))
@@ -46,21 +57,45 @@ func loadStats(fm fsdir.T, contractIds []string) (netstats relaystats.NetStats,
 
 	if err = fm.Get(fns, filenames.Stats); err == nil {
 		legacyns = filterInactive(fns, contractIds)
-		netstats = relaystats.Load(fns, map_counter.NewList)
+		netstats = relaystats.Load(fns, map_counter.NewSharded)
+		replayJournal(fm, &netstats)
 	} else if errors.Is(err, os.ErrNotExist) {
 		// lazy file generation
-		if err = fm.SetIndented(fns, filenames.Stats); err != nil {
+		if err = atomicfile.SetIndented(fm, fns, filenames.Stats); err != nil {
 			err = fmt.Errorf("could not initialise statistics file: %s", err)
 			return
 		}
 
 		//legacyns = map[string]uint64{}
-		netstats = relaystats.NewNetStats(map_counter.NewList)
+		netstats = relaystats.NewNetStats(map_counter.NewSharded)
 		log.Printf("initialising statistics file")
 	}
 	return
 }
 
+// Add the usage journaled since the stats file was stored
+func replayJournal(fm fsdir.T, netstats *relaystats.NetStats) {
+	usage, n, seq, err := journal.Replay(fm.Path(filenames.StatsJournal), netstats.CreatedAt, netstats.JournalSeq)
+	if err != nil {
+		log.Printf("could not replay network usage journal: %s", err)
+	}
+	netstats.JournalSeq = seq
+
+	if n == 0 {
+		return
+	}
+
+	for ct, b := range usage {
+		x := netstats.ContractStats.GetOrInit(ct)
+		x.Add(b)
+
+		if err := x.Close(); err != nil {
+			panic(err)
+		}
+	}
+	log.Printf("replayed %d network usage journal entries", n)
+}
+
 // Insert not inactive and legacy contracts
 func mergeInactive(sfile *file.NetStats, contractIds []string, legacyns map[string]uint64) {
 	for _, ct := range contractIds {
@@ -93,7 +128,7 @@ func saveStats(netstats relaystats.NetStats, contractIds []string, legacyns map[
 func NewDummyManager() *Manager {
 	return &Manager{
 		NetStats: netStats{
-			Active: relaystats.NewNetStats(map_counter.NewList),
+			Active: relaystats.NewNetStats(map_counter.NewSharded),
 		},
 	}
 }
@@ -0,0 +1,160 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"time"
+
+	"github.com/wireleap/relay/relaycfg"
+)
+
+// Network usage windows accounting state
+type usageWindows struct {
+	// window being accounted, "" outside windows
+	active string
+	// counters at the last accounting
+	last map[string]uint64
+}
+
+// Returns the first network usage window containing t, nil if none
+func (n netCapsCfg) window(t time.Time) *relaycfg.UsageWindow {
+	for i := range n.windows {
+		if n.windows[i].Contains(t) {
+			return &n.windows[i]
+		}
+	}
+	return nil
+}
+
+// Returns if the usage of a contract within a window is limited apart from
+// its regular limit, or the global one if contractId is empty
+func (n netCapsCfg) separate(w *relaycfg.UsageWindow, contractId string, windowCaps map[string]map[string]uint64) bool {
+	if w.Unmetered {
+		return true
+	} else if contractId == "" {
+		return w.GlobalLimit != 0
+	}
+
+	_, ok := windowCaps[contractId][w.Name]
+	return ok
+}
+
+// Returns the bandwidth limit of the window containing t, 0 if none
+func (n netCapsCfg) windowRate(t time.Time) uint64 {
+	if w := n.window(t); w != nil {
+		return w.Rate.Bytes()
+	}
+	return 0
+}
+
+// Account the usage since the last call to the window active at that time
+// netFns.lock has to be held.
+func (m *Manager) accountWindows() {
+	if len(m.netCaps.windows) == 0 {
+		return
+	}
+
+	total, _ := m.netUsage()
+
+	if m.windows.last != nil && m.windows.active != "" {
+		for ct, n := range total {
+			prev := m.windows.last[ct]
+			if n < prev {
+				// counters were reset
+				prev = 0
+			}
+
+			if n > prev {
+				m.NetStats.Active.AddWindow(m.windows.active, ct, n-prev)
+			}
+		}
+	}
+
+	m.windows.last, m.windows.active = total, ""
+	if w := m.netCaps.window(time.Now()); w != nil {
+		m.windows.active = w.Name
+	}
+}
+
+// Returns the network usage counted toward the current caps, by contract
+// and global
+func (m *Manager) capUsage() (usage map[string]uint64, sum uint64) {
+	m.netFns.lock.Lock()
+	defer m.netFns.lock.Unlock()
+
+	m.accountWindows()
+	return m._capUsage()
+}
+
+// Returns the network usage counted toward the current caps, usage within
+// the active window is counted toward the window limits while usage within
+// separately limited windows is not counted toward the regular limits.
+// netFns.lock has to be held.
+func (m *Manager) _capUsage() (usage map[string]uint64, sum uint64) {
+	total, _ := m.netUsage()
+
+	n := m.netCaps
+	w := n.window(time.Now())
+	windowCaps := n.contractWindows()
+	windows := m.NetStats.Active.Windows
+
+	// Returns the usage of a contract counted toward the contract cap, or
+	// toward the global cap if id is empty
+	counted := func(ct, id string) uint64 {
+		if w != nil && n.separate(w, id, windowCaps) {
+			return windows[w.Name][ct]
+		}
+
+		u := total[ct]
+		for i := range n.windows {
+			if ow := &n.windows[i]; n.separate(ow, id, windowCaps) {
+				if wu := windows[ow.Name][ct]; wu < u {
+					u -= wu
+				} else {
+					u = 0
+				}
+			}
+		}
+		return u
+	}
+
+	usage = make(map[string]uint64, len(total))
+	for ct := range total {
+		usage[ct] = counted(ct, ct)
+		sum += counted(ct, "")
+	}
+	return
+}
+
+// Returns the global usage within each window
+func (m *Manager) windowUsage() (active string, usage map[string]uint64) {
+	m.netFns.lock.Lock()
+	defer m.netFns.lock.Unlock()
+
+	m.accountWindows()
+
+	usage = make(map[string]uint64, len(m.netCaps.windows))
+	for _, w := range m.netCaps.windows {
+		usage[w.Name] = 0
+		for _, u := range m.NetStats.Active.Windows[w.Name] {
+			usage[w.Name] += u
+		}
+	}
+	return m.windows.active, usage
+}
+
+// Returns the usage of a contract within each window
+func (m *Manager) contractWindowUsage(contractId string) (usage map[string]uint64) {
+	m.netFns.lock.Lock()
+	defer m.netFns.lock.Unlock()
+
+	for w, cts := range m.NetStats.Active.Windows {
+		if u, ok := cts[contractId]; ok {
+			if usage == nil {
+				usage = make(map[string]uint64)
+			}
+			usage[w] = u
+		}
+	}
+	return
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Wireleap
+
+package contractmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+
+	"github.com/wireleap/relay/api/timeframe"
+	"github.com/wireleap/relay/relaycfg"
+)
+
+// Returns a window starting at now+from and ending at now+to
+func windowAt(from, to time.Duration) timeframe.Window {
+	now := time.Now().UTC()
+	return timeframe.Window{
+		Start: now.Add(from).Format("15:04"),
+		End:   now.Add(to).Format("15:04"),
+	}
+}
+
+func TestWindows(t *testing.T) {
+	m := NewDummyManager()
+	m.NetStats.cfg.timeframe = timeframe.T{Duration: time.Hour}
+	m.netCaps = newNCCfg()
+	m.netCaps.globalCap = 1000
+	m.netCaps.contractCaps = func() map[string]uint64 {
+		return map[string]uint64{"ct1": 500, "ct2": 500}
+	}
+	m.netCaps.contractWindows = func() map[string]map[string]uint64 {
+		return map[string]map[string]uint64{"ct2": {"offpeak": 200}}
+	}
+	m.netCaps.windows = []relaycfg.UsageWindow{
+		{Name: "night", Window: windowAt(-time.Hour, time.Hour), Unmetered: true},
+		{Name: "offpeak", Window: windowAt(2*time.Hour, 3*time.Hour), GlobalLimit: 800},
+	}
+
+	add := func(ct string, n uint64) {
+		c := m.NetStats.Active.ContractStats.GetOrInit(ct)
+		c.Add(n)
+		c.Close()
+	}
+
+	// usage before the accounting starts
+	add("ct1", 100)
+	m.accountWindows()
+
+	// within the unmetered window
+	add("ct1", 300)
+	add("ct2", 50)
+
+	caps, global := m.netCaps.Caps()
+	if len(caps) != 0 || global.limit != 0 {
+		t.Fatal("unmetered window should not be capped")
+	}
+
+	if _, usage := m.windowUsage(); usage["night"] != 350 || usage["offpeak"] != 0 {
+		t.Fatalf("wrong window usage %v", usage)
+	}
+
+	// within the offpeak window
+	m.netCaps.windows[0].Window = windowAt(4*time.Hour, 5*time.Hour)
+	m.netCaps.windows[1].Window = windowAt(-time.Hour, time.Hour)
+	m.accountWindows()
+
+	add("ct1", 10)
+	add("ct2", 20)
+
+	caps, global = m.netCaps.Caps()
+	if global.limit != 800 || caps["ct1"].limit != 500 || caps["ct2"].limit != 200 {
+		t.Fatalf("window limits should replace regular limits %+v %+v", global, caps)
+	}
+
+	// ct1 offpeak usage counts toward its regular cap, not ct2 one
+	usage, sum := m.capUsage()
+	if usage["ct1"] != 110 || usage["ct2"] != 20 || sum != 30 {
+		t.Fatalf("wrong capped usage %v %d", usage, sum)
+	}
+
+	// outside windows
+	m.netCaps.windows[1].Window = windowAt(4*time.Hour, 5*time.Hour)
+	m.netCaps.windows[1].Rate = datasize.MB
+
+	if m.netCaps.windowRate(time.Now()) != 0 {
+		t.Fatal("window rate should not apply outside the window")
+	}
+
+	usage, sum = m.capUsage()
+	if usage["ct1"] != 110 || usage["ct2"] != 0 || sum != 100 {
+		t.Fatalf("wrong capped usage %v %d", usage, sum)
+	}
+}
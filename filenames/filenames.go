@@ -3,13 +3,17 @@
 package filenames
 
 const (
-	Config      = "config.json"
-	Pid         = "wireleap-relay.pid"
-	Seed        = "key.seed"
-	Pub         = "key.pub"
-	TLSCert     = "relay-cert.pem"
-	TLSKey      = "relay-key.pem"
-	Sharetokens = "sharetokens"
-	Log         = "wireleap-relay.log"
-	Stats       = "stats.json"
+	Config       = "config.json"
+	Pid          = "wireleap-relay.pid"
+	Seed         = "key.seed"
+	Pub          = "key.pub"
+	TLSCert      = "relay-cert.pem"
+	TLSKey       = "relay-key.pem"
+	Sharetokens  = "sharetokens"
+	Log          = "wireleap-relay.log"
+	Stats        = "stats.json"
+	StatsJournal = "stats.journal"
+	Maintenance  = "maintenance.json"
+	Overrides    = "overrides.json"
+	ApiTokens    = "api_tokens.json"
 )
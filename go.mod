@@ -6,5 +6,6 @@ require (
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/c2h5oh/datasize v0.0.0-20200825124411-48ed595a09d2
 	github.com/wireleap/common v0.3.7
+	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd
 	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9 // indirect
 )
@@ -25,7 +25,9 @@ import (
 	"github.com/wireleap/relay/sub/balancecmd"
 	"github.com/wireleap/relay/sub/checkconfigcmd"
 	"github.com/wireleap/relay/sub/initcmd"
+	"github.com/wireleap/relay/sub/maintenancecmd"
 	"github.com/wireleap/relay/sub/startcmd"
+	"github.com/wireleap/relay/sub/statscmd"
 	"github.com/wireleap/relay/sub/withdrawcmd"
 	"github.com/wireleap/relay/version"
 )
@@ -59,6 +61,8 @@ func main() {
 			checkconfigcmd.Cmd,
 			balancecmd.Cmd(),
 			withdrawcmd.Cmd(),
+			maintenancecmd.Cmd(),
+			statscmd.Cmd(),
 			versioncmd.Cmd(
 				&version.VERSION,
 				relaycontract.T,
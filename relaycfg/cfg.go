@@ -11,8 +11,12 @@ import (
 
 	"github.com/wireleap/common/api/duration"
 	"github.com/wireleap/common/api/texturl"
+	"github.com/wireleap/relay/api/netcap"
 	relayentry "github.com/wireleap/relay/api/relayentryext"
+	"github.com/wireleap/relay/api/resolver"
 	"github.com/wireleap/relay/api/socket"
+	"github.com/wireleap/relay/api/timeframe"
+	"github.com/wireleap/relay/api/tlspolicy"
 
 	"github.com/c2h5oh/datasize"
 )
@@ -26,12 +30,32 @@ type C struct {
 	AutoSubmitInterval duration.T `json:"auto_submit_interval,omitempty"`
 	// ArchiveDir is the path of the archived sharetoken store directory.
 	ArchiveDir *string `json:"archive_dir,omitempty"`
+	// ArchiveRetention limits the archived sharetoken store.
+	ArchiveRetention Retention `json:"archive_retention,omitempty"`
 	// MaxTime is the maximum time for a single connection.
 	MaxTime duration.T `json:"maxtime,omitempty"`
 	// Timeout is the dial timeout.
 	Timeout duration.T `json:"timeout,omitempty"`
 	// BufSize is the size in bytes of transmit/receive buffers.
 	BufSize int `json:"bufsize,omitempty"`
+	// FlushDelay is the maximum time tunneled data is held before being
+	// flushed downstream, 0 flushes after every write.
+	FlushDelay duration.T `json:"flush_delay,omitempty"`
+	// HTTP2 configures the wireleap:// HTTP/2 server.
+	HTTP2 HTTP2 `json:"http2,omitempty"`
+	// TLS configures the listener and the outgoing relay dials.
+	TLS TLS `json:"tls,omitempty"`
+	// NextHops configures the pooled connections to the next relays.
+	NextHops NextHops `json:"next_hops,omitempty"`
+	// Resolver configures the resolution of the target hostnames.
+	Resolver Resolver `json:"resolver,omitempty"`
+	// Blocklists are the domain lists of the refused target hostnames.
+	Blocklists Blocklists `json:"blocklists,omitempty"`
+	// Admission sets the thresholds above which new tunnels are shed.
+	Admission Admission `json:"admission,omitempty"`
+	// FairQueue shares the bandwidth between contracts and clients under
+	// congestion.
+	FairQueue FairQueue `json:"fair_queue,omitempty"`
 	// NetUsage is the allocated bandwith per time period.
 	// NetUsage is disabled if NetUsage.Timeframe is 0.
 	NetUsage NetUsage `json:"network_usage,omitempty"`
@@ -41,6 +65,10 @@ type C struct {
 	Contracts map[texturl.URL]*relayentry.T `json:"contracts,omitempty"`
 	// AutoUpgrade sets whether this relay should attempt auto-upgrades.
 	AutoUpgrade bool `json:"auto_upgrade,omitempty"`
+	// Maintenance configures scheduled maintenance windows.
+	Maintenance Maintenance `json:"maintenance,omitempty"`
+	// Health configures the readiness criteria.
+	Health Health `json:"health,omitempty"`
 	// Those are expert settings. Take care.
 	DangerZone DangerZone `json:"danger_zone,omitempty"`
 }
@@ -51,36 +79,363 @@ type RestApi struct {
 	Address *texturl.URL `json:"address"`
 	// Socket Umask
 	Umask socket.FileMode `json:"socket_umask"`
+	// Auth requires bearer tokens, see filenames.ApiTokens
+	Auth bool `json:"auth,omitempty"`
+	// TLS configures the https scheme
+	TLS *RestApiTLS `json:"tls,omitempty"`
+}
+
+// RestApi TLS settings
+// Relative paths are resolved from the relay home.
+type RestApiTLS struct {
+	// CertFile is the server certificate (default: relay certificate)
+	CertFile string `json:"cert_file,omitempty"`
+	// KeyFile is the server private key (default: relay private key)
+	KeyFile string `json:"key_file,omitempty"`
+	// ClientCA enables mTLS, client certificates must be signed by it
+	ClientCA string `json:"client_ca,omitempty"`
+	// ClientCertFile is the certificate presented by the relay CLI to an
+	// API requiring mTLS
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	// ClientKeyFile is the private key of ClientCertFile
+	ClientKeyFile string `json:"client_key_file,omitempty"`
+}
+
+// MaxWindow is the largest HTTP/2 flow-control window
+const MaxWindow = 1<<31 - 1
+
+// HTTP/2 server settings
+type HTTP2 struct {
+	// MaxConcurrentStreams limits the tunnels per client connection.
+	MaxConcurrentStreams uint32 `json:"max_concurrent_streams,omitempty"`
+	// ConnectionWindow is the flow-control window of a client connection.
+	ConnectionWindow datasize.ByteSize `json:"connection_window,omitempty"`
+	// StreamWindow is the flow-control window of a tunnel.
+	StreamWindow datasize.ByteSize `json:"stream_window,omitempty"`
+	// ReadHeaderTimeout is the time allowed to read the request headers.
+	ReadHeaderTimeout duration.T `json:"read_header_timeout,omitempty"`
+	// WriteTimeout is the time allowed to answer a request, 0 disables it
+	// as it also ends tunnels.
+	WriteTimeout duration.T `json:"write_timeout,omitempty"`
+	// IdleTimeout closes client connections without tunnels.
+	IdleTimeout duration.T `json:"idle_timeout,omitempty"`
+	// MaxHeaderBytes limits the size of the request headers.
+	MaxHeaderBytes datasize.ByteSize `json:"max_header_bytes,omitempty"`
+	// PingInterval is the interval of the keepalive pings sent to the
+	// clients and previous relays, 0 disables them.
+	PingInterval duration.T `json:"ping_interval,omitempty"`
+	// PingTimeout closes the connections, to the next relays too, not
+	// answering a ping in time.
+	PingTimeout duration.T `json:"ping_timeout,omitempty"`
+}
+
+// Validate the HTTP/2 settings on their own
+func (h HTTP2) Validate() error {
+	switch {
+	case h.ConnectionWindow > MaxWindow:
+		return fmt.Errorf("'connection_window' cannot exceed %d bytes", MaxWindow)
+	case h.StreamWindow > MaxWindow:
+		return fmt.Errorf("'stream_window' cannot exceed %d bytes", MaxWindow)
+	case h.ConnectionWindow != 0 && h.StreamWindow > h.ConnectionWindow:
+		return errors.New("'stream_window' cannot exceed 'connection_window'")
+	case h.ReadHeaderTimeout < 0, h.WriteTimeout < 0, h.IdleTimeout < 0:
+		return errors.New("timeouts cannot be negative")
+	case h.PingInterval < 0, h.PingTimeout < 0:
+		return errors.New("ping settings cannot be negative")
+	case h.PingInterval > 0 && h.PingTimeout == 0:
+		return errors.New("'ping_timeout' has to be set")
+	}
+	return nil
+}
+
+// Pooled connections to the next relays
+type NextHops struct {
+	// PoolSize is the maximum number of connections per next relay,
+	// 0 disables the pool.
+	PoolSize int `json:"pool_size,omitempty"`
+	// IdleTimeout closes connections without tunnels.
+	IdleTimeout duration.T `json:"idle_timeout,omitempty"`
+	// HealthCheckInterval is the interval of the connections health
+	// checks, 0 disables them.
+	HealthCheckInterval duration.T `json:"health_check_interval,omitempty"`
+}
+
+// Validate the next hops settings on their own
+func (n NextHops) Validate() error {
+	switch {
+	case n.PoolSize < 0:
+		return errors.New("'pool_size' cannot be negative")
+	case n.IdleTimeout < 0, n.HealthCheckInterval < 0:
+		return errors.New("intervals cannot be negative")
+	case n.PoolSize > 0 && n.IdleTimeout == 0:
+		return errors.New("'idle_timeout' has to be set")
+	}
+	return nil
+}
+
+// Resolution of the target hostnames
+type Resolver struct {
+	// Servers are the udp://, tcp:// or https:// DNS servers, tried in
+	// turn. The system resolver is used if there are none.
+	Servers []string `json:"servers,omitempty"`
+	// Timeout is the time allowed to a server to answer.
+	Timeout duration.T `json:"timeout,omitempty"`
+	// Prefer is the address family dialed first, "ipv4" or "ipv6".
+	Prefer string `json:"prefer,omitempty"`
+	// FallbackDelay is the time the preferred family is dialed alone.
+	FallbackDelay duration.T `json:"fallback_delay,omitempty"`
+	// CacheSize is the maximum number of cached answers, 0 disables the
+	// cache.
+	CacheSize int `json:"cache_size,omitempty"`
+	// MinTTL and MaxTTL bound the time answers are cached.
+	MinTTL duration.T `json:"min_ttl,omitempty"`
+	MaxTTL duration.T `json:"max_ttl,omitempty"`
+	// NegativeTTL is the time negative answers are cached when their
+	// server does not tell.
+	NegativeTTL duration.T `json:"negative_ttl,omitempty"`
+}
+
+// Validate the resolver settings on their own
+func (r Resolver) Validate() error {
+	for _, s := range r.Servers {
+		if _, err := resolver.ParseServer(s); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case r.Prefer != "" && r.Prefer != resolver.PreferIPv4 && r.Prefer != resolver.PreferIPv6:
+		return fmt.Errorf("'prefer' has to be '%s' or '%s'", resolver.PreferIPv4, resolver.PreferIPv6)
+	case r.CacheSize < 0:
+		return errors.New("'cache_size' cannot be negative")
+	case r.Timeout < 0, r.FallbackDelay < 0, r.MinTTL < 0, r.MaxTTL < 0, r.NegativeTTL < 0:
+		return errors.New("durations cannot be negative")
+	case r.MaxTTL > 0 && r.MinTTL > r.MaxTTL:
+		return errors.New("'min_ttl' cannot exceed 'max_ttl'")
+	}
+	return nil
+}
+
+// Domain blocklists of the target hostnames
+// Relative paths are resolved from the relay home.
+type Blocklists struct {
+	// Files are the paths of the domain list files, by list name.
+	Files map[string]string `json:"files,omitempty"`
+	// CheckInterval is the interval of the files modification checks,
+	// 0 disables them.
+	CheckInterval duration.T `json:"check_interval,omitempty"`
+}
+
+// Validate the blocklists settings on their own
+func (b Blocklists) Validate() error {
+	for name, path := range b.Files {
+		if name == "" || path == "" {
+			return errors.New("list names and paths have to be set")
+		}
+	}
+	if b.CheckInterval < 0 {
+		return errors.New("'check_interval' cannot be negative")
+	}
+	return nil
+}
+
+// TLS policies, kept separate for the listener and the outgoing dials
+type TLS struct {
+	// Server is the policy of the wireleap:// listener.
+	Server tlspolicy.Server `json:"server,omitempty"`
+	// Client is the policy of the dials to the next relay.
+	Client tlspolicy.T `json:"client,omitempty"`
 }
 
 // Network usage soft-cap
 // Soft-cap per contract defined in relayentry.T
 type NetUsage struct {
-	// Duration defines the time period.
-	Timeframe duration.T `json:"timeframe"`
+	// Timeframe defines the time period, a duration or a calendar schedule.
+	Timeframe timeframe.T `json:"timeframe"`
 	// GlobaLimit is disabled if it is 0.
 	GlobalLimit datasize.ByteSize `json:"global_limit"`
 	// WriteInterval defines how often the metrics are stored on disk
 	WriteInterval *duration.T `json:"write_interval"`
 	// ArchiveDir is the path of the archived statistics directory.
 	ArchiveDir *string `json:"archive_dir,omitempty"`
+	// ArchiveRetention limits the archived statistics directory.
+	ArchiveRetention Retention `json:"archive_retention,omitempty"`
+	// Policy defines the global limit thresholds, and the contract limit
+	// thresholds unless overridden by contracts.X.network_usage_policy.
+	Policy *netcap.Policy `json:"policy,omitempty"`
+	// Windows are time windows accounted separately, with their own limits.
+	Windows []UsageWindow `json:"windows,omitempty"`
+	// Pacing lowers the contracts bandwidth so their limit lasts until the
+	// end of the period, unless overridden by contracts.X.network_usage_pacing.
+	Pacing bool `json:"pacing,omitempty"`
+	// PriorityLevels shed lower priority contracts as the global usage grows.
+	PriorityLevels []PriorityLevel `json:"priority_levels,omitempty"`
+}
+
+// Global network usage level shedding lower priority contracts
+type PriorityLevel struct {
+	// Limit is the usage threshold, relative to the global limit.
+	Limit netcap.Limit `json:"limit"`
+	// Priority is the minimum priority of the contracts kept enrolled.
+	Priority int `json:"priority"`
+}
+
+// Network usage time window, e.g. off-peak hours
+// Usage within the window counts toward the regular limits unless the
+// window is unmetered or defines its own limit.
+type UsageWindow struct {
+	// Name identifies the window usage in the statistics.
+	Name string `json:"name"`
+	timeframe.Window
+	// Unmetered windows usage is not limited.
+	Unmetered bool `json:"unmetered,omitempty"`
+	// GlobalLimit replaces network_usage.global_limit within the window.
+	GlobalLimit datasize.ByteSize `json:"global_limit,omitempty"`
+	// Rate limits the relay bandwidth per second within the window.
+	Rate datasize.ByteSize `json:"rate,omitempty"`
+}
+
+// Archive retention and compaction, zero values are disabled
+type Retention struct {
+	// MaxAge removes the files older than it.
+	MaxAge duration.T `json:"max_age,omitempty"`
+	// MaxCount keeps the newest files only, a bundle counts as one file.
+	MaxCount int `json:"max_count,omitempty"`
+	// MaxSize removes the oldest files above this total size.
+	MaxSize datasize.ByteSize `json:"max_size,omitempty"`
+	// CompactAfter rolls the files older than it into monthly bundles.
+	CompactAfter duration.T `json:"compact_after,omitempty"`
+}
+
+// Validate the retention on its own
+func (r Retention) Validate() error {
+	if r.MaxAge < 0 || r.CompactAfter < 0 {
+		return errors.New("'max_age' and 'compact_after' cannot be negative")
+	} else if r.MaxCount < 0 {
+		return errors.New("'max_count' cannot be negative")
+	} else if r.MaxAge != 0 && r.CompactAfter >= r.MaxAge {
+		return errors.New("'compact_after' has to be lower than 'max_age'")
+	}
+	return nil
+}
+
+// Maintenance windows
+type Maintenance struct {
+	// Windows is the list of scheduled maintenance windows.
+	Windows []MaintenanceWindow `json:"windows,omitempty"`
+}
+
+// Scheduled maintenance window
+// All the contracts are affected if Contracts is empty.
+type MaintenanceWindow struct {
+	// Start is the beginning of the window.
+	Start time.Time `json:"start"`
+	// End is the end of the window.
+	End time.Time `json:"end"`
+	// Contracts is the list of affected service contracts.
+	Contracts []texturl.URL `json:"contracts,omitempty"`
+	// Reason is an optional description of the window.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Readiness criteria
+type Health struct {
+	// MinEnrolled is the minimum number of enrolled contracts.
+	MinEnrolled int `json:"min_enrolled"`
+	// MaxHeartbeatAge is the maximum age of the last heartbeat of
+	// enrolled contracts, disabled if it is 0.
+	MaxHeartbeatAge duration.T `json:"max_heartbeat_age"`
+	// AllowCapReached keeps the relay ready once the global cap is reached.
+	AllowCapReached bool `json:"allow_cap_reached,omitempty"`
+	// SkipDiskCheck disables the relay home writability check.
+	SkipDiskCheck bool `json:"skip_disk_check,omitempty"`
 }
 
 type DangerZone struct {
 	AllowLoopback bool `json:"allow_loopback,omitempty"`
 }
 
+// Load shedding thresholds, 0 disables them
+type Admission struct {
+	// MaxOpenFiles is the share of the open files limit, from 0 to 1.
+	MaxOpenFiles float64 `json:"max_open_files,omitempty"`
+	// MaxGoroutines is the number of goroutines.
+	MaxGoroutines int `json:"max_goroutines,omitempty"`
+	// MaxHeap is the size of the allocated heap.
+	MaxHeap datasize.ByteSize `json:"max_heap,omitempty"`
+	// MaxAcceptLatency is the average time from accepting a connection to
+	// serving it.
+	MaxAcceptLatency duration.T `json:"max_accept_latency,omitempty"`
+	// Interval is the interval of the resource samples.
+	Interval duration.T `json:"interval,omitempty"`
+}
+
+// Validate the admission settings on their own
+func (a Admission) Validate() error {
+	switch {
+	case a.MaxOpenFiles < 0 || a.MaxOpenFiles > 1:
+		return errors.New("'max_open_files' has to be between 0 and 1")
+	case a.MaxGoroutines < 0, a.MaxAcceptLatency < 0, a.Interval < 0:
+		return errors.New("thresholds cannot be negative")
+	case a.Interval == 0 && (a.MaxOpenFiles > 0 || a.MaxHeap > 0 || a.MaxAcceptLatency > 0):
+		return errors.New("'interval' has to be set")
+	}
+	return nil
+}
+
+// Fair queueing of the tunneled data while the uplink is saturated
+type FairQueue struct {
+	// Interval is the interval of the throughput samples. Fair queueing is
+	// disabled if it is 0.
+	Interval duration.T `json:"interval,omitempty"`
+	// Stall is the write duration past which the uplink is taken as
+	// saturated, when exceeded by several clients.
+	Stall duration.T `json:"stall,omitempty"`
+}
+
 // Defaults provides a config with sane defaults whenever possible.
 func Defaults() C {
 	return C{
 		AutoSubmitInterval: duration.T(time.Minute * 5),
 		Timeout:            duration.T(time.Second * 5),
 		BufSize:            4096,
+		FlushDelay:         duration.T(time.Millisecond * 5),
+		HTTP2: HTTP2{
+			MaxConcurrentStreams: 250,
+			ConnectionWindow:     datasize.MB,
+			StreamWindow:         datasize.MB,
+			ReadHeaderTimeout:    duration.T(time.Second * 10),
+			IdleTimeout:          duration.T(time.Minute * 5),
+			MaxHeaderBytes:       64 * datasize.KB,
+			PingInterval:         duration.T(time.Second * 30),
+			PingTimeout:          duration.T(time.Second * 15),
+		},
+		Resolver: Resolver{
+			Timeout:       duration.T(time.Second * 2),
+			FallbackDelay: duration.T(time.Millisecond * 300),
+			CacheSize:     4096,
+			MaxTTL:        duration.T(time.Hour),
+			NegativeTTL:   duration.T(time.Second * 30),
+		},
+		Blocklists: Blocklists{
+			CheckInterval: duration.T(time.Minute),
+		},
+		Admission: Admission{
+			Interval: duration.T(time.Second),
+		},
+		NextHops: NextHops{
+			IdleTimeout:         duration.T(time.Minute * 5),
+			HealthCheckInterval: duration.T(time.Second * 30),
+		},
 		RestApi: RestApi{
 			Umask: 0600,
 		},
 		Contracts:   map[texturl.URL]*relayentry.T{},
 		AutoUpgrade: true,
+		Health: Health{
+			MinEnrolled:     1,
+			MaxHeartbeatAge: duration.T(time.Minute * 15),
+		},
 	}
 }
 
@@ -116,10 +471,121 @@ func (c *C) Validate() error {
 		}
 	}
 
+	if err := c.NetUsage.Timeframe.Validate(); err != nil {
+		return fmt.Errorf("network_usage.timeframe failed to validate: %w", err)
+	}
+
+	if err := c.HTTP2.Validate(); err != nil {
+		return fmt.Errorf("http2 failed to validate: %w", err)
+	}
+
+	if err := c.NextHops.Validate(); err != nil {
+		return fmt.Errorf("next_hops failed to validate: %w", err)
+	}
+
+	if err := c.Resolver.Validate(); err != nil {
+		return fmt.Errorf("resolver failed to validate: %w", err)
+	}
+
+	if err := c.Blocklists.Validate(); err != nil {
+		return fmt.Errorf("blocklists failed to validate: %w", err)
+	}
+
+	if err := c.Admission.Validate(); err != nil {
+		return fmt.Errorf("admission failed to validate: %w", err)
+	}
+
+	if c.FairQueue.Interval < 0 || c.FairQueue.Stall < 0 {
+		return errors.New("fair_queue durations cannot be negative")
+	}
+
+	if err := c.TLS.Server.Validate(); err != nil {
+		return fmt.Errorf("tls.server failed to validate: %w", err)
+	} else if err = c.TLS.Client.Validate(); err != nil {
+		return fmt.Errorf("tls.client failed to validate: %w", err)
+	}
+
+	if err := c.ArchiveRetention.Validate(); err != nil {
+		return fmt.Errorf("archive_retention failed to validate: %w", err)
+	} else if err = c.NetUsage.ArchiveRetention.Validate(); err != nil {
+		return fmt.Errorf("network_usage.archive_retention failed to validate: %w", err)
+	}
+
+	windows := make(map[string]UsageWindow, len(c.NetUsage.Windows))
+	for i, w := range c.NetUsage.Windows {
+		if w.Name == "" {
+			return fmt.Errorf("network usage window %d failed to validate: 'name' has to be set", i)
+		} else if _, ok := windows[w.Name]; ok {
+			return fmt.Errorf("network usage window %d failed to validate: duplicate name %q", i, w.Name)
+		} else if err := w.Window.Validate(); err != nil {
+			return fmt.Errorf("network usage window %s failed to validate: %w", w.Name, err)
+		} else if w.Unmetered && w.GlobalLimit != 0 {
+			return fmt.Errorf("network usage window %s failed to validate: unmetered window cannot have a 'global_limit'", w.Name)
+		}
+		windows[w.Name] = w
+	}
+
+	for i, l := range c.NetUsage.PriorityLevels {
+		if c.NetUsage.GlobalLimit == 0 {
+			return errors.New("network_usage.priority_levels requires 'global_limit' to be set")
+		} else if err := l.Limit.Validate(); err != nil {
+			return fmt.Errorf("network usage priority level %d failed to validate: %w", i, err)
+		}
+	}
+
+	policy := c.NetUsage.Policy.Merge(netcap.DefaultPolicy())
+	if err := policy.Validate(uint64(c.NetUsage.GlobalLimit)); err != nil {
+		return fmt.Errorf("network_usage.policy failed to validate: %w", err)
+	}
+
+	var reserved datasize.ByteSize
 	for k, v := range c.Contracts {
 		if err := v.Validate(); err != nil {
 			return fmt.Errorf("enrollment config for %s failed to validate: %w", k.String(), err)
 		}
+
+		for name := range v.NetUsageWindows {
+			if w, ok := windows[name]; !ok {
+				return fmt.Errorf("enrollment config for %s failed to validate: unknown network usage window %q", k.String(), name)
+			} else if w.Unmetered {
+				return fmt.Errorf("enrollment config for %s failed to validate: network usage window %q is unmetered", k.String(), name)
+			}
+		}
+
+		// thresholds inherited from the global policy have to be consistent too
+		p := v.NetUsagePolicy.Merge(policy)
+		if err := p.Validate(uint64(v.NetUsage)); err != nil {
+			return fmt.Errorf("enrollment config for %s failed to validate: network_usage_policy: %w", k.String(), err)
+		}
+
+		if v.NetUsageReserved != 0 && c.NetUsage.GlobalLimit == 0 {
+			return fmt.Errorf("enrollment config for %s failed to validate: network_usage_reserved requires network_usage.global_limit", k.String())
+		}
+		reserved += v.NetUsageReserved
+	}
+
+	if reserved > c.NetUsage.GlobalLimit && c.NetUsage.GlobalLimit != 0 {
+		return fmt.Errorf("reserved network usage %s exceeds network_usage.global_limit", reserved.HR())
+	}
+
+	for i, w := range c.Maintenance.Windows {
+		if w.Start.IsZero() || w.End.IsZero() {
+			return fmt.Errorf("maintenance window %d failed to validate: 'start' and 'end' have to be set", i)
+		} else if !w.End.After(w.Start) {
+			return fmt.Errorf("maintenance window %d failed to validate: 'end' has to be after 'start'", i)
+		}
+
+		for _, sc := range w.Contracts {
+			if _, ok := c.Contracts[sc]; !ok {
+				return fmt.Errorf("maintenance window %d failed to validate: unknown contract %s", i, sc.String())
+			}
+		}
+	}
+
+	if c.Health.MinEnrolled < 0 {
+		return errors.New("health.min_enrolled cannot be negative")
+	} else if c.Health.MaxHeartbeatAge < 0 {
+		return errors.New("health.max_heartbeat_age cannot be negative")
 	}
 
 	if c.RestApi.Address != nil {
@@ -128,11 +594,21 @@ func (c *C) Validate() error {
 			if c.RestApi.Address.Host != "" {
 				return errors.New(`restapi address failed to validate: file path must start by "file:///"`)
 			}
-		case "http":
+		case "http", "https":
 			// pass
 		default:
 			return fmt.Errorf("restapi address %s has unknown scheme: %s", c.RestApi.Address.String(), c.RestApi.Address.Scheme)
 		}
+
+		if c.RestApi.TLS != nil {
+			if c.RestApi.Address.Scheme != "https" {
+				return errors.New(`restapi tls failed to validate: requires the "https" address scheme`)
+			} else if (c.RestApi.TLS.CertFile == "") != (c.RestApi.TLS.KeyFile == "") {
+				return errors.New("restapi tls failed to validate: 'cert_file' and 'key_file' have to be set together")
+			} else if (c.RestApi.TLS.ClientCertFile == "") != (c.RestApi.TLS.ClientKeyFile == "") {
+				return errors.New("restapi tls failed to validate: 'client_cert_file' and 'client_key_file' have to be set together")
+			}
+		}
 	}
 
 	return nil
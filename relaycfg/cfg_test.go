@@ -7,6 +7,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
+
+	"github.com/wireleap/common/api/duration"
+	"github.com/wireleap/common/api/texturl"
+
+	"github.com/c2h5oh/datasize"
 )
 
 func TestCfg(t *testing.T) {
@@ -105,3 +111,411 @@ func TestCfgRestapi2(t *testing.T) {
 		t.Fatal("wrong permissions")
 	}
 }
+
+func TestCfgRestapiTLS(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/restapi/config-tls-fronting.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	err = json.Unmarshal(b, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.RestApi.Address.Scheme != "https" {
+		t.Fatal("wrong scheme")
+	} else if !c.RestApi.Auth {
+		t.Fatal("auth should be enabled")
+	} else if c.RestApi.TLS == nil || c.RestApi.TLS.ClientCA != "api-client-ca.pem" {
+		t.Fatal("wrong client ca")
+	}
+
+	// Should fail with a partial key pair
+	c.RestApi.TLS.CertFile = "api-cert.pem"
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("cert without key should fail to validate")
+	}
+
+	// Should fail with a partial client key pair
+	c.RestApi.TLS.CertFile = ""
+	c.RestApi.TLS.ClientCertFile = "api-client-cert.pem"
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("client cert without key should fail to validate")
+	}
+
+	// Should fail without the https scheme
+	c.RestApi.TLS.ClientCertFile = ""
+	c.RestApi.Address.Scheme = "http"
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("tls without https should fail to validate")
+	}
+}
+
+func TestCfgMaintenance(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/config-maintenance.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	err = json.Unmarshal(b, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Maintenance.Windows) != 1 {
+		t.Fatal("wrong number of maintenance windows")
+	}
+
+	w := &c.Maintenance.Windows[0]
+
+	// Should fail with an unknown contract
+	w.Contracts = append(w.Contracts, *texturl.URLMustParse("http://unknown-contract:8080"))
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("unknown contract should fail to validate")
+	}
+
+	// Should fail with a reversed window
+	w.Contracts = nil
+	w.Start, w.End = w.End, w.Start
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("reversed window should fail to validate")
+	}
+}
+
+func TestCfgNetworkCalendar(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/network/config-backing-calendar.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	err = json.Unmarshal(b, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if tf := c.NetUsage.Timeframe; tf.Calendar == nil || tf.Calendar.Day != 1 {
+		t.Fatal("calendar timeframe not loaded")
+	}
+
+	// Should fail with an invalid schedule
+	b = []byte(`{"network_usage": {"timeframe": {"every": "month", "day": 0}}}`)
+
+	if err = json.Unmarshal(b, &c); err == nil {
+		t.Fatal("invalid calendar timeframe should fail to load")
+	}
+}
+
+func TestCfgNetworkWindows(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/network/config-backing-windows.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	err = json.Unmarshal(b, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.NetUsage.Windows) != 2 || c.NetUsage.Windows[1].Start != "22:00" {
+		t.Fatal("network usage windows not loaded")
+	}
+
+	// Should fail with an unknown window limit
+	for _, v := range c.Contracts {
+		v.NetUsageWindows["weekend"] = v.NetUsage
+	}
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("unknown window should fail to validate")
+	}
+
+	// Should fail with a limit on an unmetered window
+	for _, v := range c.Contracts {
+		delete(v.NetUsageWindows, "weekend")
+		v.NetUsageWindows["night"] = v.NetUsage
+	}
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("unmetered window limit should fail to validate")
+	}
+
+	// Should fail with duplicate names
+	for _, v := range c.Contracts {
+		delete(v.NetUsageWindows, "night")
+	}
+	c.NetUsage.Windows[1].Name = "night"
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("duplicate window names should fail to validate")
+	}
+}
+
+func TestCfgNetworkPriorities(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/network/config-backing-priorities.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	err = json.Unmarshal(b, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.NetUsage.PriorityLevels) != 2 || c.NetUsage.PriorityLevels[1].Limit.Margin != 100*datasize.GB {
+		t.Fatal("network usage priority levels not loaded")
+	}
+
+	// Should fail with reserved shares exceeding the global limit
+	for _, v := range c.Contracts {
+		if v.Priority != 2 {
+			t.Fatal("contract priority not loaded")
+		}
+		v.NetUsageReserved = 2 * datasize.TB
+	}
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("reserved usage exceeding the global limit should fail to validate")
+	}
+
+	// Should fail without global limit
+	c.NetUsage.GlobalLimit = 0
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("priority levels without global limit should fail to validate")
+	}
+}
+
+func TestCfgFairQueue(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/network/config-backing-fairqueue.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	err = json.Unmarshal(b, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.FairQueue.Interval != duration.T(100*time.Millisecond) || c.FairQueue.Stall != duration.T(5*time.Millisecond) {
+		t.Fatal("fair queueing settings not loaded")
+	}
+
+	for _, v := range c.Contracts {
+		if v.FairQueueWeight != 3 {
+			t.Fatal("contract weight not loaded")
+		}
+		v.FairQueueWeight = -1
+	}
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("negative weight should fail to validate")
+	}
+
+	for _, v := range c.Contracts {
+		v.FairQueueWeight = 0
+	}
+	c.FairQueue.Stall = -1
+
+	if err = c.Validate(); err == nil {
+		t.Fatal("negative stall should fail to validate")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	var r Retention
+	if err := json.Unmarshal([]byte(`{"max_age":"90d","max_count":100,"max_size":"1GB","compact_after":"30d"}`), &r); err != nil {
+		t.Fatal(err)
+	} else if err = r.Validate(); err != nil {
+		t.Fatal(err)
+	} else if r.MaxSize != datasize.GB || r.MaxCount != 100 {
+		t.Fatalf("retention not loaded %+v", r)
+	}
+
+	r.CompactAfter = r.MaxAge
+	if err := r.Validate(); err == nil {
+		t.Fatal("compacting after the max age should fail to validate")
+	}
+
+	r = Retention{MaxCount: -1}
+	if err := r.Validate(); err == nil {
+		t.Fatal("negative count should fail to validate")
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	h := Defaults().HTTP2
+	if err := h.Validate(); err != nil {
+		t.Fatal(err)
+	} else if h.MaxConcurrentStreams == 0 || h.ReadHeaderTimeout == 0 {
+		t.Fatalf("defaults should be set %+v", h)
+	}
+
+	if err := json.Unmarshal([]byte(`{"connection_window":"64MB","stream_window":"16MB","max_concurrent_streams":1000}`), &h); err != nil {
+		t.Fatal(err)
+	} else if err = h.Validate(); err != nil {
+		t.Fatal(err)
+	} else if h.StreamWindow != 16*datasize.MB || h.IdleTimeout == 0 {
+		t.Fatalf("settings not merged with the defaults %+v", h)
+	}
+
+	h.StreamWindow = 128 * datasize.MB
+	if err := h.Validate(); err == nil {
+		t.Fatal("stream window above the connection window should fail to validate")
+	}
+
+	h.ConnectionWindow, h.StreamWindow = 4*datasize.GB, 0
+	if err := h.Validate(); err == nil {
+		t.Fatal("window above the HTTP/2 maximum should fail to validate")
+	}
+
+	h = Defaults().HTTP2
+	if h.PingInterval == 0 || h.PingTimeout == 0 {
+		t.Fatalf("keepalive pings should be enabled by default %+v", h)
+	}
+
+	h.PingTimeout = 0
+	if err := h.Validate(); err == nil {
+		t.Fatal("pings without timeout should fail to validate")
+	}
+
+	h.PingInterval = 0
+	if err := h.Validate(); err != nil {
+		t.Fatal("disabled pings should validate")
+	}
+}
+
+func TestNextHops(t *testing.T) {
+	n := Defaults().NextHops
+	if err := n.Validate(); err != nil {
+		t.Fatal(err)
+	} else if n.PoolSize != 0 {
+		t.Fatal("pool should be disabled by default")
+	}
+
+	if err := (NextHops{PoolSize: 2}).Validate(); err == nil {
+		t.Fatal("pool without idle timeout should fail to validate")
+	} else if err = (NextHops{}).Validate(); err != nil {
+		t.Fatal("disabled pool should validate")
+	}
+}
+
+func TestResolver(t *testing.T) {
+	r := Defaults().Resolver
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"servers":["udp://192.0.2.53","https://dns.example.org/dns-query"],"prefer":"ipv4"}`), &r); err != nil {
+		t.Fatal(err)
+	} else if err = r.Validate(); err != nil {
+		t.Fatal(err)
+	} else if len(r.Servers) != 2 || r.CacheSize == 0 {
+		t.Fatalf("settings not merged with the defaults %+v", r)
+	}
+
+	r.Prefer = "ipv5"
+	if err := r.Validate(); err == nil {
+		t.Fatal("unknown address family should fail to validate")
+	}
+
+	r.Prefer, r.Servers = "", []string{"192.0.2.53"}
+	if err := r.Validate(); err == nil {
+		t.Fatal("server without scheme should fail to validate")
+	}
+
+	if err := (Resolver{MinTTL: 10, MaxTTL: 5}).Validate(); err == nil {
+		t.Fatal("min_ttl above max_ttl should fail to validate")
+	}
+}
+
+func TestBlocklists(t *testing.T) {
+	b := Defaults().Blocklists
+	if err := json.Unmarshal([]byte(`{"files":{"malware":"/etc/wireleap/malware.txt"}}`), &b); err != nil {
+		t.Fatal(err)
+	} else if err = b.Validate(); err != nil {
+		t.Fatal(err)
+	} else if len(b.Files) != 1 || b.CheckInterval == 0 {
+		t.Fatalf("settings not merged with the defaults %+v", b)
+	}
+
+	b.Files[""] = "/etc/wireleap/c2.txt"
+	if err := b.Validate(); err == nil {
+		t.Fatal("unnamed list should fail to validate")
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	a := Defaults().Admission
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	} else if a.MaxOpenFiles != 0 {
+		t.Fatal("open files threshold should be off by default")
+	}
+
+	if err := json.Unmarshal([]byte(`{"max_heap":"2GB","max_accept_latency":"500ms"}`), &a); err != nil {
+		t.Fatal(err)
+	} else if err = a.Validate(); err != nil {
+		t.Fatal(err)
+	} else if a.MaxHeap != 2*datasize.GB || a.Interval == 0 {
+		t.Fatalf("settings not merged with the defaults %+v", a)
+	}
+
+	a.MaxOpenFiles = 1.5
+	if err := a.Validate(); err == nil {
+		t.Fatal("open files share above 1 should fail to validate")
+	}
+
+	if err := (Admission{MaxHeap: datasize.GB}).Validate(); err == nil {
+		t.Fatal("sampled thresholds without interval should fail to validate")
+	} else if err = (Admission{MaxGoroutines: 100000}).Validate(); err != nil {
+		t.Fatal("goroutines are counted on every tunnel")
+	}
+}
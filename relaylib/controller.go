@@ -13,6 +13,8 @@ import (
 	"github.com/wireleap/common/api/status"
 	"github.com/wireleap/common/api/texturl"
 
+	"github.com/wireleap/relay/api/events"
+	"github.com/wireleap/relay/api/netcap"
 	relayentry "github.com/wireleap/relay/api/relayentryext"
 	"github.com/wireleap/relay/relaycfg"
 )
@@ -28,6 +30,7 @@ var (
 	ErrContractNotFound     = errors.New("relay contract not found")
 	ErrContractNotAvailable = errors.New("relay contract not available")
 	ErrDisenroll            = errors.New("disenrollment patially failed, couldn't disenroll the following contracts")
+	ErrContractMaintenance  = errors.New("relay contract in maintenance mode")
 )
 
 // Controller is the serverlib relay handler
@@ -37,6 +40,8 @@ type Controller struct {
 	hbt      *time.Ticker
 	relays   map[string]*relayStatus
 	callback chan *status.T
+	// Events receives enrollment events, can be nil
+	Events *events.Bus
 }
 
 // Create new controller instance
@@ -56,6 +61,7 @@ func (c *Controller) add(scurl texturl.URL, cfg *relayentry.T) (contractId strin
 
 	var rs relayStatus
 	if rs, err = NewRelayStatus(c.client, scurl, cfg); err == nil {
+		rs.id = contractId
 		c.relays[contractId] = &rs
 	} else {
 		contractId = ""
@@ -105,29 +111,45 @@ func (c *Controller) enroll(rs *relayStatus) (err error) {
 
 	if err == nil {
 		log.Printf("Enrolled successfully as %s relay into %s", rs.Relay.Role, rs.scUrl)
+		c.Events.Emit(events.Enrolled, rs.id, map[string]string{"role": rs.Relay.Role})
+	} else {
+		c.Events.Emit(events.EnrollFailed, rs.id, map[string]string{"error": err.Error()})
 	}
 
 	return
 }
 
 // Heartbeat specific relay
-func (c *Controller) beat(rs *relayStatus) (*status.T, error) {
-	return rs.Beat(c.client)
+func (c *Controller) beat(rs *relayStatus) (st *status.T, err error) {
+	if st, err = rs.Beat(c.client); err != nil {
+		c.Events.Emit(events.HeartbeatFailed, rs.id, map[string]string{"error": err.Error()})
+	}
+	return
 }
 
 // Disenroll specific relay
-func (c *Controller) disenroll(rs *relayStatus) error {
-	return rs.Disenroll(c.client)
+func (c *Controller) disenroll(rs *relayStatus) (err error) {
+	if err = rs.Disenroll(c.client); err == nil {
+		c.Events.Emit(events.Disenrolled, rs.id, nil)
+	} else {
+		c.Events.Emit(events.DisenrollFailed, rs.id, map[string]string{"error": err.Error()})
+	}
+	return
 }
 
 // Disenroll specific relay, do not return an error
-func (c *Controller) forcedisenroll(rs *relayStatus) bool {
-	return rs.ForceDisenroll(c.client)
+func (c *Controller) forcedisenroll(rs *relayStatus) (ok bool) {
+	enrolled := rs.Status().Flags.Enrolled
+	if ok = rs.ForceDisenroll(c.client); enrolled {
+		c.Events.Emit(events.Disenrolled, rs.id, nil)
+	}
+	return
 }
 
 // Disable specific relay
 func (c *Controller) disable(rs *relayStatus) {
 	rs.Disable()
+	c.Events.Emit(events.Disabled, rs.id, nil)
 	return
 }
 
@@ -182,10 +204,12 @@ func (c *Controller) Enroll(contractId string) error {
 		return ErrNotStarted
 	}
 
-	if rs, ok := c.relays[contractId]; ok {
-		return c.enroll(rs)
-	} else {
+	if rs, ok := c.relays[contractId]; !ok {
 		return fmt.Errorf(errTmpl, ErrContractNotFound, contractId)
+	} else if rs.Status().Flags.Maintenance {
+		return fmt.Errorf(errTmpl, ErrContractMaintenance, contractId)
+	} else {
+		return c.enroll(rs)
 	}
 }
 
@@ -196,8 +220,8 @@ func (c *Controller) EnrollAll() (err error) {
 	}
 
 	for _, rs := range c.relays {
-		if rs.Status().Flags.Enrolled {
-			// skipping relay already enrolled
+		if flags := rs.Status().Flags; flags.Enrolled || flags.Maintenance {
+			// skipping relay already enrolled or in maintenance
 		} else if err = c.enroll(rs); err != nil {
 			break
 		}
@@ -234,7 +258,7 @@ func (c *Controller) DisenrollAll() (err error) {
 	return
 }
 
-// Disenroll relay by contractId
+// Disable relay by contractId
 func (c *Controller) Disable(contractId string) error {
 	if c.hbt == nil {
 		return ErrNotStarted
@@ -248,6 +272,33 @@ func (c *Controller) Disable(contractId string) error {
 	}
 }
 
+// Enable relay by contractId
+func (c *Controller) Enable(contractId string) error {
+	if c.hbt == nil {
+		return ErrNotStarted
+	}
+
+	if rs, ok := c.relays[contractId]; ok {
+		rs.Enable()
+		c.Events.Emit(events.Enabled, contractId, nil)
+		return nil
+	} else {
+		return fmt.Errorf(errTmpl, ErrContractNotFound, contractId)
+	}
+}
+
+// Set maintenance mode by contractId
+// Relays in maintenance mode refuse new connections and are not enrolled
+func (c *Controller) SetMaintenance(contractId string, on bool) error {
+	if rs, ok := c.relays[contractId]; ok {
+		rs.SetMaintenance(on)
+		c.Events.Emit(events.Maintenance, contractId, map[string]bool{"enabled": on})
+		return nil
+	} else {
+		return fmt.Errorf(errTmpl, ErrContractNotFound, contractId)
+	}
+}
+
 // Controller starter
 // Enrolls relays and starts heartbeat goroutine
 func (c *Controller) Start() error {
@@ -265,8 +316,8 @@ func (c *Controller) StartWithList(contractIds ...string) error {
 	for _, contractId := range contractIds {
 		if rs, ok := c.relays[contractId]; !ok {
 			return fmt.Errorf(errTmpl, ErrContractNotFound, contractId)
-		} else if rs.Status().Flags.Enrolled {
-			// skipping relay already enrolled
+		} else if flags := rs.Status().Flags; flags.Enrolled || flags.Maintenance {
+			// skipping relay already enrolled or in maintenance
 		} else if err := c.enroll(rs); err != nil {
 			return err
 		}
@@ -342,7 +393,9 @@ func (c *Controller) NewConn(contractId string) (ctx context.Context, err error)
 		err = ErrNotStarted
 	} else if rs, ok := c.relays[contractId]; !ok {
 		err = fmt.Errorf(errTmpl, ErrContractNotFound, contractId)
-	} else if !rs.Status().Flags.Enrolled {
+	} else if flags := rs.Status().Flags; flags.Maintenance {
+		err = fmt.Errorf(errTmpl, ErrContractMaintenance, contractId)
+	} else if !flags.Enrolled {
 		err = fmt.Errorf(errTmpl, ErrContractNotAvailable, contractId)
 	} else {
 		ctx = rs.Context()
@@ -385,9 +438,98 @@ func (c *Controller) NetCap() (m map[string]uint64) {
 	m = make(map[string]uint64)
 
 	for contractId, rs := range c.relays {
+		rs.lock.RLock()
 		if rs.Relay.NetUsage != 0 {
 			m[contractId] = uint64(rs.Relay.NetUsage)
 		}
+		rs.lock.RUnlock()
+	}
+	return
+}
+
+// Returns current relays Netcap policies, by contractId
+func (c *Controller) NetCapPolicy() (m map[string]*netcap.Policy) {
+	m = make(map[string]*netcap.Policy)
+
+	for contractId, rs := range c.relays {
+		rs.lock.RLock()
+		if rs.policy != nil {
+			m[contractId] = rs.policy
+		}
+		rs.lock.RUnlock()
+	}
+	return
+}
+
+// Returns current relays Netcap pacing overrides, by contractId
+func (c *Controller) NetCapPacing() (m map[string]bool) {
+	m = make(map[string]bool)
+
+	for contractId, rs := range c.relays {
+		rs.lock.RLock()
+		if rs.pacing != nil {
+			m[contractId] = *rs.pacing
+		}
+		rs.lock.RUnlock()
+	}
+	return
+}
+
+// Returns current relays global limit priorities, by contractId
+func (c *Controller) NetCapPriority() (m map[string]int) {
+	m = make(map[string]int)
+
+	for contractId, rs := range c.relays {
+		rs.lock.RLock()
+		if rs.priority != 0 {
+			m[contractId] = rs.priority
+		}
+		rs.lock.RUnlock()
+	}
+	return
+}
+
+// Returns current relays fair queueing weights, by contractId
+func (c *Controller) FairQueueWeights() (m map[string]int) {
+	m = make(map[string]int)
+
+	for contractId, rs := range c.relays {
+		rs.lock.RLock()
+		if rs.weight != 0 {
+			m[contractId] = rs.weight
+		}
+		rs.lock.RUnlock()
+	}
+	return
+}
+
+// Returns current relays reserved shares of the global limit, by contractId
+func (c *Controller) NetCapReserved() (m map[string]uint64) {
+	m = make(map[string]uint64)
+
+	for contractId, rs := range c.relays {
+		rs.lock.RLock()
+		if rs.reserved != 0 {
+			m[contractId] = uint64(rs.reserved)
+		}
+		rs.lock.RUnlock()
+	}
+	return
+}
+
+// Returns current relays Netcap window limits, by contractId and window name
+func (c *Controller) NetCapWindows() (m map[string]map[string]uint64) {
+	m = make(map[string]map[string]uint64)
+
+	for contractId, rs := range c.relays {
+		rs.lock.RLock()
+		if len(rs.windows) != 0 {
+			m[contractId] = make(map[string]uint64, len(rs.windows))
+			for name, limit := range rs.windows {
+				m[contractId][name] = uint64(limit)
+			}
+		}
+		rs.lock.RUnlock()
 	}
 	return
 }
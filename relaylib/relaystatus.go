@@ -24,6 +24,10 @@ import (
 	"github.com/wireleap/common/api/status"
 	"github.com/wireleap/common/api/texturl"
 
+	"github.com/c2h5oh/datasize"
+
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/api/netcap"
 	"github.com/wireleap/relay/api/relayentryext"
 	"github.com/wireleap/relay/version"
 )
@@ -40,20 +44,46 @@ type relayStatus struct {
 	rdUrl  string
 	scUrl  string
 	lock   *sync.RWMutex
+	id     string
 	status RelayFlags
 	ctx    ctx_
+	// last successful enrollment or heartbeat (epoch millis)
+	lastBeat int64
+	lastErr  *RelayError
+	// network usage thresholds, not shared with the directory
+	policy *netcap.Policy
+	// network usage window limits, not shared with the directory
+	windows map[string]datasize.ByteSize
+	// network usage pacing override, not shared with the directory
+	pacing *bool
+	// global limit priority and reserved share, not shared with the directory
+	priority int
+	reserved datasize.ByteSize
+	// bandwidth share under congestion, not shared with the directory
+	weight int
 }
 
 // RelayStatus minified version of relayStatus
 type RelayStatus struct {
-	Addr  *texturl.URL
-	Role  string
-	Flags RelayFlags
+	Addr      *texturl.URL
+	Role      string
+	Flags     RelayFlags
+	Contract  string
+	Directory string
+	LastBeat  int64
+	LastError *RelayError
+}
+
+// RelayError is the last error raised while talking to the directory
+type RelayError struct {
+	At    int64  `json:"at"`
+	Error string `json:"error"`
 }
 
 type RelayFlags struct {
 	Enrolled      bool `json:"enrolled"`
 	NetCapReached bool `json:"network_cap_reached"`
+	Maintenance   bool `json:"maintenance"`
 	/**
 		ToDo: More flags to define the current status
 		- Failed heartbeats
@@ -112,10 +142,16 @@ func NewRelayStatus(cl *client.Client, scurl texturl.URL, cfg *relayentryext.T)
 	}
 
 	rs = relayStatus{
-		Relay: d,
-		rdUrl: dirurl,
-		scUrl: sc,
-		lock:  &sync.RWMutex{},
+		Relay:    d,
+		rdUrl:    dirurl,
+		scUrl:    sc,
+		lock:     &sync.RWMutex{},
+		policy:   cfg.NetUsagePolicy,
+		windows:  cfg.NetUsageWindows,
+		pacing:   cfg.NetUsagePacing,
+		priority: cfg.Priority,
+		reserved: cfg.NetUsageReserved,
+		weight:   cfg.FairQueueWeight,
 	}
 	return
 }
@@ -129,9 +165,18 @@ func (rs *relayStatus) Reload(cfg *relayentryext.T) (err error) {
 		return
 	}
 
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
 	rs.Relay.Addr = cfg.Addr
 	rs.Relay.Key = cfg.Key
 	rs.Relay.NetUsage = cfg.NetUsage
+	rs.policy = cfg.NetUsagePolicy
+	rs.windows = cfg.NetUsageWindows
+	rs.pacing = cfg.NetUsagePacing
+	rs.priority = cfg.Priority
+	rs.reserved = cfg.NetUsageReserved
+	rs.weight = cfg.FairQueueWeight
 	return
 }
 
@@ -167,14 +212,19 @@ func (rs *relayStatus) enroll(cl *client.Client, init bool, errHandler func(*rel
 		// Update relay status
 		rs.status.Enrolled = true
 		rs.status.NetCapReached = false
+		rs.lastBeat = epoch.EpochMillis()
 
 		if rs.ctx.isNil() {
 			// Renew context if not initialised
 			ctx, cancel := context.WithCancel(context.Background())
 			rs.ctx = ctx_{ctx, cancel}
 		}
-	} else if errHandler != nil {
-		err = errHandler(rs, err)
+	} else {
+		rs.setError(err)
+
+		if errHandler != nil {
+			err = errHandler(rs, err)
+		}
 	}
 	return
 }
@@ -191,12 +241,21 @@ func (rs *relayStatus) disenroll(cl *client.Client, errHandler func(*relayStatus
 	if err == nil {
 		// Update relay status
 		rs.status.Enrolled = false
-	} else if errHandler != nil {
-		err = errHandler(rs, err)
+	} else {
+		rs.setError(err)
+
+		if errHandler != nil {
+			err = errHandler(rs, err)
+		}
 	}
 	return
 }
 
+// Record last error, lock must be held
+func (rs *relayStatus) setError(err error) {
+	rs.lastErr = &RelayError{At: epoch.EpochMillis(), Error: err.Error()}
+}
+
 // Enroll relay, returns error
 func (rs *relayStatus) Enroll(cl *client.Client) (st *status.T, err error) {
 	rs.lock.Lock()
@@ -250,14 +309,41 @@ func (rs *relayStatus) Disable() {
 	return
 }
 
+// Enable relay, reverts Disable
+func (rs *relayStatus) Enable() {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	// Update relay status
+	rs.status.NetCapReached = false
+
+	// Renew context
+	if rs.ctx.isNil() {
+		ctx, cancel := context.WithCancel(context.Background())
+		rs.ctx = ctx_{ctx, cancel}
+	}
+}
+
+// Set relay maintenance mode
+func (rs *relayStatus) SetMaintenance(on bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.status.Maintenance = on
+}
+
 func (rs *relayStatus) Status() RelayStatus {
 
 	rs.lock.RLock()
 	defer rs.lock.RUnlock()
 	return RelayStatus{
-		Addr:  rs.Relay.Addr,
-		Role:  rs.Relay.Role,
-		Flags: rs.status,
+		Addr:      rs.Relay.Addr,
+		Role:      rs.Relay.Role,
+		Flags:     rs.status,
+		Contract:  rs.scUrl,
+		Directory: rs.rdUrl,
+		LastBeat:  rs.lastBeat,
+		LastError: rs.lastErr,
 	}
 }
 
@@ -13,8 +13,12 @@ type contractStat struct {
 
 type NetStats struct {
 	ContractStats map[string]*contractStat `json:"contract_stats,omitempty"`
-	CreatedAt     int64                    `json:"created_at,omitempty"`
-	UpdatedAt     int64                    `json:"updated_at,omitempty"`
+	// Usage within network usage windows, by window and contract
+	Windows   map[string]map[string]uint64 `json:"windows,omitempty"`
+	CreatedAt int64                        `json:"created_at,omitempty"`
+	UpdatedAt int64                        `json:"updated_at,omitempty"`
+	// Last network usage journal entry included
+	JournalSeq uint64 `json:"journal_seq,omitempty"`
 }
 
 func NewContractStats() map[string]*contractStat {
@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Wireleap
+
+// Package journal provides an append-only log of network usage deltas,
+// replayed on top of the last stored statistics after a crash.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Entry is the usage accounted since the previous entry
+type Entry struct {
+	// Seq numbers the entries, it keeps growing across truncations
+	Seq uint64 `json:"seq"`
+	// At is the entry time (epoch millis)
+	At int64 `json:"at"`
+	// Since is the start of the period the usage belongs to (epoch millis)
+	Since int64 `json:"since"`
+	// Usage is the usage delta in bytes, by contract
+	Usage map[string]uint64 `json:"usage"`
+}
+
+// T is the type of a network usage journal.
+type T struct {
+	f  *os.File
+	mu sync.Mutex
+}
+
+// Open opens the journal file under path, creating it if needed.
+func Open(path string) (*T, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal %s: %w", path, err)
+	}
+
+	// Terminate an incomplete trailing entry so it does not swallow the next one
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		b := make([]byte, 1)
+		if _, err = f.ReadAt(b, fi.Size()-1); err == nil && b[0] != '\n' {
+			f.Write([]byte{'\n'})
+		}
+	}
+	return &T{f: f}, nil
+}
+
+// Append appends an entry and syncs it to disk.
+func (t *T) Append(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err = t.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return t.f.Sync()
+}
+
+// Truncate drops every entry, once the usage is stored.
+func (t *T) Truncate() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.f.Truncate(0); err != nil {
+		return err
+	}
+	return t.f.Sync()
+}
+
+// Close closes the journal file.
+func (t *T) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.f.Close()
+}
+
+// Replay sums the usage of the entries of the period started at since
+// numbered after seq, the entries up to seq being already stored. It returns
+// the number of entries replayed and the last sequence number of the
+// journal. Incomplete entries, left by interrupted writes, are skipped.
+func Replay(path string, since int64, seq uint64) (usage map[string]uint64, n int, last uint64, err error) {
+	usage, last = map[string]uint64{}, seq
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, 0, last, nil
+	} else if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+
+		if e.Seq > last {
+			last = e.Seq
+		}
+		if e.Since != since || e.Seq <= seq {
+			continue
+		}
+
+		for ct, b := range e.Usage {
+			usage[ct] += b
+		}
+		n++
+	}
+
+	err = s.Err()
+	return
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Wireleap
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "journaltest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	p := filepath.Join(tmpd, "stats.journal")
+	if u, n, _, err := Replay(p, 100, 0); err != nil || n != 0 || len(u) != 0 {
+		t.Fatal("missing journal should replay nothing")
+	}
+
+	j, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []Entry{
+		{Seq: 1, At: 50, Since: 0, Usage: map[string]uint64{"ct1": 1000}},
+		{Seq: 2, At: 110, Since: 100, Usage: map[string]uint64{"ct1": 10, "ct2": 20}},
+		{Seq: 3, At: 120, Since: 100, Usage: map[string]uint64{"ct1": 5}},
+	} {
+		if err = j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	// Interrupted write
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":4,"at":130,"since":100,"usage":{"ct1":`)
+	f.Close()
+
+	u, n, last, err := Replay(p, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 2 || u["ct1"] != 15 || u["ct2"] != 20 || last != 3 {
+		t.Fatalf("wrong replay %d %d %v", n, last, u)
+	}
+
+	// Entries already stored are skipped
+	if u, n, _, _ = Replay(p, 100, 2); n != 1 || u["ct1"] != 5 || u["ct2"] != 0 {
+		t.Fatalf("stored entries should be skipped %d %v", n, u)
+	}
+
+	// Appending after the interrupted write
+	j, err = Open(p)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = j.Append(Entry{Seq: 4, At: 140, Since: 100, Usage: map[string]uint64{"ct2": 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, n, _, _ = Replay(p, 100, 0); n != 3 || u["ct2"] != 21 {
+		t.Fatalf("entries after an interrupted write should be replayed %d %v", n, u)
+	} else if err = j.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if _, n, _, _ = Replay(p, 100, 0); n != 0 {
+		t.Fatal("truncated journal should replay nothing")
+	}
+}
@@ -12,6 +12,8 @@ type ContractMetric struct {
 	Contract string `json:"contract"`
 	Active   bool   `json:"active"`
 	NetUsage uint64 `json:"network_usage_bytes"`
+	// Windows is the part of NetUsage within each network usage window
+	Windows map[string]uint64 `json:"window_usage_bytes,omitempty"`
 }
 
 type NetStats struct {
@@ -37,19 +39,29 @@ func mergeCts(ctActive map[string]bool, netusageMetrics map[string]uint64) (cts
 	return
 }
 
-func buildMetrics(ctActive map[string]bool, netusageMetrics map[string]uint64) (cms []ContractMetric) {
+func buildMetrics(ctActive map[string]bool, netusageMetrics map[string]uint64, windowMetrics map[string]map[string]uint64) (cms []ContractMetric) {
 	cts := mergeCts(ctActive, netusageMetrics)
 
 	cms = make([]ContractMetric, 0, len(cts))
 	for ct, b := range cts {
 		nu, _ := netusageMetrics[ct]
-		cms = append(cms, ContractMetric{Contract: ct, Active: b, NetUsage: nu})
+		cm := ContractMetric{Contract: ct, Active: b, NetUsage: nu}
+
+		for w, wm := range windowMetrics {
+			if wnu, ok := wm[ct]; ok {
+				if cm.Windows == nil {
+					cm.Windows = make(map[string]uint64)
+				}
+				cm.Windows[w] = wnu
+			}
+		}
+		cms = append(cms, cm)
 	}
 	return
 }
 
-func NewArchiveFile(relayId string, ctActive map[string]bool, netusageMetrics map[string]uint64, startAt, endAt int64) NetStats {
-	metrics := buildMetrics(ctActive, netusageMetrics)
+func NewArchiveFile(relayId string, ctActive map[string]bool, netusageMetrics map[string]uint64, windowMetrics map[string]map[string]uint64, startAt, endAt int64) NetStats {
+	metrics := buildMetrics(ctActive, netusageMetrics, windowMetrics)
 	return NetStats{
 		RelayId:   relayId,
 		Metrics:   metrics,
@@ -51,7 +51,7 @@ func TestArchive(t *testing.T) {
 	// ending recording date
 	until := ns.CreatedAt
 
-	archive := NewArchiveFile("someRelayId", map[string]bool{}, m, since, until)
+	archive := NewArchiveFile("someRelayId", map[string]bool{}, m, nil, since, until)
 
 	// match metric lengths
 	if len(archive.Metrics) != len(m) {
@@ -138,7 +138,7 @@ func TestArchiveActiveCT(t *testing.T) {
 		"ct_zero2": false,
 	}
 
-	archive := NewArchiveFile("someRelayId", ctActive, m, since, until)
+	archive := NewArchiveFile("someRelayId", ctActive, m, nil, since, until)
 
 	// match metric lengths
 	if len(archive.Metrics) != len(m)+2 {
@@ -6,6 +6,8 @@ import (
 	"sync"
 
 	"github.com/wireleap/common/cli/fsdir"
+
+	"github.com/wireleap/relay/api/atomicfile"
 )
 
 // T is the type of a network usage store.
@@ -30,5 +32,5 @@ func (t *T) Add(ns NetStats) error {
 
 	_, k2, k3 := ns.Keys()
 
-	return t.m.Set(ns, k2+"-"+k3+".json")
+	return atomicfile.Set(t.m, ns, k2+"-"+k3+".json")
 }
@@ -30,7 +30,7 @@ func Test(t *testing.T) {
 	since := int64(100)
 	until := int64(200)
 
-	archive := NewArchiveFile("someRelayId", map[string]bool{}, m, since, until)
+	archive := NewArchiveFile("someRelayId", map[string]bool{}, m, nil, since, until)
 
 	if err := s.Add(archive); err != nil {
 		t.Fatalf("error should ne nil: %s", err)
@@ -0,0 +1,230 @@
+// Copyright (c) 2022 Wireleap
+
+package nustore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wireleap/relay/api/janitor"
+)
+
+// Query selects archived periods
+type Query struct {
+	// Since excludes the periods ended before it (epoch millis), 0 if unbounded
+	Since int64
+	// Until excludes the periods started after it (epoch millis), 0 if unbounded
+	Until int64
+	// Contract restricts the metrics to a single contract, if set
+	Contract string
+}
+
+// Returns if a period overlaps the query interval
+func (q Query) overlaps(startAt, endAt int64) bool {
+	return (q.Since == 0 || endAt > q.Since) && (q.Until == 0 || startAt < q.Until)
+}
+
+// ContractUsage is the usage of a contract over several periods
+type ContractUsage struct {
+	Contract string            `json:"contract"`
+	NetUsage uint64            `json:"network_usage_bytes"`
+	Windows  map[string]uint64 `json:"window_usage_bytes,omitempty"`
+}
+
+// Usage is the usage aggregated over several periods
+type Usage struct {
+	// StartAt and EndAt are the interval covered by the periods
+	StartAt   int64           `json:"start_at"`
+	EndAt     int64           `json:"end_at"`
+	Periods   int             `json:"periods"`
+	NetUsage  uint64          `json:"network_usage_bytes"`
+	Contracts []ContractUsage `json:"contracts"`
+}
+
+// History is the result of a query
+type History struct {
+	Periods []NetStats `json:"periods"`
+	Usage   Usage      `json:"usage"`
+}
+
+// Returns the end of a period from its archive file name
+func endAt(name string) (int64, bool) {
+	if !strings.HasSuffix(name, ".json") {
+		return 0, false
+	}
+
+	name = strings.TrimSuffix(filepath.Base(name), ".json")
+	i, err := strconv.ParseInt(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	return i, err == nil
+}
+
+// Calls fn for every archived period, compacted bundles included, load
+// reads the period. Bundled periods are named after their bundle, e.g.
+// archive-2022-08.tar.gz/<relayid>-<endat>.json
+// The archive janitor may remove or compact files meanwhile: files gone
+// after being listed are skipped, and periods found both in a bundle and
+// as a file are only visited once.
+func (t *T) walk(fn func(name string, end int64, load func() (NetStats, error)) error) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	fis, err := ioutil.ReadDir(t.m.Path())
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() {
+			continue
+		} else if janitor.IsBundle(name) {
+			err = janitor.ReadBundle(t.m.Path(name), func(member string, _ time.Time, data []byte) error {
+				e, ok := endAt(member)
+				if !ok || seen[member] {
+					return nil
+				}
+
+				seen[member] = true
+				return fn(name+"/"+member, e, func() (ns NetStats, err error) {
+					err = json.Unmarshal(data, &ns)
+					return
+				})
+			})
+		} else if e, ok := endAt(name); ok && !seen[name] {
+			seen[name] = true
+			err = fn(name, e, func() (ns NetStats, err error) {
+				err = t.m.Get(&ns, name)
+				return
+			})
+		}
+
+		if errors.Is(err, os.ErrNotExist) {
+			// removed or compacted by the janitor
+			err = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the archived periods names, sorted by period end
+func (t *T) List() (names []string, err error) {
+	ends := map[string]int64{}
+	err = t.walk(func(name string, end int64, _ func() (NetStats, error)) error {
+		names = append(names, name)
+		ends[name] = end
+		return nil
+	})
+
+	sort.Slice(names, func(i, j int) bool { return ends[names[i]] < ends[names[j]] })
+	return
+}
+
+// Get loads an archived period by name
+func (t *T) Get(name string) (ns NetStats, err error) {
+	err = fmt.Errorf("%w: %s", os.ErrNotExist, name)
+	errW := t.walk(func(n string, _ int64, load func() (NetStats, error)) error {
+		if n == name {
+			ns, err = load()
+		}
+		return nil
+	})
+
+	if errW != nil {
+		err = errW
+	}
+	return
+}
+
+// Range loads the archived periods matching the query, sorted by start
+func (t *T) Range(q Query) (nss []NetStats, err error) {
+	nss = []NetStats{}
+	err = t.walk(func(_ string, end int64, load func() (NetStats, error)) error {
+		if !q.overlaps(0, end) {
+			return nil
+		}
+
+		ns, err := load()
+		if err != nil {
+			return err
+		} else if !q.overlaps(ns.StartAt, ns.EndAt) {
+			return nil
+		}
+
+		if q.Contract != "" {
+			ms := []ContractMetric{}
+			for _, cm := range ns.Metrics {
+				if cm.Contract == q.Contract {
+					ms = append(ms, cm)
+				}
+			}
+			ns.Metrics = ms
+		}
+
+		sort.Slice(ns.Metrics, func(i, j int) bool { return ns.Metrics[i].Contract < ns.Metrics[j].Contract })
+		nss = append(nss, ns)
+		return nil
+	})
+
+	sort.SliceStable(nss, func(i, j int) bool { return nss[i].StartAt < nss[j].StartAt })
+	return
+}
+
+// History loads the archived periods matching the query and aggregates them
+func (t *T) History(q Query) (h History, err error) {
+	if h.Periods, err = t.Range(q); err == nil {
+		h.Usage = Aggregate(h.Periods)
+	}
+	return
+}
+
+// Aggregate sums the usage of several periods, by contract
+func Aggregate(nss []NetStats) (u Usage) {
+	cus := map[string]*ContractUsage{}
+	for i, ns := range nss {
+		if i == 0 || ns.StartAt < u.StartAt {
+			u.StartAt = ns.StartAt
+		}
+
+		if ns.EndAt > u.EndAt {
+			u.EndAt = ns.EndAt
+		}
+
+		for _, cm := range ns.Metrics {
+			cu, ok := cus[cm.Contract]
+			if !ok {
+				cu = &ContractUsage{Contract: cm.Contract}
+				cus[cm.Contract] = cu
+			}
+
+			cu.NetUsage += cm.NetUsage
+			u.NetUsage += cm.NetUsage
+
+			for w, b := range cm.Windows {
+				if cu.Windows == nil {
+					cu.Windows = map[string]uint64{}
+				}
+				cu.Windows[w] += b
+			}
+		}
+	}
+
+	u.Periods = len(nss)
+	u.Contracts = make([]ContractUsage, 0, len(cus))
+	for _, cu := range cus {
+		u.Contracts = append(u.Contracts, *cu)
+	}
+
+	sort.Slice(u.Contracts, func(i, j int) bool { return u.Contracts[i].Contract < u.Contracts[j].Contract })
+	return
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2022 Wireleap
+
+package nustore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wireleap/relay/api/janitor"
+)
+
+func TestHistory(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "nutest.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpd) })
+
+	s, err := New(tmpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []NetStats{
+		NewArchiveFile("relay-id", nil, map[string]uint64{"ct1": 100, "ct2": 10}, nil, 100, 200),
+		NewArchiveFile("relay-id", nil, map[string]uint64{"ct1": 200}, map[string]map[string]uint64{"night": {"ct1": 50}}, 200, 300),
+		NewArchiveFile("relay-id", nil, map[string]uint64{"ct2": 30}, nil, 300, 400),
+	} {
+		if err = s.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Not an archive file
+	ioutil.WriteFile(tmpd+"/README", []byte("x"), 0644)
+
+	if names, err := s.List(); err != nil {
+		t.Fatal(err)
+	} else if len(names) != 3 || names[0] != "relay-id-200.json" {
+		t.Fatalf("wrong archive list %v", names)
+	}
+
+	if _, err = s.Get("README"); err == nil {
+		t.Fatal("should only load archive files")
+	}
+
+	h, err := s.History(Query{})
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Usage.Periods != 3 || h.Usage.StartAt != 100 || h.Usage.EndAt != 400 || h.Usage.NetUsage != 340 {
+		t.Fatalf("wrong aggregated usage %+v", h.Usage)
+	}
+
+	h, err = s.History(Query{Since: 250, Contract: "ct1"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(h.Periods) != 2 || h.Periods[0].StartAt != 200 {
+		t.Fatalf("wrong periods %+v", h.Periods)
+	} else if u := h.Usage; u.NetUsage != 200 || len(u.Contracts) != 1 || u.Contracts[0].Windows["night"] != 50 {
+		t.Fatalf("wrong contract usage %+v", u)
+	}
+
+	if h, err = s.History(Query{Since: 150, Until: 250}); err != nil {
+		t.Fatal(err)
+	} else if h.Usage.Periods != 2 || h.Usage.NetUsage != 310 {
+		t.Fatalf("wrong range usage %+v", h.Usage)
+	}
+
+	// Compacted periods stay readable
+	if r, err := janitor.Run(tmpd, janitor.Policy{CompactAfter: time.Minute}, time.Now().Add(time.Hour)); err != nil || r.Compacted != 3 {
+		t.Fatalf("could not compact %+v %v", r, err)
+	}
+
+	if names, err := s.List(); err != nil || len(names) != 3 || !strings.HasSuffix(names[0], ".tar.gz/relay-id-200.json") {
+		t.Fatalf("wrong bundled archive list %v %v", names, err)
+	} else if ns, err := s.Get(names[2]); err != nil || ns.EndAt != 400 {
+		t.Fatalf("could not get bundled period %v", err)
+	}
+
+	if h, err = s.History(Query{Since: 150, Until: 250}); err != nil {
+		t.Fatal(err)
+	} else if h.Usage.Periods != 2 || h.Usage.NetUsage != 310 {
+		t.Fatalf("wrong bundled range usage %+v", h.Usage)
+	}
+
+	// Periods not removed yet after being bundled are not counted twice
+	if err = s.Add(NewArchiveFile("relay-id", nil, map[string]uint64{"ct2": 30}, nil, 300, 400)); err != nil {
+		t.Fatal(err)
+	}
+
+	if h, err = s.History(Query{}); err != nil {
+		t.Fatal(err)
+	} else if h.Usage.Periods != 3 || h.Usage.NetUsage != 340 {
+		t.Fatalf("bundled period should be counted once %+v", h.Usage)
+	}
+}
+
+func TestWalkRemoved(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, end := range []int64{200, 300, 400} {
+		if err = s.Add(NewArchiveFile("relay-id", nil, map[string]uint64{"ct1": 100}, nil, end-100, end)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the janitor removes files between the listing and their loading
+	var loaded int
+	err = s.walk(func(name string, _ int64, load func() (NetStats, error)) error {
+		if name == "relay-id-200.json" {
+			os.Remove(s.m.Path("relay-id-300.json"))
+		}
+
+		if _, err := load(); err != nil {
+			return err
+		}
+		loaded++
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("removed files should be skipped: %s", err)
+	} else if loaded != 2 {
+		t.Fatalf("wrong number of loaded periods %d", loaded)
+	}
+}
@@ -13,7 +13,11 @@ import (
 
 type NetStats struct {
 	ContractStats map_counter.Map
-	CreatedAt     int64
+	// Usage within network usage windows, by window and contract
+	Windows   map[string]map[string]uint64
+	CreatedAt int64
+	// Last network usage journal entry accounted
+	JournalSeq uint64
 }
 
 // Initialise Netstats
@@ -42,6 +46,24 @@ func (ns *NetStats) ResetWithDate(t time.Time) (m map[string]uint64, ok bool) {
 	return
 }
 
+// Add the usage of a contract within a window
+func (ns *NetStats) AddWindow(window, contract string, n uint64) {
+	if ns.Windows == nil {
+		ns.Windows = make(map[string]map[string]uint64)
+	}
+
+	if ns.Windows[window] == nil {
+		ns.Windows[window] = make(map[string]uint64)
+	}
+	ns.Windows[window][contract] += n
+}
+
+// Reset windows usage, returns the previous one
+func (ns *NetStats) ResetWindows() (m map[string]map[string]uint64) {
+	m, ns.Windows = ns.Windows, nil
+	return
+}
+
 // Returns time next Reset in the future, and if NS should have been already reset
 func (ns NetStats) GetNextReset(d time.Duration) (time.Time, bool) {
 	c_at := epoch.FromEpochMillis(ns.CreatedAt)
@@ -59,9 +81,40 @@ func (ns NetStats) GetNextReset(d time.Duration) (time.Time, bool) {
 	}
 }
 
+// Calendar schedule of the periods
+type Schedule interface {
+	// Next returns the first period boundary strictly after t
+	Next(t time.Time) time.Time
+}
+
+// Returns time next Reset in the future following a calendar schedule, the
+// start of the current period, and if NS should have been already reset
+func (ns NetStats) GetNextScheduledReset(s Schedule) (next, since time.Time, reset bool) {
+	c_at := epoch.FromEpochMillis(ns.CreatedAt)
+	now := time.Now()
+
+	if next = s.Next(c_at); next.After(now) {
+		return next, c_at, false
+	}
+
+	// Current period started at the last boundary before now
+	for since = next; ; since = next {
+		if next = s.Next(since); next.After(now) {
+			return next, since, true
+		}
+	}
+}
+
 // Load from file format
 func Load(sfile *file.NetStats, initMap func() map_counter.Map) (ns NetStats) {
-	ns = NetStats{initMap(), sfile.CreatedAt}
+	ns = NetStats{ContractStats: initMap(), CreatedAt: sfile.CreatedAt, JournalSeq: sfile.JournalSeq}
+
+	for w, cts := range sfile.Windows {
+		for ct, n := range cts {
+			ns.AddWindow(w, ct, n)
+		}
+	}
+
 	for ct, cs := range sfile.ContractStats {
 
 		if cs != nil {
@@ -110,6 +163,8 @@ func Save(ns NetStats, sfile *file.NetStats) bool {
 	res := ns.ContractStats.Range(fLoad)
 	if res {
 		sfile.CreatedAt = ns.CreatedAt
+		sfile.Windows = ns.Windows
+		sfile.JournalSeq = ns.JournalSeq
 	}
 	return res
 }
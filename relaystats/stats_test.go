@@ -157,3 +157,62 @@ func TestNetStatsGetNextReset(t *testing.T) {
 	}
 
 }
+
+// Schedule with boundaries every minute
+type minutes struct{}
+
+func (minutes) Next(t time.Time) time.Time {
+	return t.Truncate(time.Minute).Add(time.Minute)
+}
+
+func TestNetStatsGetNextScheduledReset(t *testing.T) {
+	ns := NewNetStats(map_counter.NewList)
+	now := time.Now()
+
+	// Common case
+	next_reset, since, reset_now := ns.GetNextScheduledReset(minutes{})
+	if reset_now {
+		t.Error("Shouldn't reset now")
+	}
+
+	if !next_reset.Equal(now.Truncate(time.Minute).Add(time.Minute)) {
+		t.Error("Incorrect next_reset date")
+	}
+
+	if since != epoch.FromEpochMillis(ns.CreatedAt) {
+		t.Error("Incorrect period start")
+	}
+
+	// Several periods ended, reseting to the current period
+	ns.CreatedAt = ns.CreatedAt - int64(300000) // fake sleep
+
+	next_reset, since, reset_now = ns.GetNextScheduledReset(minutes{})
+	if !reset_now {
+		t.Error("Should reset now")
+	}
+
+	if !since.Equal(now.Truncate(time.Minute)) || !next_reset.Equal(since.Add(time.Minute)) {
+		t.Error("Incorrect next_reset date")
+	}
+}
+
+func TestNetStatsWindows(t *testing.T) {
+	ns := NewNetStats(map_counter.NewList)
+
+	ns.AddWindow("offpeak", "ct1", 100)
+	ns.AddWindow("offpeak", "ct1", 50)
+
+	fns := NewFileNetStats()
+	if !Save(ns, fns) {
+		t.Error("Couldn't save contract stats")
+	}
+
+	ns = Load(fns, map_counter.NewList)
+	if ns.Windows["offpeak"]["ct1"] != 150 {
+		t.Error("Couldn't recover window stats")
+	}
+
+	if w := ns.ResetWindows(); w["offpeak"]["ct1"] != 150 || ns.Windows != nil {
+		t.Error("Couldn't reset window stats")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Wireleap
+
+package restapi
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/common/cli/fsdir"
+	"github.com/wireleap/relay/filenames"
+)
+
+// Token scopes
+const (
+	// ScopeRead grants access to GET and HEAD requests
+	ScopeRead = "read"
+	// ScopeAdmin grants access to every request
+	ScopeAdmin = "admin"
+)
+
+var ErrUnauthorized = &status.T{
+	Code: http.StatusUnauthorized,
+	Desc: "authentication required",
+}
+
+// Token is an API REST bearer token
+type Token struct {
+	Name  string `json:"name,omitempty"`
+	Token string `json:"token"`
+	Scope string `json:"scope"`
+}
+
+// Allows returns if the token scope grants access to a request method
+func (tk Token) Allows(method string) bool {
+	switch tk.Scope {
+	case ScopeAdmin:
+		return true
+	case ScopeRead:
+		return method == http.MethodGet || method == http.MethodHead
+	default:
+		return false
+	}
+}
+
+// Bearer tokens holder
+type tokens struct {
+	lock sync.RWMutex
+	l    []Token
+}
+
+// LoadTokens reads the bearer tokens file from the relay home
+// A missing file results in an empty list.
+func LoadTokens(fm fsdir.T) (l []Token, err error) {
+	if err = fm.Get(&l, filenames.ApiTokens); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, tk := range l {
+		if tk.Token == "" {
+			return nil, fmt.Errorf("api token %d failed to validate: 'token' cannot be empty", i)
+		} else if tk.Scope != ScopeRead && tk.Scope != ScopeAdmin {
+			return nil, fmt.Errorf("api token %d failed to validate: unknown scope %q", i, tk.Scope)
+		}
+	}
+	return
+}
+
+// Returns the token matching a bearer string
+func (ts *tokens) lookup(bearer string) (Token, bool) {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+
+	for _, tk := range ts.l {
+		if subtle.ConstantTimeCompare([]byte(tk.Token), []byte(bearer)) == 1 {
+			return tk, true
+		}
+	}
+	return Token{}, false
+}
+
+// Replace the current tokens
+func (ts *tokens) set(l []Token) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	ts.l = l
+}
+
+// authGate rejects requests without a valid bearer token for the scope
+// required by the request method.
+func (ts *tokens) authGate(targetMux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := r.Header.Get("Authorization")
+		if !strings.HasPrefix(h, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wireleap-relay"`)
+			ErrUnauthorized.WriteTo(w)
+			return
+		}
+
+		tk, ok := ts.lookup(strings.TrimPrefix(h, "Bearer "))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wireleap-relay", error="invalid_token"`)
+			ErrUnauthorized.Wrap(status.Cause("invalid token")).WriteTo(w)
+			return
+		} else if !tk.Allows(r.Method) {
+			status.ErrForbidden.Wrap(status.Cause(
+				fmt.Sprintf("token scope %q does not allow %s requests", tk.Scope, r.Method),
+			)).WriteTo(w)
+			return
+		}
+		targetMux.ServeHTTP(w, r)
+	})
+}
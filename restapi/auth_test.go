@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Wireleap
+
+package restapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/wireleap/common/api/texturl"
+	"github.com/wireleap/common/cli/fsdir"
+	"github.com/wireleap/relay/contractmanager"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaycfg"
+)
+
+func TestLoadTokens(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "wlrelay-restapi.*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpd)
+
+	fm, err := fsdir.New(tmpd)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := LoadTokens(fm); err != nil || len(l) != 0 {
+		t.Fatal("missing tokens file should result in no tokens")
+	}
+
+	if err = fm.Set([]Token{{Token: "abc", Scope: "root"}}, filenames.ApiTokens); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadTokens(fm); err == nil {
+		t.Fatal("unknown scope should fail to validate")
+	}
+
+	if err = fm.Set([]Token{{Name: "dashboard", Token: "abc", Scope: ScopeRead}}, filenames.ApiTokens); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := LoadTokens(fm); err != nil || len(l) != 1 || l[0].Name != "dashboard" {
+		t.Fatal("could not load tokens")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	fm, err := fsdir.New(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fm.Set([]Token{{Token: "abc", Scope: "root"}}, filenames.ApiTokens); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := relaycfg.RestApi{Address: texturl.URLMustParse("http://127.0.0.1:0"), Auth: true}
+
+	if err = New(contractmanager.NewDummyManager(), fm).Configure(cfg); err == nil {
+		t.Fatal("invalid tokens should fail to configure the api")
+	}
+}
+
+func TestAuthGate(t *testing.T) {
+	ts := &tokens{}
+	ts.set([]Token{
+		{Token: "readtoken", Scope: ScopeRead},
+		{Token: "admintoken", Scope: ScopeAdmin},
+	})
+
+	h := ts.authGate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		method, token string
+		code          int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodGet, "wrongtoken", http.StatusUnauthorized},
+		{http.MethodGet, "readtoken", http.StatusOK},
+		{http.MethodPost, "readtoken", http.StatusForbidden},
+		{http.MethodGet, "admintoken", http.StatusOK},
+		{http.MethodPost, "admintoken", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "/api/status", nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != c.code {
+			t.Fatalf("%s with token %q: expected %d, got %d", c.method, c.token, c.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2022 Wireleap
+
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/common/cli/fsdir"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaycfg"
+)
+
+var ErrNotConfigured = errors.New("'rest_api.address' has to be set to reach the running relay")
+
+// Client is a minimal client for the API REST of a running relay.
+type Client struct {
+	base  string
+	hc    *http.Client
+	token string
+}
+
+// NewClient returns a client for the API REST described by cfg, fm is the
+// relay home holding the bearer tokens and TLS certificate.
+func NewClient(cfg relaycfg.RestApi, fm fsdir.T) (*Client, error) {
+	if cfg.Address == nil {
+		return nil, ErrNotConfigured
+	}
+
+	tr := &http.Transport{}
+	c := &Client{hc: &http.Client{Transport: tr, Timeout: 30 * time.Second}}
+
+	if cfg.Auth {
+		tks, err := LoadTokens(fm)
+		if err != nil {
+			return nil, err
+		}
+
+		// prefer admin tokens
+		for _, tk := range tks {
+			if tk.Scope == ScopeAdmin {
+				c.token = tk.Token
+				break
+			} else if c.token == "" {
+				c.token = tk.Token
+			}
+		}
+	}
+
+	switch cfg.Address.Scheme {
+	case "file":
+		path := cfg.Address.Path
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		c.base = "http://unix"
+	case "http":
+		c.base = "http://" + cfg.Address.Host
+	case "https":
+		tc, err := pinnedTLSConfig(cfg.TLS, fm)
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSClientConfig = tc
+		c.base = "https://" + cfg.Address.Host
+	default:
+		return nil, fmt.Errorf("restapi address %s has unknown scheme: %s", cfg.Address.String(), cfg.Address.Scheme)
+	}
+	return c, nil
+}
+
+// Do performs a request against the API REST, x is JSON-encoded as the
+// request body if not nil and the response body is decoded into y if not nil.
+func (c *Client) Do(method, path string, x, y interface{}) (err error) {
+	var body io.Reader
+	if x != nil {
+		var b []byte
+		if b, err = json.Marshal(x); err != nil {
+			return
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return
+	}
+
+	if x != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		st := &status.T{}
+		if err = json.Unmarshal(b, st); err != nil {
+			return fmt.Errorf("unexpected response %s: %s", res.Status, bytes.TrimSpace(b))
+		}
+		return st
+	}
+
+	if y != nil {
+		err = json.Unmarshal(b, y)
+	}
+	return
+}
+
+// Resolves a configured path from the relay home
+func homePath(fm fsdir.T, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return fm.Path(p)
+}
+
+// pinnedTLSConfig returns a TLS configuration trusting only the server
+// certificate configured in the relay home, presenting the configured client
+// certificate if any.
+func pinnedTLSConfig(cfg *relaycfg.RestApiTLS, fm fsdir.T) (*tls.Config, error) {
+	certFile := fm.Path(filenames.TLSCert)
+	if cfg != nil && cfg.CertFile != "" {
+		certFile = homePath(fm, cfg.CertFile)
+	}
+
+	var certs []tls.Certificate
+	if cfg != nil && cfg.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(homePath(fm, cfg.ClientCertFile), homePath(fm, cfg.ClientKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("could not load api client certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	} else if cfg != nil && cfg.ClientCA != "" {
+		return nil, errors.New("api requires mTLS but no client certificate is configured")
+	}
+
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+
+	return &tls.Config{
+		Certificates: certs,
+		// the relay certificate is self-signed, verify it by pinning instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 || !bytes.Equal(raw[0], blk.Bytes) {
+				return errors.New("api server certificate does not match the configured certificate")
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2022 Wireleap
+
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/relay/api/events"
+)
+
+// Interval between keepalive comments on idle event streams
+const eventsKeepalive = 15 * time.Second
+
+// Write an event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, ev events.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Id, ev.Type, b)
+	return err
+}
+
+// events serves /api/events as a Server-Sent Events stream
+// Clients can resume a stream with the Last-Event-ID header and filter
+// event types with the types query parameter.
+func (t *T) events(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		status.ErrNotImplemented.Wrap(status.Cause("streaming not supported")).WriteTo(w)
+		return
+	}
+
+	var lastId uint64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		var err error
+		if lastId, err = strconv.ParseUint(s, 10, 64); err != nil {
+			status.ErrRequest.Wrap(fmt.Errorf("invalid Last-Event-ID: %w", err)).WriteTo(w)
+			return
+		}
+	}
+
+	var types map[string]bool
+	if s := r.URL.Query().Get("types"); s != "" {
+		types = map[string]bool{}
+		for _, typ := range strings.Split(s, ",") {
+			types[typ] = true
+		}
+	}
+
+	backlog, ch, cancel := t.manager.Events.Subscribe(lastId)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev events.Event) error {
+		if types != nil && !types[ev.Type] {
+			return nil
+		}
+		return writeEvent(w, ev)
+	}
+
+	for _, ev := range backlog {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+	fl.Flush()
+
+	ka := time.NewTicker(eventsKeepalive)
+	defer ka.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				// fell behind, the client will reconnect and catch up
+				return
+			} else if err := send(ev); err != nil {
+				return
+			}
+		case <-ka.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		fl.Flush()
+	}
+}
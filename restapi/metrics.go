@@ -0,0 +1,230 @@
+// Copyright (c) 2022 Wireleap
+
+package restapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/wireleap/relay/api/admission"
+	"github.com/wireleap/relay/api/resolver"
+)
+
+// Prometheus text exposition format writer
+type metrics struct {
+	bytes.Buffer
+}
+
+// Metric sample, labels are formatted as `name="value"` pairs
+type sample struct {
+	labels string
+	value  float64
+}
+
+// Write a gauge and its samples
+func (m *metrics) gauge(name, help string, samples ...sample) {
+	m.family("gauge", name, help, samples...)
+}
+
+// Write a counter and its samples, by label
+func (m *metrics) counters(name, help string, samples ...sample) {
+	m.family("counter", name, help, samples...)
+}
+
+// Write a metric family of the given type
+func (m *metrics) family(typ, name, help string, samples ...sample) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, s := range samples {
+		if s.labels != "" {
+			fmt.Fprintf(m, "%s{%s} %s\n", name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		} else {
+			fmt.Fprintf(m, "%s %s\n", name, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+}
+
+// Write a counter and its value
+func (m *metrics) counter(name, help string, value uint64) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// Returns a contract label
+func contractLabel(id string) string {
+	return "contract=" + strconv.Quote(id)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metrics serves /api/metrics in the Prometheus text format
+func (t *T) metrics(w http.ResponseWriter, r *http.Request) {
+	ms := t.manager.Status()
+	m := &metrics{}
+
+	var enrolled, paced, usage, caps, rates, ttcs []sample
+	for _, rs := range ms.RelayStatus {
+		l := contractLabel(rs.Id)
+
+		enrolled = append(enrolled, sample{l, boolValue(rs.Status.Enrolled)})
+		paced = append(paced, sample{l, boolValue(rs.Paced)})
+		usage = append(usage, sample{l, float64(rs.NetUsage)})
+		rates = append(rates, sample{l, float64(rs.NetRate)})
+
+		if rs.NetCap != nil {
+			caps = append(caps, sample{l, float64(*rs.NetCap)})
+		}
+
+		if rs.TimeToCap != nil {
+			ttcs = append(ttcs, sample{l, float64(*rs.TimeToCap) / 1000})
+		}
+	}
+
+	m.gauge("wireleap_relay_enrolled", "Whether the relay is enrolled into the contract.", enrolled...)
+	m.gauge("wireleap_relay_network_usage_bytes", "Contract network usage in the current period.", usage...)
+	m.gauge("wireleap_relay_network_cap_bytes", "Contract network cap.", caps...)
+	m.gauge("wireleap_relay_network_rate_bytes_per_second", "Contract network usage rate.", rates...)
+	m.gauge("wireleap_relay_network_time_to_cap_seconds", "Estimated time until the contract cap is reached.", ttcs...)
+	m.gauge("wireleap_relay_network_paced", "Whether the contract bandwidth is paced.", paced...)
+
+	if n := ms.Network; n != nil {
+		if n.Usage != nil {
+			m.gauge("wireleap_relay_global_network_usage_bytes", "Global network usage in the current period.", sample{value: float64(*n.Usage)})
+		}
+
+		if n.Until != nil {
+			m.gauge("wireleap_relay_global_network_period_end_seconds", "End of the current period (epoch seconds).", sample{value: float64(*n.Until) / 1000})
+		}
+
+		if n.Cap != nil && *n.Cap != 0 {
+			m.gauge("wireleap_relay_global_network_cap_bytes", "Global network cap.", sample{value: float64(*n.Cap)})
+		}
+
+		if n.Rate != nil {
+			m.gauge("wireleap_relay_global_network_rate_bytes_per_second", "Global network usage rate.", sample{value: float64(*n.Rate)})
+		}
+
+		if n.TimeToCap != nil {
+			m.gauge("wireleap_relay_global_network_time_to_cap_seconds", "Estimated time until the global cap is reached.", sample{value: float64(*n.TimeToCap) / 1000})
+		}
+	}
+
+	if t.nextHops != nil {
+		if s, ok := t.nextHops(); ok {
+			var conns, streams []sample
+			for _, ts := range s.Targets {
+				l := "target=" + strconv.Quote(ts.Target)
+				conns = append(conns, sample{l, float64(ts.Connections)})
+				streams = append(streams, sample{l, float64(ts.Streams)})
+			}
+
+			m.gauge("wireleap_relay_next_hop_connections", "Pooled connections to the next relay.", conns...)
+			m.gauge("wireleap_relay_next_hop_streams", "Tunnels open over the pooled connections to the next relay.", streams...)
+			m.counter("wireleap_relay_next_hop_dials_total", "Pooled connections dialed.", s.Dials)
+			m.counter("wireleap_relay_next_hop_streams_total", "Tunnels opened over pooled connections.", s.Streams)
+			m.counter("wireleap_relay_next_hop_fallbacks_total", "Tunnels to a next relay dialed without the pool.", s.Fallbacks)
+			m.counter("wireleap_relay_next_hop_health_failures_total", "Pooled connections failing their health check.", s.HealthFailures)
+		}
+	}
+
+	if t.peers != nil {
+		ps := t.peers()
+		peers := make([]string, 0, len(ps))
+		for k := range ps {
+			peers = append(peers, k)
+		}
+		sort.Strings(peers)
+
+		var rtt, minRTT, maxRTT, pings, dead, tunnels []sample
+		for _, k := range peers {
+			s, l := ps[k], "peer="+strconv.Quote(k)
+			if s.Pings > 0 {
+				rtt = append(rtt, sample{l, s.RTT.Seconds()})
+				minRTT = append(minRTT, sample{l, s.MinRTT.Seconds()})
+				maxRTT = append(maxRTT, sample{l, s.MaxRTT.Seconds()})
+			}
+			pings = append(pings, sample{l, float64(s.Pings)})
+			dead = append(dead, sample{l, float64(s.Dead)})
+			tunnels = append(tunnels, sample{l, float64(s.DeadTunnels)})
+		}
+
+		m.gauge("wireleap_relay_peer_rtt_seconds", "Smoothed keepalive ping round-trip time.", rtt...)
+		m.gauge("wireleap_relay_peer_rtt_min_seconds", "Lowest keepalive ping round-trip time.", minRTT...)
+		m.gauge("wireleap_relay_peer_rtt_max_seconds", "Highest keepalive ping round-trip time.", maxRTT...)
+		m.counters("wireleap_relay_peer_pings_total", "Acknowledged keepalive pings.", pings...)
+		m.counters("wireleap_relay_peer_dead_total", "Connections closed for a missed keepalive ping.", dead...)
+		m.counters("wireleap_relay_peer_dead_tunnels_total", "Tunnels ended by a dead peer.", tunnels...)
+	}
+
+	if t.admission != nil {
+		if s, ok := t.admission(); ok {
+			var shed []sample
+			for _, r := range admission.Reasons {
+				shed = append(shed, sample{"reason=" + strconv.Quote(r), float64(s.Shed[r])})
+			}
+
+			if s.OpenFiles >= 0 {
+				m.gauge("wireleap_relay_open_files", "Open file descriptors.", sample{value: float64(s.OpenFiles)})
+			}
+			m.gauge("wireleap_relay_open_files_limit", "Open file descriptors limit.", sample{value: float64(s.FileLimit)})
+			m.gauge("wireleap_relay_goroutines", "Goroutines.", sample{value: float64(s.Goroutines)})
+			m.gauge("wireleap_relay_heap_bytes", "Allocated heap.", sample{value: float64(s.Heap)})
+			m.gauge("wireleap_relay_accept_latency_seconds", "Average time from accepting a connection to serving it.", sample{value: s.AcceptLatency.Seconds()})
+			m.gauge("wireleap_relay_shedding", "Whether new tunnels are shed.", sample{value: boolValue(s.Shedding != "")})
+			m.counters("wireleap_relay_shed_tunnels_total", "Tunnels rejected by the admission control.", shed...)
+		}
+	}
+
+	if t.fairQueue != nil {
+		if s, ok := t.fairQueue(); ok {
+			var weights, clients, queued []sample
+			for _, cs := range s.Contracts {
+				l := contractLabel(cs.Contract)
+				weights = append(weights, sample{l, float64(cs.Weight)})
+				clients = append(clients, sample{l, float64(cs.Clients)})
+				queued = append(queued, sample{l, float64(cs.Queued)})
+			}
+
+			m.gauge("wireleap_relay_fair_queue_weight", "Bandwidth share of a scheduled contract.", weights...)
+			m.gauge("wireleap_relay_fair_queue_clients", "Scheduled clients of a contract.", clients...)
+			m.gauge("wireleap_relay_fair_queue_queued_bytes", "Data of a contract waiting for its turn.", queued...)
+			m.gauge("wireleap_relay_fair_queue_congested", "Whether the uplink is saturated and tunneled data scheduled.", sample{value: boolValue(s.Congested)})
+			m.gauge("wireleap_relay_fair_queue_rate_bytes", "Tunneled throughput per second at the last sample.", sample{value: float64(s.Rate)})
+			m.counter("wireleap_relay_fair_queue_waits_total", "Reads which waited for their turn while the uplink was saturated.", s.Waits)
+		}
+	}
+
+	if t.resolver != nil {
+		s := t.resolver()
+		var errs []sample
+		for _, k := range resolver.Kinds {
+			errs = append(errs, sample{"error=" + strconv.Quote(k), float64(s.Errors[k])})
+		}
+
+		m.counter("wireleap_relay_dns_queries_total", "Target hostname questions sent to the DNS servers.", s.Queries)
+		m.counter("wireleap_relay_dns_cache_hits_total", "Target hostname questions answered from the cache.", s.CacheHits)
+		m.gauge("wireleap_relay_dns_cache_entries", "Cached DNS answers.", sample{value: float64(s.CacheEntries)})
+		m.counters("wireleap_relay_dns_errors_total", "Failed target hostname resolutions and refused addresses.", errs...)
+	}
+
+	if t.blocklists != nil {
+		var domains, blocked []sample
+		for _, ls := range t.blocklists() {
+			l := "list=" + strconv.Quote(ls.Name)
+			domains = append(domains, sample{l, float64(ls.Domains)})
+			blocked = append(blocked, sample{l, float64(ls.Blocked)})
+		}
+
+		m.gauge("wireleap_relay_blocklist_domains", "Domains of a blocklist.", domains...)
+		m.counters("wireleap_relay_blocklist_blocked_total", "Target dials refused by a blocklist.", blocked...)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(m.Bytes())
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2022 Wireleap
+
+package restapi
+
+import "testing"
+
+func TestMetrics(t *testing.T) {
+	m := &metrics{}
+	m.gauge("relay_usage", "Usage.", sample{contractLabel("ct1"), 1024}, sample{contractLabel("ct2"), 0.5})
+	m.gauge("relay_global", "Global.", sample{value: 1e12})
+	m.counter("relay_dials_total", "Dials.", 3)
+	m.counters("relay_pings_total", "Pings.", sample{`peer="inbound"`, 7})
+
+	exp := `# HELP relay_usage Usage.
+# TYPE relay_usage gauge
+relay_usage{contract="ct1"} 1024
+relay_usage{contract="ct2"} 0.5
+# HELP relay_global Global.
+# TYPE relay_global gauge
+relay_global 1e+12
+# HELP relay_dials_total Dials.
+# TYPE relay_dials_total counter
+relay_dials_total 3
+# HELP relay_pings_total Pings.
+# TYPE relay_pings_total counter
+relay_pings_total{peer="inbound"} 7
+`
+
+	if s := m.String(); s != exp {
+		t.Fatalf("unexpected metrics:\n%s", s)
+	}
+}
@@ -3,40 +3,217 @@
 package restapi
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/wireleap/common/api/provide"
 	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/common/cli/fsdir"
+	"github.com/wireleap/relay/api/admission"
+	"github.com/wireleap/relay/api/blocklist"
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/api/fairqueue"
+	"github.com/wireleap/relay/api/h2ping"
+	"github.com/wireleap/relay/api/h2pool"
+	"github.com/wireleap/relay/api/resolver"
 	"github.com/wireleap/relay/contractmanager"
+	"github.com/wireleap/relay/filenames"
 	"github.com/wireleap/relay/relaycfg"
+	"github.com/wireleap/relay/relaylib"
+	"github.com/wireleap/relay/relaystats/nustore"
 )
 
 type T struct {
 	manager *contractmanager.Manager
+	fm      fsdir.T
 	l       *log.Logger
 	mux     *http.ServeMux
+	auth    bool
+	tokens  tokens
+	// https server TLS configuration
+	tc *tls.Config
+	// pooled connections to the next relays statistics
+	nextHops func() (h2pool.Stats, bool)
+	// keepalive statistics by peer type
+	peers func() map[string]h2ping.PeerStats
+	// admission control statistics
+	admission func() (admission.Stats, bool)
+	// bandwidth scheduler statistics
+	fairQueue func() (fairqueue.Stats, bool)
+	// target hostnames resolver statistics
+	resolver func() resolver.Stats
+	// domain blocklists statistics
+	blocklists func() []blocklist.ListStats
 }
 
 func (t *T) reply(w http.ResponseWriter, x interface{}) {
+	t.replyCode(w, http.StatusOK, x)
+}
+
+func (t *T) replyCode(w http.ResponseWriter, code int, x interface{}) {
 	b, err := json.Marshal(x)
 	if err != nil {
 		t.l.Printf("error %s while serving reply", err)
 		status.ErrInternal.WriteTo(w) //err to check
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(b) //err to check
 }
 
+// Maintenance mode change request
+type maintenanceRequest struct {
+	Enabled   bool     `json:"enabled"`
+	Contracts []string `json:"contracts,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+// readOnlyGate rejects requests that could modify the relay state.
+// Without authentication, write endpoints are only served through the
+// unix socket, which is protected by the socket file permissions.
+func readOnlyGate(targetMux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status.ErrForbidden.Wrap(status.Cause(
+				"write endpoints are only available through the unix socket",
+			)).WriteTo(w)
+			return
+		}
+		targetMux.ServeHTTP(w, r)
+	})
+}
+
+// replyErr maps manager errors to API errors
+func (t *T) replyErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, relaylib.ErrContractNotFound), errors.Is(err, contractmanager.ErrUnknownAction),
+		errors.Is(err, contractmanager.ErrNoArchive):
+		status.ErrNotFound.Wrap(err).WriteTo(w)
+	case errors.Is(err, relaylib.ErrNotStarted), errors.Is(err, relaylib.ErrContractMaintenance):
+		status.ErrConflict.Wrap(err).WriteTo(w)
+	default:
+		t.l.Printf("error %s while serving request", err)
+		status.ErrInternal.Wrap(err).WriteTo(w)
+	}
+}
+
+// contract serves /api/contracts/{id} and /api/contracts/{id}/{action}
+func (t *T) contract(w http.ResponseWriter, r *http.Request) {
+	ps := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/contracts/"), "/")
+
+	if len(ps) > 2 || ps[0] == "" {
+		status.ErrNotFound.WriteTo(w)
+		return
+	}
+
+	id := ps[0]
+
+	if len(ps) == 1 {
+		provide.MethodGate(provide.Routes{http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if o, ok := t.manager.ContractDetail(id); ok {
+				t.reply(w, o)
+			} else {
+				t.replyErr(w, fmt.Errorf("%w: %s", relaylib.ErrContractNotFound, id))
+			}
+		})}).ServeHTTP(w, r)
+		return
+	}
+
+	action := ps[1]
+
+	provide.MethodGate(provide.Routes{http.MethodPost: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := t.manager.ContractAction(id, action); err != nil {
+			t.replyErr(w, err)
+			return
+		}
+
+		o, _ := t.manager.ContractDetail(id)
+		t.reply(w, o)
+	})}).ServeHTTP(w, r)
+}
+
+// usageHistory serves /api/usage/history
+func (t *T) usageHistory(w http.ResponseWriter, r *http.Request) {
+	var (
+		v   = r.URL.Query()
+		q   = nustore.Query{Contract: v.Get("contract")}
+		err error
+	)
+
+	if s := v.Get("since"); s != "" {
+		q.Since, err = epoch.Parse(s)
+	}
+
+	if s := v.Get("until"); s != "" && err == nil {
+		q.Until, err = epoch.Parse(s)
+	}
+
+	if err != nil {
+		status.ErrRequest.Wrap(err).WriteTo(w)
+		return
+	}
+
+	h, err := t.manager.UsageHistory(q)
+	if err != nil {
+		t.replyErr(w, err)
+		return
+	}
+	t.reply(w, h)
+}
+
+// Configure loads the bearer tokens and the TLS settings of cfg, it has to
+// be called before Run.
+func (t *T) Configure(cfg relaycfg.RestApi) (err error) {
+	if cfg.Address == nil {
+		// Not defined, pass
+		return
+	}
+
+	if cfg.Auth {
+		if err = t.ReloadTokens(); err != nil {
+			return fmt.Errorf("could not load api tokens: %w", err)
+		}
+		t.auth = true
+	}
+
+	if cfg.Address.Scheme == "https" {
+		if t.tc, err = t.tlsConfig(cfg.TLS); err != nil {
+			return fmt.Errorf("could not configure api tls: %w", err)
+		}
+	}
+	return
+}
+
 func (t *T) Run(cfg relaycfg.RestApi) {
 	if cfg.Address == nil {
 		// Not defined, pass
-	} else if cfg.Address.Scheme == "http" {
+		return
+	}
+
+	if cfg.Address.Scheme == "http" {
 		log.Printf("Launching HTTP Server: %s\n", cfg.Address.Host)
-		if err := t.TCPServer(cfg.Address.Host); err != nil {
+		if err := t.TCPServer(cfg.Address.Host, nil); err != nil {
+			log.Print(err)
+		}
+	} else if cfg.Address.Scheme == "https" {
+		if t.tc == nil {
+			log.Print("api tls is not configured, not launching HTTPS Server")
+			return
+		}
+
+		log.Printf("Launching HTTPS Server: %s\n", cfg.Address.Host)
+		if err := t.TCPServer(cfg.Address.Host, t.tc); err != nil {
 			log.Print(err)
 		}
 	} else if cfg.Address.Scheme == "file" {
@@ -49,6 +226,121 @@ func (t *T) Run(cfg relaycfg.RestApi) {
 	}
 }
 
+// SetNextHops sets the source of the next relays pool statistics, shown
+// in the metrics
+func (t *T) SetNextHops(fn func() (h2pool.Stats, bool)) {
+	t.nextHops = fn
+}
+
+// SetPeers sets the source of the keepalive statistics, shown in the
+// metrics
+func (t *T) SetPeers(fn func() map[string]h2ping.PeerStats) {
+	t.peers = fn
+}
+
+// SetAdmission sets the source of the admission control statistics, shown
+// in the metrics
+func (t *T) SetAdmission(fn func() (admission.Stats, bool)) {
+	t.admission = fn
+}
+
+// SetFairQueue sets the source of the bandwidth scheduler statistics, shown
+// in the metrics
+func (t *T) SetFairQueue(fn func() (fairqueue.Stats, bool)) {
+	t.fairQueue = fn
+}
+
+// SetResolver sets the source of the resolver statistics, shown in the
+// metrics
+func (t *T) SetResolver(fn func() resolver.Stats) {
+	t.resolver = fn
+}
+
+// SetBlocklists sets the source of the domain blocklists statistics, shown
+// in the metrics
+func (t *T) SetBlocklists(fn func() []blocklist.ListStats) {
+	t.blocklists = fn
+}
+
+// ReloadTokens reloads the bearer tokens file
+func (t *T) ReloadTokens() error {
+	l, err := LoadTokens(t.fm)
+	if err != nil {
+		return err
+	}
+
+	if len(l) == 0 {
+		log.Printf("api auth is enabled but %s holds no tokens, all requests will be refused", t.fm.Path(filenames.ApiTokens))
+	}
+
+	t.tokens.set(l)
+	return nil
+}
+
+// Returns the path of a file relative to the relay home
+func (t *T) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return t.fm.Path(p)
+}
+
+// Build the https server TLS configuration
+func (t *T) tlsConfig(cfg *relaycfg.RestApiTLS) (tc *tls.Config, err error) {
+	if cfg == nil {
+		cfg = &relaycfg.RestApiTLS{}
+	}
+
+	certFile, keyFile := t.fm.Path(filenames.TLSCert), t.fm.Path(filenames.TLSKey)
+	if cfg.CertFile != "" {
+		certFile, keyFile = t.path(cfg.CertFile), t.path(cfg.KeyFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return
+	}
+
+	tc = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCA != "" {
+		var b []byte
+		if b, err = ioutil.ReadFile(t.path(cfg.ClientCA)); err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCA)
+		}
+
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
+}
+
+// Returns the handler for a listener
+// TCP listeners are read-only unless authentication is enabled,
+// health probes are always served without authentication.
+func (t *T) handler(tcp bool) http.Handler {
+	var h http.Handler = t.mux
+	if t.auth {
+		h = t.tokens.authGate(t.mux)
+	} else if tcp {
+		h = readOnlyGate(t.mux)
+	}
+
+	pm := http.NewServeMux()
+	pm.Handle("/healthz", t.mux)
+	pm.Handle("/readyz", t.mux)
+	pm.Handle("/", h)
+	return pm
+}
+
 func (t *T) UnixServer(path string, fm os.FileMode) error {
 	if err := os.RemoveAll(path); err != nil {
 		return err
@@ -64,30 +356,82 @@ func (t *T) UnixServer(path string, fm os.FileMode) error {
 		return err
 	}
 
-	h := &http.Server{Handler: t.mux}
+	h := &http.Server{Handler: t.handler(false)}
 	return h.Serve(l)
 }
 
-func (t *T) TCPServer(addr string) error {
+func (t *T) TCPServer(addr string, tc *tls.Config) error {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
-	h := &http.Server{Handler: t.mux}
+	if tc != nil {
+		l = tls.NewListener(l, tc)
+	}
+
+	h := &http.Server{Handler: t.handler(true)}
 	return h.Serve(l)
 }
 
-func New(manager *contractmanager.Manager) (t *T) {
+func New(manager *contractmanager.Manager, fm fsdir.T) (t *T) {
 	t = &T{
 		manager: manager,
+		fm:      fm,
 		l:       log.Default(),
 		mux:     http.NewServeMux(),
 	}
 
+	t.mux.Handle("/healthz", t.probe(t.manager.Liveness))
+	t.mux.Handle("/readyz", t.probe(t.manager.Readiness))
+
 	t.mux.Handle("/api/status", provide.MethodGate(provide.Routes{http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		o := t.manager.Status()
 		t.reply(w, o)
 	})}))
+
+	t.mux.Handle("/api/maintenance", provide.MethodGate(provide.Routes{
+		http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			o := t.manager.MaintenanceStatus()
+			t.reply(w, o)
+		}),
+		http.MethodPost: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req maintenanceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				status.ErrRequest.Wrap(err).WriteTo(w)
+				return
+			}
+
+			o, err := t.manager.SetMaintenance(req.Enabled, req.Contracts, req.Reason)
+			if err != nil {
+				t.replyErr(w, err)
+				return
+			}
+			t.reply(w, o)
+		}),
+	}))
+
+	t.mux.Handle("/api/contracts", provide.MethodGate(provide.Routes{http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.reply(w, t.manager.ContractDetails())
+	})}))
+	t.mux.Handle("/api/contracts/", http.HandlerFunc(t.contract))
+
+	t.mux.Handle("/api/events", provide.MethodGate(provide.Routes{http.MethodGet: http.HandlerFunc(t.events)}))
+	t.mux.Handle("/api/metrics", provide.MethodGate(provide.Routes{http.MethodGet: http.HandlerFunc(t.metrics)}))
+	t.mux.Handle("/api/usage/history", provide.MethodGate(provide.Routes{http.MethodGet: http.HandlerFunc(t.usageHistory)}))
 	return
 }
+
+// probe serves a health check, failed checks reply 503
+func (t *T) probe(check func() contractmanager.HealthStatus) http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hs := check()
+
+		code := http.StatusOK
+		if !hs.Ok {
+			code = http.StatusServiceUnavailable
+		}
+		t.replyCode(w, code, hs)
+	})
+	return provide.MethodGate(provide.Routes{http.MethodGet: h, http.MethodHead: h})
+}
@@ -9,25 +9,31 @@ import (
 	"time"
 
 	"github.com/wireleap/common/api/sharetoken"
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/api/events"
 )
 
 type T struct {
 	scheduled map[int64][]*sharetoken.T
 	mu        sync.Mutex
 	tt        *time.Ticker
+	ev        *events.Bus
 }
 
-func New(dur time.Duration, submit func(*sharetoken.T) error) (t *T) {
+// New starts a scheduler submitting sharetokens every dur, submission
+// results are emitted to ev which can be nil
+func New(dur time.Duration, submit func(*sharetoken.T) error, ev *events.Bus) (t *T) {
 	t = &T{
 		tt:        time.NewTicker(dur),
 		scheduled: map[int64][]*sharetoken.T{},
+		ev:        ev,
 	}
 
 	// regular submission thread
 	go func() {
 		for range t.tt.C {
 			t.mu.Lock()
-			n := 0
+			n := map[string]int{}
 			now := time.Now()
 			for t0, sts := range t.scheduled {
 				if t0 <= now.Unix() {
@@ -35,6 +41,10 @@ func New(dur time.Duration, submit func(*sharetoken.T) error) (t *T) {
 						if err := submit(st); err != nil {
 							ntime := now.Add(dur)
 							blurb := ""
+							data := map[string]interface{}{
+								"signature": st.Signature.String(),
+								"error":     err.Error(),
+							}
 
 							if st.IsExpiredAt(ntime.Unix()) {
 								// next attempt will fail
@@ -43,8 +53,11 @@ func New(dur time.Duration, submit func(*sharetoken.T) error) (t *T) {
 								// try again later
 								t.scheduled[ntime.Unix()] = append(t.scheduled[ntime.Unix()], st)
 								blurb = fmt.Sprintf("next submission attempt at %s", ntime)
+								data["next_attempt"] = epoch.ToEpochMillis(ntime)
 							}
 
+							t.ev.Emit(events.STSubmitFailed, st.Contract.PublicKey.String(), data)
+
 							log.Printf(
 								"could not submit sharetoken (sig=%s): %s, %s",
 								st.Signature,
@@ -52,7 +65,7 @@ func New(dur time.Duration, submit func(*sharetoken.T) error) (t *T) {
 								blurb,
 							)
 						} else {
-							n++
+							n[st.Contract.PublicKey.String()]++
 						}
 					}
 					// submission of all sts complete or postponed, clean up
@@ -60,6 +73,10 @@ func New(dur time.Duration, submit func(*sharetoken.T) error) (t *T) {
 				}
 			}
 			t.mu.Unlock()
+
+			for ct, c := range n {
+				t.ev.Emit(events.STSubmitted, ct, map[string]int{"count": c})
+			}
 		}
 	}()
 	log.Printf(
@@ -27,5 +27,7 @@ var Cmd = &cli.Subcmd{
 			os.Exit(1)
 		}
 		fmt.Println("OK")
+		fmt.Println("tls.server:", c.TLS.Server)
+		fmt.Println("tls.client:", c.TLS.Client)
 	},
 }
@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Wireleap
+
+package maintenancecmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/wireleap/common/cli"
+	"github.com/wireleap/common/cli/fsdir"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaycfg"
+	"github.com/wireleap/relay/restapi"
+)
+
+// contractList is a repeatable string flag
+type contractList []string
+
+func (l *contractList) String() string { return strings.Join(*l, ",") }
+
+func (l *contractList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func Cmd() *cli.Subcmd {
+	var (
+		fs        = flag.NewFlagSet("maintenance", flag.ExitOnError)
+		reason    = fs.String("reason", "", "Reason for entering maintenance mode")
+		contracts contractList
+	)
+
+	fs.Var(&contracts, "contract", "Service contract URL or id, can be repeated (default: all)")
+
+	run := func(fm fsdir.T) {
+		c := relaycfg.Defaults()
+		err := fm.Get(&c, filenames.Config)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cl, err := restapi.NewClient(c.RestApi, fm)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// allow options after the action
+		action := fs.Arg(0)
+		if fs.NArg() > 1 {
+			if err = fs.Parse(fs.Args()[1:]); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		var res json.RawMessage
+
+		switch action {
+		case "on", "off":
+			req := map[string]interface{}{
+				"enabled":   action == "on",
+				"contracts": contracts,
+				"reason":    *reason,
+			}
+			err = cl.Do(http.MethodPost, "/api/maintenance", req, &res)
+		case "status":
+			err = cl.Do(http.MethodGet, "/api/maintenance", nil, &res)
+		default:
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		if err != nil {
+			log.Fatalf("could not reach the relay API REST: %s", err)
+		}
+
+		data, err := json.MarshalIndent(res, "", "    ")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(data))
+	}
+
+	r := &cli.Subcmd{
+		FlagSet: fs,
+		Desc:    "Control wireleap-relay maintenance mode",
+		Run:     run,
+		Sections: []cli.Section{{
+			Title: "Actions",
+			Entries: []cli.Entry{
+				{Key: "on", Value: "Disenroll and refuse new connections"},
+				{Key: "off", Value: "Leave maintenance mode and reenroll"},
+				{Key: "status", Value: "Show maintenance mode status"},
+			},
+		}},
+	}
+
+	return r
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -24,6 +25,11 @@ import (
 	"github.com/wireleap/common/cli/fsdir"
 	"github.com/wireleap/common/ststore"
 	"github.com/wireleap/common/wlnet/transport"
+	"github.com/wireleap/relay/api/admission"
+	"github.com/wireleap/relay/api/blocklist"
+	"github.com/wireleap/relay/api/fairqueue"
+	"github.com/wireleap/relay/api/resolver"
+	"github.com/wireleap/relay/api/sdnotify"
 	"github.com/wireleap/relay/contractmanager"
 	"github.com/wireleap/relay/filenames"
 	"github.com/wireleap/relay/relaycfg"
@@ -74,6 +80,8 @@ func serverun(fm fsdir.T) {
 		log.Fatal(err)
 	}
 
+	manager.PendingST = func(contractId string) int { return len(sts.Filter(contractId, "")) }
+
 	scs := manager.Controller.SCS()
 
 	creds, err := tls.LoadX509KeyPair(
@@ -137,7 +145,7 @@ func serverun(fm fsdir.T) {
 				)
 			}
 			return nil
-		})
+		}, manager.Events)
 
 		// schedule tokens in store for submission on startup
 		for _, st := range sts.Filter("", "") {
@@ -186,13 +194,76 @@ func serverun(fm fsdir.T) {
 		Timeout:   time.Duration(c.Timeout),
 	})
 
+	// the listener policy is applied to its own copy in ListenAndServeHTTP
+	if err = c.TLS.Client.Apply(n.Transport.TLSClientConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	var dnsServers []resolver.Server
+	for _, s := range c.Resolver.Servers {
+		ds, err := resolver.ParseServer(s)
+		if err != nil {
+			log.Fatalf("could not parse resolver server: %s", err)
+		}
+		dnsServers = append(dnsServers, ds)
+	}
+
+	bl, err := blocklist.New(blocklistFiles(fm, c.Blocklists), time.Duration(c.Blocklists.CheckInterval))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	r := relay.New(n, manager, relay.Options{
 		MaxTime:       time.Duration(c.MaxTime),
 		BufSize:       c.BufSize,
+		FlushDelay:    time.Duration(c.FlushDelay),
 		HandleST:      handleST,
 		ErrorOrigin:   jsonb.PK(pk).String(),
 		AllowLoopback: c.DangerZone.AllowLoopback,
+		Server: relay.ServerOptions{
+			MaxConcurrentStreams: c.HTTP2.MaxConcurrentStreams,
+			ConnectionWindow:     int32(c.HTTP2.ConnectionWindow),
+			StreamWindow:         int32(c.HTTP2.StreamWindow),
+			ReadHeaderTimeout:    time.Duration(c.HTTP2.ReadHeaderTimeout),
+			WriteTimeout:         time.Duration(c.HTTP2.WriteTimeout),
+			IdleTimeout:          time.Duration(c.HTTP2.IdleTimeout),
+			MaxHeaderBytes:       int(c.HTTP2.MaxHeaderBytes),
+			PingInterval:         time.Duration(c.HTTP2.PingInterval),
+			PingTimeout:          time.Duration(c.HTTP2.PingTimeout),
+			TLS:                  c.TLS.Server,
+		},
+		NextHops: relay.NextHopOptions{
+			Size:        c.NextHops.PoolSize,
+			IdleTimeout: time.Duration(c.NextHops.IdleTimeout),
+			HealthCheck: time.Duration(c.NextHops.HealthCheckInterval),
+			PingTimeout: time.Duration(c.HTTP2.PingTimeout),
+		},
+		Admission: admission.Options{
+			MaxOpenFiles:     c.Admission.MaxOpenFiles,
+			MaxGoroutines:    c.Admission.MaxGoroutines,
+			MaxHeap:          uint64(c.Admission.MaxHeap),
+			MaxAcceptLatency: time.Duration(c.Admission.MaxAcceptLatency),
+			Interval:         time.Duration(c.Admission.Interval),
+			Events:           manager.Events,
+		},
+		FairQueue: fairqueue.Options{
+			Interval: time.Duration(c.FairQueue.Interval),
+			Stall:    time.Duration(c.FairQueue.Stall),
+		},
+		Resolver: resolver.Options{
+			Servers:       dnsServers,
+			Timeout:       time.Duration(c.Resolver.Timeout),
+			Prefer:        c.Resolver.Prefer,
+			FallbackDelay: time.Duration(c.Resolver.FallbackDelay),
+			CacheSize:     c.Resolver.CacheSize,
+			MinTTL:        time.Duration(c.Resolver.MinTTL),
+			MaxTTL:        time.Duration(c.Resolver.MaxTTL),
+			NegativeTTL:   time.Duration(c.Resolver.NegativeTTL),
+			DialTimeout:   time.Duration(c.Timeout),
+		},
+		Blocklist: bl,
 	})
+	r.SetFairQueueWeights(manager.Controller.FairQueueWeights())
 
 	// wireleap:// HTTP/2 server
 	err = r.ListenAndServeHTTP(*c.Address)
@@ -201,6 +272,18 @@ func serverun(fm fsdir.T) {
 	}
 	log.Printf("Listening for H/2 requests on https://%s", *c.Address)
 
+	// API REST, configured before enrolling as its errors are fatal
+	api := restapi.New(r.Manager, fm)
+	api.SetNextHops(r.NextHops)
+	api.SetPeers(r.Peers)
+	api.SetAdmission(r.Admission)
+	api.SetFairQueue(r.FairQueue)
+	api.SetResolver(r.Resolver)
+	api.SetBlocklists(bl.Stats)
+	if err = api.Configure(c.RestApi); err != nil {
+		log.Fatal(err)
+	}
+
 	// finalizer
 	if err := r.Manager.Start(); err != nil {
 		// finalizer is valid and needs to run even if there was an error
@@ -210,6 +293,7 @@ func serverun(fm fsdir.T) {
 
 	shutdown := func() bool {
 		log.Print("gracefully shutting down...")
+		sdnotify.Notify(sdnotify.Stopping)
 		r.Manager.Stop()
 
 		fm.Del(filenames.Pid)
@@ -227,9 +311,27 @@ func serverun(fm fsdir.T) {
 	defer shutdown()
 
 	// Launch API REST goroutine
-	api := restapi.New(r.Manager)
 	go api.Run(c.RestApi)
 
+	// notify systemd if supervised
+	if _, err = sdnotify.Notify(sdnotify.Ready); err != nil {
+		log.Printf("could not notify readiness to systemd: %s", err)
+	}
+
+	if d, ok := sdnotify.WatchdogInterval(); ok {
+		log.Printf("systemd watchdog enabled, notifying every %s", d/2)
+
+		go func() {
+			for range time.Tick(d / 2) {
+				if hs := r.Manager.Liveness(); !hs.Ok {
+					log.Printf("relay is not healthy, skipping watchdog notification")
+				} else if _, err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+					log.Printf("could not notify watchdog to systemd: %s", err)
+				}
+			}
+		}()
+	}
+
 	// check limit on open files (includes tcp connections)
 	var rlim syscall.Rlimit
 	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err == nil {
@@ -263,6 +365,18 @@ func serverun(fm fsdir.T) {
 				log.Printf("could not validate config file: %s", err)
 			} else if err = r.Manager.ReloadCfg(&c); err != nil {
 				log.Printf("could not reload relay config: %s", err)
+			} else {
+				r.SetFairQueueWeights(r.Manager.Controller.FairQueueWeights())
+
+				if err = bl.Set(blocklistFiles(fm, c.Blocklists)); err != nil {
+					log.Printf("%s, keeping old list...", err)
+				}
+			}
+
+			if c.RestApi.Auth {
+				if err = api.ReloadTokens(); err != nil {
+					log.Printf("could not reload api tokens: %s, keeping old tokens...", err)
+				}
 			}
 
 			return
@@ -278,3 +392,16 @@ func serverun(fm fsdir.T) {
 		syscall.SIGQUIT: shutdown,
 	})
 }
+
+// Returns the blocklist files by list name, relative paths being resolved
+// from the relay home
+func blocklistFiles(fm fsdir.T, b relaycfg.Blocklists) map[string]string {
+	files := make(map[string]string, len(b.Files))
+	for name, p := range b.Files {
+		if !filepath.IsAbs(p) {
+			p = fm.Path(p)
+		}
+		files[name] = p
+	}
+	return files
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2022 Wireleap
+
+package statscmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wireleap/common/cli"
+	"github.com/wireleap/common/cli/fsdir"
+	"github.com/wireleap/relay/api/epoch"
+	"github.com/wireleap/relay/filenames"
+	"github.com/wireleap/relay/relaycfg"
+	"github.com/wireleap/relay/relaystats/nustore"
+
+	"github.com/c2h5oh/datasize"
+)
+
+func Cmd() *cli.Subcmd {
+	var (
+		fs       = flag.NewFlagSet("stats", flag.ExitOnError)
+		since    = fs.String("since", "", "Skip periods ended before this time (epoch millis, RFC 3339 or YYYY-MM-DD)")
+		until    = fs.String("until", "", "Skip periods started after this time (epoch millis, RFC 3339 or YYYY-MM-DD)")
+		contract = fs.String("contract", "", "Show a single contract id")
+		format   = fs.String("format", "table", "Output format: json, csv or table (default: table)")
+		total    = fs.Bool("total", false, "Show the usage aggregated over the periods")
+	)
+
+	run := func(fm fsdir.T) {
+		c := relaycfg.Defaults()
+		err := fm.Get(&c, filenames.Config)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// allow options after the action
+		action := fs.Arg(0)
+		if fs.NArg() > 1 {
+			if err = fs.Parse(fs.Args()[1:]); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if action != "history" {
+			fs.Usage()
+			os.Exit(1)
+		} else if c.NetUsage.ArchiveDir == nil {
+			log.Fatal("network_usage.archive_dir is not set")
+		}
+
+		q := nustore.Query{Contract: *contract}
+		if *since != "" {
+			if q.Since, err = epoch.Parse(*since); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *until != "" {
+			if q.Until, err = epoch.Parse(*until); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		archive, err := nustore.New(fm.Path(*c.NetUsage.ArchiveDir))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		h, err := archive.History(q)
+		if err != nil {
+			log.Fatalf("could not read network usage archive: %s", err)
+		}
+
+		switch *format {
+		case "json":
+			var x interface{} = h
+			if *total {
+				x = h.Usage
+			}
+
+			data, err := json.MarshalIndent(x, "", "    ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(data))
+		case "csv":
+			err = writeCSV(os.Stdout, h, *total)
+		case "table":
+			err = writeTable(os.Stdout, h, *total)
+		default:
+			log.Fatalf("unknown format %q", *format)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	r := &cli.Subcmd{
+		FlagSet: fs,
+		Desc:    "Show archived network usage statistics",
+		Run:     run,
+		Sections: []cli.Section{{
+			Title: "Actions",
+			Entries: []cli.Entry{
+				{Key: "history", Value: "Show the network usage of the archived periods"},
+			},
+		}},
+	}
+
+	return r
+}
+
+// Usage of a contract over a period
+type row struct {
+	startAt, endAt int64
+	contract       string
+	usage          uint64
+}
+
+// Returns the usage rows, one per contract and period or one per contract
+func rows(h nustore.History, total bool) (rs []row) {
+	if total {
+		for _, cu := range h.Usage.Contracts {
+			rs = append(rs, row{h.Usage.StartAt, h.Usage.EndAt, cu.Contract, cu.NetUsage})
+		}
+		return
+	}
+
+	for _, ns := range h.Periods {
+		for _, cm := range ns.Metrics {
+			rs = append(rs, row{ns.StartAt, ns.EndAt, cm.Contract, cm.NetUsage})
+		}
+	}
+	return
+}
+
+func formatTime(t int64) string {
+	return epoch.FromEpochMillis(t).UTC().Format(time.RFC3339)
+}
+
+func writeCSV(w io.Writer, h nustore.History, total bool) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"start_at", "end_at", "contract", "network_usage_bytes"})
+
+	for _, r := range rows(h, total) {
+		cw.Write([]string{formatTime(r.startAt), formatTime(r.endAt), r.contract, strconv.FormatUint(r.usage, 10)})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTable(w io.Writer, h nustore.History, total bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tEND\tCONTRACT\tUSAGE")
+
+	rs := rows(h, total)
+	if !total && h.Usage.Periods > 0 {
+		rs = append(rs, row{h.Usage.StartAt, h.Usage.EndAt, "TOTAL", h.Usage.NetUsage})
+	}
+
+	for _, r := range rs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", formatTime(r.startAt), formatTime(r.endAt), r.contract, datasize.ByteSize(r.usage).HR())
+	}
+	return tw.Flush()
+}
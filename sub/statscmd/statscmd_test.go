@@ -0,0 +1,34 @@
+// Copyright (c) 2022 Wireleap
+
+package statscmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wireleap/relay/relaystats/nustore"
+)
+
+func TestWrite(t *testing.T) {
+	ps := []nustore.NetStats{
+		nustore.NewArchiveFile("relay-id", nil, map[string]uint64{"ct1": 100}, nil, 0, 86400000),
+		nustore.NewArchiveFile("relay-id", nil, map[string]uint64{"ct1": 2048}, nil, 86400000, 172800000),
+	}
+	h := nustore.History{Periods: ps, Usage: nustore.Aggregate(ps)}
+
+	var b bytes.Buffer
+	if err := writeCSV(&b, h, true); err != nil {
+		t.Fatal(err)
+	} else if s := b.String(); s != "start_at,end_at,contract,network_usage_bytes\n1970-01-01T00:00:00Z,1970-01-03T00:00:00Z,ct1,2148\n" {
+		t.Fatalf("wrong csv %q", s)
+	}
+
+	b.Reset()
+	if err := writeTable(&b, h, false); err != nil {
+		t.Fatal(err)
+	} else if n := bytes.Count(b.Bytes(), []byte("\n")); n != 4 {
+		t.Fatalf("wrong table rows %d:\n%s", n, b.String())
+	} else if !bytes.Contains(b.Bytes(), []byte("TOTAL     2.1 KB")) {
+		t.Fatalf("wrong table total:\n%s", b.String())
+	}
+}
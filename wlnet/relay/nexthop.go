@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Wireleap
+
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wireleap/common/api/interfaces/clientrelay"
+	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/common/api/texturl"
+	"github.com/wireleap/common/wlnet"
+	"github.com/wireleap/common/wlnet/h2conn"
+	"github.com/wireleap/relay/api/h2ping"
+	"github.com/wireleap/relay/api/h2pool"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// FeaturesHeader lists the relay features in PING replies.
+	FeaturesHeader = "wl-features"
+	// Relays accepting connections forwarded over pooled streams
+	featureForward = "forward"
+	// Command forwarding a connection to the relay listener
+	cmdForward = "FORWARD"
+	// Time allowed to the TLS handshake of a forwarded connection
+	forwardHandshakeTimeout = 10 * time.Second
+)
+
+// NextHopOptions configure the pooled connections to the next relays.
+type NextHopOptions struct {
+	// Size is the maximum number of connections per next relay, 0 disables
+	// the pool.
+	Size int
+	// IdleTimeout closes connections without tunnels.
+	IdleTimeout time.Duration
+	// HealthCheck is the interval of the connections health checks.
+	HealthCheck time.Duration
+	// PingTimeout closes the connections not answering a health check.
+	PingTimeout time.Duration
+}
+
+func (t *T) newPool(o NextHopOptions) *h2pool.T {
+	return h2pool.New(&http2.Transport{TLSClientConfig: t.Transport.TLSClientConfig}, h2pool.Options{
+		Size:        o.Size,
+		IdleTimeout: o.IdleTimeout,
+		HealthCheck: o.HealthCheck,
+		PingTimeout: o.PingTimeout,
+		Recorder:    t.peers,
+		Dial:        t.Transport.DialTLSContext,
+		Probe:       probe,
+	})
+}
+
+// Returns the next relay URL as known by the pool
+func nextHopURL(remote *texturl.URL) string {
+	u := remote.URL
+	u.Scheme = "https"
+	return u.String()
+}
+
+// Checks with a PING that the next relay accepts forwarded connections
+func probe(ctx context.Context, cc *http2.ClientConn, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, nil)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range (&wlnet.Init{Command: "PING", Version: &clientrelay.T.Version}).Headers() {
+		req.Header.Set(k, v)
+	}
+
+	res, err := cc.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.Header.Get(FeaturesHeader) != featureForward {
+		return h2pool.ErrUnsupported
+	}
+	return nil
+}
+
+// Dials the next hop, over a pooled connection to the next relay if possible,
+// targets through the resolver
+func (t *T) dial(ctx context.Context, p *wlnet.Init) (net.Conn, error) {
+	if t.nextHops != nil && p.Remote.Scheme == "wireleap" && p.Protocol == "tcp" {
+		target := nextHopURL(p.Remote)
+		cc, err := t.nextHops.Get(ctx, target)
+
+		if err == nil {
+			var c *h2conn.T
+			init := &wlnet.Init{Command: cmdForward, Version: &clientrelay.T.Version}
+			if c, err = h2conn.New(cc, target, init.Headers()); err == nil {
+				return &pooledConn{T: c, cc: cc}, nil
+			}
+			t.nextHops.Release(cc)
+		}
+
+		if !errors.Is(err, h2pool.ErrFull) && !errors.Is(err, h2pool.ErrUnsupported) {
+			log.Printf("could not use a pooled connection to the next relay: %s", err)
+		}
+		t.nextHops.Fallback()
+	}
+
+	if p.Remote.Scheme == "target" {
+		return t.resolver.Dial(ctx, p.Protocol, p.Remote.Host)
+	}
+	return t.T.Transport.DialContext(ctx, p.Protocol, p.Remote.Host)
+}
+
+// NextHops returns the pooled connections statistics, if enabled
+func (t *T) NextHops() (h2pool.Stats, bool) {
+	if t.nextHops == nil {
+		return h2pool.Stats{}, false
+	}
+	return t.nextHops.Stats(), true
+}
+
+// pooledConn is a tunnel opened over a pooled connection
+type pooledConn struct {
+	*h2conn.T
+	cc *http2.ClientConn
+}
+
+// Forwarded connection address
+type addr string
+
+func (a addr) Network() string { return "tcp" }
+func (a addr) String() string  { return string(a) }
+
+// forwardConn is a connection forwarded by the previous relay over a
+// stream, deadlines are not supported
+type forwardConn struct {
+	*stream
+	remote addr
+}
+
+func (c *forwardConn) LocalAddr() net.Addr                { return addr("") }
+func (c *forwardConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *forwardConn) SetDeadline(t time.Time) error      { return nil }
+func (c *forwardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *forwardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Serves a connection forwarded by the previous relay as if it had been
+// accepted by the listener, it carries the client TLS session to this relay
+func (t *T) serveForward(c *stream, h http.Header, r *http.Request) {
+	if t.srv == nil {
+		(&status.T{
+			Code:   http.StatusBadRequest,
+			Desc:   "forwarded connections are not supported",
+			Origin: t.ErrorOrigin,
+		}).ToHeader(h)
+		return
+	}
+
+	// connections are forwarded once, by the previous relay
+	if pc := h2ping.FromContext(r.Context()); pc != nil && pc.Peer() == h2ping.PeerForwarded {
+		(&status.T{
+			Code:   http.StatusBadRequest,
+			Desc:   "connection already forwarded",
+			Origin: t.ErrorOrigin,
+		}).ToHeader(h)
+		return
+	}
+
+	tc := tls.Server(&forwardConn{stream: c, remote: addr(r.RemoteAddr)}, t.srv.TLSConfig)
+	tm := time.AfterFunc(forwardHandshakeTimeout, func() { tc.Close() })
+	err := tc.Handshake()
+	tm.Stop()
+
+	if err != nil {
+		log.Printf("forwarded connection TLS handshake error: %s", err)
+		return
+	}
+
+	t.serveConn(r.Context(), tc, h2ping.PeerForwarded, t)
+}
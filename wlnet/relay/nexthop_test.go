@@ -0,0 +1,223 @@
+// Copyright (c) 2022 Wireleap
+
+package relay
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wireleap/common/api/interfaces/clientrelay"
+	"github.com/wireleap/common/api/servicekey"
+	"github.com/wireleap/common/api/sharetoken"
+	"github.com/wireleap/common/api/signer"
+	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/common/api/texturl"
+	"github.com/wireleap/common/wlnet"
+	"github.com/wireleap/common/wlnet/transport"
+	"github.com/wireleap/relay/api/admission"
+	"github.com/wireleap/relay/api/h2ping"
+	"github.com/wireleap/relay/contractmanager"
+)
+
+func newCert(t *testing.T) tls.Certificate {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func newRelay(t *testing.T, o Options) string {
+	tt := transport.New(transport.Options{Certs: []tls.Certificate{newCert(t)}, Timeout: 5 * time.Second})
+	o.BufSize, o.AllowLoopback = 2048, true
+
+	rl := New(tt, contractmanager.NewDummyManager(), o)
+	addr := freeAddr(t)
+	if err := rl.ListenAndServeHTTP(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if rl.nextHops != nil {
+		t.Cleanup(rl.nextHops.Close)
+	}
+	return addr
+}
+
+func newToken(t *testing.T) *sharetoken.T {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sk := servicekey.New(priv)
+	sk.Contract.SettlementOpen = time.Now().Add(time.Minute).Unix()
+	sk.Contract.SettlementClose = time.Now().Add(2 * time.Minute).Unix()
+	sk.Contract.Sign(signer.New(priv))
+
+	st, err := sharetoken.New(sk, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// Starts an echo target, returns its address
+func newEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func newInit(st *sharetoken.T, remote string) *wlnet.Init {
+	return &wlnet.Init{
+		Command:  "CONNECT",
+		Protocol: "tcp",
+		Remote:   texturl.URLMustParse(remote),
+		Token:    st,
+		Version:  &clientrelay.T.Version,
+	}
+}
+
+// Writes p and checks that it is echoed back
+func echo(t *testing.T, c net.Conn, p []byte) {
+	if _, err := c.Write(p); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, len(p))
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	} else if string(b) != string(p) {
+		t.Fatalf("corrupted echo %q", b)
+	}
+}
+
+func TestNextHopPool(t *testing.T) {
+	st, target := newToken(t), newEcho(t)
+
+	tt1 := transport.New(transport.Options{Certs: []tls.Certificate{newCert(t)}, Timeout: 5 * time.Second})
+	rl1 := New(tt1, contractmanager.NewDummyManager(), Options{
+		BufSize:       2048,
+		AllowLoopback: true,
+		NextHops:      NextHopOptions{Size: 1, IdleTimeout: time.Minute},
+	})
+	defer rl1.nextHops.Close()
+
+	addr1 := freeAddr(t)
+	if err := rl1.ListenAndServeHTTP(addr1); err != nil {
+		t.Fatal(err)
+	}
+	addr2 := newRelay(t, Options{})
+
+	client := transport.New(transport.Options{Timeout: 5 * time.Second})
+
+	tunnel := func() {
+		u1, u2 := texturl.URLMustParse("wireleap://"+addr1), texturl.URLMustParse("wireleap://"+addr2)
+
+		c1, err := client.DialWL(nil, "tcp", &u1.URL, newInit(st, u2.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c1.Close()
+
+		c2, err := client.DialWL(c1, "tcp", &u2.URL, newInit(st, "target://"+target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Close()
+
+		echo(t, c2, []byte("hello!"))
+	}
+
+	tunnel()
+	tunnel()
+
+	s, ok := rl1.NextHops()
+	if !ok {
+		t.Fatal("next hops pool should be enabled")
+	} else if s.Dials != 1 || s.Streams != 2 || s.Fallbacks != 0 {
+		t.Fatalf("tunnels should share a pooled connection %+v", s)
+	}
+}
+
+func TestForwardRefused(t *testing.T) {
+	forward := func(rl *T, ctx context.Context) (res status.T) {
+		r := httptest.NewRequest(http.MethodPut, "/", http.NoBody).WithContext(ctx)
+		for k, v := range (&wlnet.Init{Command: cmdForward, Version: &clientrelay.T.Version}).Headers() {
+			r.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		rl.ServeHTTP(rw, r)
+
+		if err := json.Unmarshal([]byte(rw.Header().Get(status.Header)), &res); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	tt := transport.New(transport.Options{Certs: []tls.Certificate{newCert(t)}, Timeout: 5 * time.Second})
+	rl := New(tt, contractmanager.NewDummyManager(), Options{BufSize: 2048, AllowLoopback: true})
+	if err := rl.ListenAndServeHTTP(freeAddr(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// connections are forwarded once
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	pc := h2ping.New(tls.Server(c1, &tls.Config{}), h2ping.PeerForwarded, h2ping.Options{})
+	if res := forward(rl, h2ping.NewContext(context.Background(), pc)); res.Code != http.StatusBadRequest {
+		t.Fatalf("forwarded connection should not be forwarded again, got %+v", res)
+	}
+
+	// and shed under pressure like tunnels
+	rl = New(tt, contractmanager.NewDummyManager(), Options{BufSize: 2048, Admission: admission.Options{MaxHeap: 1}})
+	if res := forward(rl, context.Background()); res.Code != http.StatusBadGateway || !status.IsRetryable(&res) {
+		t.Fatalf("forwarded connection should be shed, got %+v", res)
+	}
+}
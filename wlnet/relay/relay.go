@@ -5,6 +5,7 @@ package relay
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -16,23 +17,49 @@ import (
 	"github.com/wireleap/common/api/sharetoken"
 	"github.com/wireleap/common/api/status"
 	"github.com/wireleap/common/wlnet"
-	"github.com/wireleap/common/wlnet/flushwriter"
-	"github.com/wireleap/common/wlnet/h2rwc"
 	"github.com/wireleap/common/wlnet/transport"
-	"github.com/wireleap/relay/api/meteredrwc"
+	"github.com/wireleap/relay/api/admission"
+	"github.com/wireleap/relay/api/blocklist"
+	"github.com/wireleap/relay/api/bufpool"
+	"github.com/wireleap/relay/api/fairqueue"
+	"github.com/wireleap/relay/api/h2ping"
+	"github.com/wireleap/relay/api/h2pool"
 	"github.com/wireleap/relay/api/meteredrwc/mrwclabels"
+	"github.com/wireleap/relay/api/ratelimit"
+	"github.com/wireleap/relay/api/resolver"
+	"github.com/wireleap/relay/api/tlspolicy"
 	"github.com/wireleap/relay/contractmanager"
+
+	"golang.org/x/net/http2"
 )
 
 type T struct {
 	*transport.T
 	Options
 	*contractmanager.Manager
+
+	pool *bufpool.T
+	// pooled connections to the next relays, if enabled
+	nextHops *h2pool.T
+	// keepalive statistics by peer type
+	peers *h2ping.Recorder
+	// admission control of new tunnels, if enabled
+	admission *admission.T
+	// bandwidth scheduler of the tunnels, if enabled
+	fairQueue *fairqueue.T
+	// resolver of the target hostnames
+	resolver *resolver.T
+	// listener servers, set by ListenAndServeHTTP
+	srv *http.Server
+	h2  *http2.Server
 }
 
 type Options struct {
 	// BufSize is the size in bytes of the send/receive buffers of a relay.
 	BufSize int
+	// FlushDelay is the maximum time data is held before being flushed
+	// downstream, 0 flushes after every write.
+	FlushDelay time.Duration
 	// MaxTime is the maximum time for a single connection.
 	MaxTime time.Duration
 	// HandleST is a generic function which is called on incoming sharetokens.
@@ -49,10 +76,70 @@ type Options struct {
 	// ContractCap sets the ammount of traffic that can be forwarded during
 	// a given period, by contract
 	ContractCap map[string]uint64
+	// Server configures the HTTP/2 server.
+	Server ServerOptions
+	// NextHops configures the pooled connections to the next relays.
+	NextHops NextHopOptions
+	// Admission sets the thresholds above which new tunnels are shed.
+	Admission admission.Options
+	// FairQueue shares the bandwidth between contracts and clients while
+	// the uplink is saturated, it is disabled if FairQueue.Interval is 0.
+	FairQueue fairqueue.Options
+	// Resolver configures the resolution of the target hostnames, the
+	// resolved loopback addresses are not dialed unless AllowLoopback is
+	// set.
+	Resolver resolver.Options
+	// Blocklist refuses the target hostnames it matches, it can be nil.
+	Blocklist *blocklist.T
+}
+
+// ServerOptions are the HTTP/2 server settings, zero values use the
+// net/http and x/net/http2 defaults.
+type ServerOptions struct {
+	// MaxConcurrentStreams limits the streams per client connection.
+	MaxConcurrentStreams uint32
+	// ConnectionWindow is the flow-control window of a client connection.
+	ConnectionWindow int32
+	// StreamWindow is the flow-control window of a stream.
+	StreamWindow int32
+	// ReadHeaderTimeout is the time allowed to read the request headers.
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout is the time allowed to answer a request.
+	WriteTimeout time.Duration
+	// IdleTimeout closes client connections without streams.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of the request headers.
+	MaxHeaderBytes int
+	// PingInterval is the interval of the keepalive pings sent to the
+	// clients and previous relays, 0 disables them.
+	PingInterval time.Duration
+	// PingTimeout closes the connections not answering a keepalive ping.
+	PingTimeout time.Duration
+	// TLS is the listener policy, applied to a copy of the transport
+	// settings so that outgoing dials are not affected.
+	TLS tlspolicy.Server
 }
 
 func New(tt *transport.T, m *contractmanager.Manager, o Options) *T {
-	return &T{T: tt, Options: o, Manager: m}
+	t := &T{T: tt, Options: o, Manager: m, pool: bufpool.New(o.BufSize), peers: h2ping.NewRecorder()}
+	if tt != nil && o.NextHops.Size > 0 {
+		t.nextHops = t.newPool(o.NextHops)
+	}
+	if o.Admission.Enabled() {
+		t.admission = admission.New(o.Admission)
+	}
+	if o.FairQueue.Enabled() {
+		if o.FairQueue.Quantum == 0 {
+			o.FairQueue.Quantum = o.BufSize
+		}
+		t.fairQueue = fairqueue.New(o.FairQueue)
+	}
+	if !o.AllowLoopback {
+		// no dials to this relay's host through a hostname either
+		o.Resolver.Allow = func(ip net.IP) bool { return !isLoopbackIP(ip) }
+	}
+	t.resolver = resolver.New(o.Resolver)
+	return t
 }
 
 // isLoopback determines whether the presented address is a loopback interface
@@ -66,6 +153,10 @@ func isLoopback(addr string) bool {
 		// probably a fqdn
 		return false
 	}
+	return isLoopbackIP(ip)
+}
+
+func isLoopbackIP(ip net.IP) bool {
 	// unspecified ips (0.0.0.0/::) can be used to access loopback too
 	return ip.IsLoopback() || ip.IsUnspecified()
 }
@@ -83,10 +174,7 @@ func (t *T) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h := w.Header()
 	h.Set("Trailer", "wl-status")
 
-	var c io.ReadWriteCloser = h2rwc.T{
-		Writer:     flushwriter.T{Writer: w},
-		ReadCloser: r.Body,
-	}
+	c := t.newStream(w, r.Body)
 
 	var ctlabs mrwclabels.ContractLabels
 	defer c.Close()
@@ -104,6 +192,10 @@ func (t *T) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if p.Command == "PING" {
+		if t.srv != nil {
+			h.Set(FeaturesHeader, featureForward)
+		}
+
 		// raw, not in wlnet wire format
 		(&status.T{
 			Code:   http.StatusOK,
@@ -113,6 +205,19 @@ func (t *T) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = t.admission.Admit(); err != nil {
+		// retryable, clients fail over to another relay
+		st := status.ErrGateway.Wrap(err)
+		st.Origin = origin
+		st.ToHeader(h)
+		return
+	}
+
+	if p.Command == cmdForward {
+		t.serveForward(c, h, r)
+		return
+	}
+
 	contractId := p.Token.Contract.PublicKey.String()
 	ctlabs = ctlabs.SetContract(contractId)
 
@@ -164,6 +269,18 @@ func (t *T) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// no dials to blocked domains, the target is not logged
+	if p.Remote.Scheme == "target" {
+		if _, ok := t.Blocklist.Match(p.Remote.Hostname()); ok {
+			(&status.T{
+				Code:   http.StatusForbidden,
+				Desc:   "target domain blocked",
+				Origin: origin,
+			}).ToHeader(h)
+			return
+		}
+	}
+
 	// hide requested target for privacy
 	shown := "(target)"
 
@@ -173,29 +290,20 @@ func (t *T) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("Dialing %s connection to %s", p.Protocol, shown)
-	c2, err := t.T.Transport.DialContext(ctx, p.Protocol, p.Remote.Host)
+	c2, err := t.dial(ctx, p)
 
 	if err != nil {
-		// TODO more granular errors
-
-		if os.IsTimeout(err) {
-			(&status.T{
-				Code:   http.StatusRequestTimeout,
-				Desc:   err.Error(),
-				Origin: origin,
-			}).ToHeader(h)
-		} else {
-			(&status.T{
-				Code:   http.StatusBadGateway,
-				Desc:   err.Error(),
-				Origin: origin,
-			}).ToHeader(h)
-		}
-
+		dialStatus(err, origin).ToHeader(h)
 		return
 	}
 
-	err = t.meteredSplice(ctx, c, c2, ctlabs)
+	err = t.meteredSplice(ctx, c, c2, ctlabs, p.Token.PublicKey.String())
+
+	if peer := t.deadPeer(r.Context(), c2); peer != "" {
+		log.Printf("%s connection to %s ended: %s peer dead", p.Protocol, shown, peer)
+		t.peers.DeadTunnel(peer)
+		err = errPeerDead
+	}
 
 	if err != nil {
 		// TODO more granular errors
@@ -216,40 +324,171 @@ func (t *T) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (t *T) monitorRWC(cIn, cOut io.ReadWriteCloser, ctId string) (io.ReadWriteCloser, io.ReadWriteCloser, func() error) {
-	// To extend if other metrics need to be recorded
-	syncCounter := t.Manager.NetStats.Active.ContractStats.GetOrInit(ctId)
-	in, out := syncCounter.Inner() // Get inner counters
-	return meteredrwc.New(cIn, in), meteredrwc.New(cOut, out), syncCounter.Close
+// Returns the status of a failed dial, telling resolution errors apart
+func dialStatus(err error, origin string) *status.T {
+	st := &status.T{Code: http.StatusBadGateway, Desc: err.Error(), Origin: origin}
+
+	switch {
+	case errors.Is(err, resolver.ErrNotFound):
+		st.Code = http.StatusNotFound
+	case errors.Is(err, resolver.ErrForbidden):
+		st.Code = http.StatusForbidden
+	case errors.Is(err, resolver.ErrTimeout):
+		st.Code = http.StatusGatewayTimeout
+	case errors.Is(err, resolver.ErrServer):
+		st.Code = http.StatusServiceUnavailable
+	case os.IsTimeout(err):
+		st.Code = http.StatusRequestTimeout
+	}
+	return st
 }
 
-func (t *T) meteredSplice(ctx context.Context, cIn, cOut io.ReadWriteCloser, ctlabs mrwclabels.ContractLabels) error {
-	var closeFn func() error
-	if t.Manager.NetStats.Enabled() {
-		cIn, cOut, closeFn = t.monitorRWC(cIn, cOut, ctlabs.Contract)
+func (t *T) meteredSplice(ctx context.Context, cIn, cOut io.ReadWriteCloser, ctlabs mrwclabels.ContractLabels, client string) error {
+	// bytes are counted while copied rather than through wrapping RWCs
+	var in, out *uint64
+	if t.Manager.Metered() {
+		syncCounter := t.Manager.ContractStats().GetOrInit(ctlabs.Contract)
+		in, out = syncCounter.Inner() // Get inner counters
+
+		defer func() {
+			if err := syncCounter.Close(); err != nil {
+				log.Printf("error happened when closing synccounter %s\n", err.Error())
+			}
+		}()
 	}
 
-	defer func() {
-		if err := closeFn(); err != nil {
-			log.Printf("error happened when closing synccounter %s\n", err.Error())
-		}
-	}()
+	if ls := t.Manager.Limiters(ctlabs.Contract); len(ls) > 0 {
+		// Throttle both directions while a throttling threshold is reached
+		cIn, cOut = ratelimit.NewRWC(ctx, cIn, ls...), ratelimit.NewRWC(ctx, cOut, ls...)
+	}
+
+	if t.fairQueue != nil {
+		// Share the bandwidth by contract, then by sharetoken client
+		cIn = t.fairQueue.NewRWC(ctx, cIn, ctlabs.Contract, client)
+		cOut = t.fairQueue.NewRWC(ctx, cOut, ctlabs.Contract, client)
+	}
 
-	return wlnet.Splice(ctx, cIn, cOut, t.MaxTime, t.BufSize)
+	return t.splice(ctx, cIn, cOut, in, out)
 }
 
 // ListenAndServeHTTP listens on the specified address and passes the
 // connections to ServeHTTP.
 func (t *T) ListenAndServeHTTP(addr string) error {
-	l, err := tls.Listen("tcp", addr, t.Transport.TLSClientConfig)
+	o := t.Server
+	tc := t.Transport.TLSClientConfig.Clone()
+	stop, err := o.TLS.Apply(tc)
 	if err != nil {
 		return err
 	}
-	s := http.Server{
-		Addr:      addr,
-		Handler:   t,
-		TLSConfig: t.Transport.TLSClientConfig,
+
+	l, err := tls.Listen("tcp", addr, tc)
+	if err != nil {
+		stop()
+		return err
+	}
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           t,
+		TLSConfig:         tc,
+		ReadHeaderTimeout: o.ReadHeaderTimeout,
+		WriteTimeout:      o.WriteTimeout,
+		IdleTimeout:       o.IdleTimeout,
+		MaxHeaderBytes:    o.MaxHeaderBytes,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, acceptedKey{}, time.Now())
+		},
+	}
+
+	h2 := &http2.Server{
+		MaxConcurrentStreams:         o.MaxConcurrentStreams,
+		MaxUploadBufferPerConnection: o.ConnectionWindow,
+		MaxUploadBufferPerStream:     o.StreamWindow,
+		IdleTimeout:                  o.IdleTimeout,
+	}
+	if err = http2.ConfigureServer(s, h2); err != nil {
+		stop()
+		l.Close()
+		return err
 	}
+	// as set by http2.ConfigureServer, with keepalive pings
+	s.TLSNextProto[http2.NextProtoTLS] = func(hs *http.Server, c *tls.Conn, h http.Handler) {
+		ctx := context.Background()
+		if bc, ok := h.(interface{ BaseContext() context.Context }); ok {
+			ctx = bc.BaseContext()
+		}
+		if at, ok := ctx.Value(acceptedKey{}).(time.Time); ok {
+			t.admission.Accepted(time.Since(at))
+		}
+		t.serveConn(ctx, c, h2ping.PeerInbound, h)
+	}
+
+	t.srv, t.h2 = s, h2
+
 	go s.Serve(l)
 	return nil
 }
+
+// Context key of the time a connection was accepted
+type acceptedKey struct{}
+
+// Serves an HTTP/2 connection, sending keepalive pings to the peer
+func (t *T) serveConn(ctx context.Context, c *tls.Conn, peer string, h http.Handler) {
+	pc := h2ping.New(c, peer, h2ping.Options{
+		Interval: t.Server.PingInterval,
+		Timeout:  t.Server.PingTimeout,
+		Recorder: t.peers,
+	})
+	defer pc.Close()
+
+	t.h2.ServeConn(pc, &http2.ServeConnOpts{
+		Context:    h2ping.NewContext(ctx, pc),
+		BaseConfig: t.srv,
+		Handler:    h,
+	})
+}
+
+var errPeerDead = errors.New("peer dead")
+
+// Returns the type of the peer of a tunnel found dead by keepalive pings,
+// if any
+func (t *T) deadPeer(ctx context.Context, c net.Conn) string {
+	if pc := h2ping.FromContext(ctx); pc != nil && pc.Dead() {
+		return pc.Peer()
+	}
+	if pc, ok := c.(*pooledConn); ok && t.nextHops.Dead(pc.cc) {
+		return h2ping.PeerNextHop
+	}
+	return ""
+}
+
+// Admission returns the admission control statistics, if enabled
+func (t *T) Admission() (admission.Stats, bool) {
+	if t.admission == nil {
+		return admission.Stats{}, false
+	}
+	return t.admission.Stats(), true
+}
+
+// SetFairQueueWeights sets the bandwidth shares of the contracts under
+// congestion, by contract id.
+func (t *T) SetFairQueueWeights(weights map[string]int) {
+	t.fairQueue.SetWeights(weights)
+}
+
+// FairQueue returns the bandwidth scheduler statistics, if enabled
+func (t *T) FairQueue() (fairqueue.Stats, bool) {
+	if t.fairQueue == nil {
+		return fairqueue.Stats{}, false
+	}
+	return t.fairQueue.Stats(), true
+}
+
+// Resolver returns the target hostnames resolver statistics
+func (t *T) Resolver() resolver.Stats {
+	return t.resolver.Stats()
+}
+
+// Peers returns the keepalive statistics by peer type
+func (t *T) Peers() map[string]h2ping.PeerStats {
+	return t.peers.Stats()
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Wireleap
+
+package relay
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wireleap/common/wlnet"
+	"github.com/wireleap/common/wlnet/flushwriter"
+	"github.com/wireleap/common/wlnet/h2rwc"
+	"github.com/wireleap/relay/api/meteredrwc"
+	"github.com/wireleap/relay/api/synccounters"
+)
+
+const (
+	benchBufSize = 4096
+	// data sent upstream and downstream by every benchmarked tunnel
+	benchPayload = 256 << 10
+)
+
+// flushConn emulates an h2 response, flushes are no-ops
+type flushConn struct{ net.Conn }
+
+func (flushConn) Flush() {}
+
+var _ http.Flusher = flushConn{}
+
+// Runs a tunnel per iteration, the client and the target both send the
+// payload and read until EOF
+func benchTunnel(b *testing.B, tunnel func(w flushConn, body io.ReadCloser, target net.Conn, ctc *synccounters.ContractCounter) error) {
+	payload := make([]byte, benchPayload)
+	ctc := synccounters.NewContractCounter()
+
+	b.SetBytes(2 * benchPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		client, down := net.Pipe()
+		up, target := net.Pipe()
+
+		done := make(chan struct{}, 2)
+		peer := func(c net.Conn) {
+			go c.Write(payload)
+			io.Copy(ioutil.Discard, c)
+			done <- struct{}{}
+		}
+		go peer(client)
+		go peer(target)
+
+		go func() {
+			// end the tunnel once both directions are through
+			for ctc.Sum() < uint64((i+1)*2*benchPayload) {
+				time.Sleep(100 * time.Microsecond)
+			}
+			client.Close()
+		}()
+
+		if err := tunnel(flushConn{down}, down, up, ctc); err != nil {
+			b.Fatal(err)
+		}
+		<-done
+		<-done
+	}
+}
+
+// BenchmarkTunnelBefore is the previous hot path: wrapping RWCs flushing on
+// every write and a buffer allocated per direction
+func BenchmarkTunnelBefore(b *testing.B) {
+	benchTunnel(b, func(w flushConn, body io.ReadCloser, target net.Conn, ctc *synccounters.ContractCounter) error {
+		var c io.ReadWriteCloser = h2rwc.T{
+			Writer:     flushwriter.T{Writer: w},
+			ReadCloser: body,
+		}
+
+		cc := ctc.NewChild()
+		defer cc.Close()
+
+		in, out := cc.Inner()
+		return wlnet.Splice(context.Background(), meteredrwc.New(c, in), meteredrwc.New(target, out), 0, benchBufSize)
+	})
+}
+
+// BenchmarkTunnelAfter is the current hot path: pooled buffers, batched
+// flushes and bytes counted while copied
+func BenchmarkTunnelAfter(b *testing.B) {
+	rl := New(nil, nil, Options{BufSize: benchBufSize, FlushDelay: 5 * time.Millisecond})
+	benchTunnel(b, func(w flushConn, body io.ReadCloser, target net.Conn, ctc *synccounters.ContractCounter) error {
+		c := rl.newStream(w, body)
+
+		cc := ctc.NewChild()
+		defer cc.Close()
+
+		in, out := cc.Inner()
+		return rl.splice(context.Background(), c, target, in, out)
+	})
+}
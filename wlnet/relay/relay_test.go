@@ -4,11 +4,17 @@ package relay
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,9 +22,15 @@ import (
 	"github.com/wireleap/common/api/servicekey"
 	"github.com/wireleap/common/api/sharetoken"
 	"github.com/wireleap/common/api/signer"
+	"github.com/wireleap/common/api/status"
 	"github.com/wireleap/common/api/texturl"
 	"github.com/wireleap/common/wlnet"
 	"github.com/wireleap/common/wlnet/transport"
+	"github.com/wireleap/relay/api/admission"
+	"github.com/wireleap/relay/api/blocklist"
+	"github.com/wireleap/relay/api/fairqueue"
+	"github.com/wireleap/relay/api/h2ping"
+	"github.com/wireleap/relay/api/resolver"
 	"github.com/wireleap/relay/contractmanager"
 )
 
@@ -118,3 +130,196 @@ func TestWLRelay(t *testing.T) {
 		t.Fatal("wireleap-relay received corrupted message", p0, p2[:n])
 	}
 }
+
+// frozenConn drops its writes once frozen, as a vanished peer would
+type frozenConn struct {
+	net.Conn
+	frozen int32
+}
+
+func (c *frozenConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.frozen) == 1 {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func TestDeadPeer(t *testing.T) {
+	st, target := newToken(t), newEcho(t)
+	addr := freeAddr(t)
+
+	tt := transport.New(transport.Options{Certs: []tls.Certificate{newCert(t)}, Timeout: 5 * time.Second})
+	rl := New(tt, contractmanager.NewDummyManager(), Options{
+		BufSize:       2048,
+		AllowLoopback: true,
+		Server:        ServerOptions{PingInterval: 20 * time.Millisecond, PingTimeout: 100 * time.Millisecond},
+	})
+	if err := rl.ListenAndServeHTTP(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	fc := &frozenConn{}
+	client := transport.New(transport.Options{Timeout: 5 * time.Second})
+	client.Transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		fc.Conn = c
+		tc := tls.Client(fc, client.Transport.TLSClientConfig)
+		return tc, tc.Handshake()
+	}
+
+	u := texturl.URLMustParse("wireleap://" + addr)
+	c, err := client.DialWL(nil, "tcp", &u.URL, newInit(st, "target://"+target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	echo(t, c, []byte("hello!"))
+	atomic.StoreInt32(&fc.frozen, 1)
+
+	for i := 0; i < 200; i++ {
+		if rl.Peers()[h2ping.PeerInbound].DeadTunnels > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if s := rl.Peers()[h2ping.PeerInbound]; s.Dead != 1 || s.DeadTunnels != 1 {
+		t.Fatalf("tunnel of the dead peer should be reported %+v", s)
+	}
+}
+
+func TestShedding(t *testing.T) {
+	st, target := newToken(t), newEcho(t)
+	addr := freeAddr(t)
+
+	tt := transport.New(transport.Options{Certs: []tls.Certificate{newCert(t)}, Timeout: 5 * time.Second})
+	rl := New(tt, contractmanager.NewDummyManager(), Options{
+		BufSize:       2048,
+		AllowLoopback: true,
+		Admission:     admission.Options{MaxHeap: 1},
+	})
+	if err := rl.ListenAndServeHTTP(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	client := transport.New(transport.Options{Timeout: 5 * time.Second})
+	u := texturl.URLMustParse("wireleap://" + addr)
+
+	c, err := client.DialWL(nil, "tcp", &u.URL, newInit(st, "target://"+target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the status is reported in the trailer
+	c.Write([]byte("hello!"))
+	if _, err = ioutil.ReadAll(c); err == nil {
+		t.Fatal("tunnel should be shed")
+	} else if !status.IsRetryable(err) {
+		t.Fatalf("shed tunnel should be retryable: %s", err)
+	}
+
+	if s, ok := rl.Admission(); !ok || s.Shed[admission.ReasonHeap] != 1 {
+		t.Fatalf("shed tunnel should be counted %+v", s)
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	st, target := newToken(t), newEcho(t)
+	addr := freeAddr(t)
+
+	tt := transport.New(transport.Options{Certs: []tls.Certificate{newCert(t)}, Timeout: 5 * time.Second})
+	rl := New(tt, contractmanager.NewDummyManager(), Options{
+		BufSize:       2048,
+		AllowLoopback: true,
+		FairQueue:     fairqueue.Options{Interval: 10 * time.Millisecond},
+	})
+	if err := rl.ListenAndServeHTTP(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	client := transport.New(transport.Options{Timeout: 5 * time.Second})
+	u := texturl.URLMustParse("wireleap://" + addr)
+
+	c, err := client.DialWL(nil, "tcp", &u.URL, newInit(st, "target://"+target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// a single client does not saturate the uplink
+	echo(t, c, bytes.Repeat([]byte("0123456789abcdef"), 4096))
+
+	if s, ok := rl.FairQueue(); !ok || s.Congested || s.Waits != 0 {
+		t.Fatalf("uncongested tunnel should not be scheduled %+v", s)
+	}
+}
+
+func TestResolvedLoopback(t *testing.T) {
+	st, target := newToken(t), newEcho(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	rl := New(transport.New(transport.Options{}), contractmanager.NewDummyManager(), Options{BufSize: 2048})
+
+	// resolves to a loopback address past the hostname check
+	r := httptest.NewRequest(http.MethodPut, "/", http.NoBody)
+	for k, v := range newInit(st, "target://LOCALHOST:"+port).Headers() {
+		r.Header.Set(k, v)
+	}
+	rw := httptest.NewRecorder()
+	rl.ServeHTTP(rw, r)
+
+	var res status.T
+	if err := json.Unmarshal([]byte(rw.Header().Get(status.Header)), &res); err != nil {
+		t.Fatal(err)
+	} else if res.Code != http.StatusForbidden || res.Origin != "target" {
+		t.Fatalf("expected a forbidden target status, got %+v", res)
+	}
+
+	if s := rl.Resolver(); s.Errors[resolver.KindForbidden] != 1 {
+		t.Fatalf("refused dial should be counted %+v", s)
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	st := newToken(t)
+
+	p := filepath.Join(t.TempDir(), "malware.txt")
+	if err := ioutil.WriteFile(p, []byte(".malware.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bl, err := blocklist.New(map[string]string{"malware": p}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rl := New(transport.New(transport.Options{}), contractmanager.NewDummyManager(), Options{
+		BufSize:   2048,
+		Blocklist: bl,
+	})
+
+	r := httptest.NewRequest(http.MethodPut, "/", http.NoBody)
+	for k, v := range newInit(st, "target://cdn.malware.example.com:443").Headers() {
+		r.Header.Set(k, v)
+	}
+	rw := httptest.NewRecorder()
+	rl.ServeHTTP(rw, r)
+
+	var res status.T
+	if err := json.Unmarshal([]byte(rw.Header().Get(status.Header)), &res); err != nil {
+		t.Fatal(err)
+	} else if res.Code != http.StatusForbidden || res.Origin != "target" {
+		t.Fatalf("expected a forbidden target status, got %+v", res)
+	}
+
+	if s := bl.Stats(); len(s) != 1 || s[0].Blocked != 1 {
+		t.Fatalf("blocked dial should be counted %+v", s)
+	}
+	if s := rl.Resolver(); s.Queries != 0 {
+		t.Fatal("blocked target should not be resolved")
+	}
+}
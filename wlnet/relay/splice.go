@@ -0,0 +1,122 @@
+// Copyright (c) 2022 Wireleap
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/wireleap/common/api/status"
+	"github.com/wireleap/relay/api/batchwriter"
+)
+
+// stream is the downstream side of a tunnel, an h2 request body and the
+// response it is answered with
+type stream struct {
+	*batchwriter.T
+	body io.ReadCloser
+}
+
+func (t *T) newStream(w io.Writer, body io.ReadCloser) *stream {
+	return &stream{
+		T:    batchwriter.New(w, t.BufSize, t.FlushDelay),
+		body: body,
+	}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *stream) Close() error {
+	s.T.Close()
+	return s.body.Close()
+}
+
+// Copies src to dst with buf, adding the bytes read to n if set. Unlike
+// io.CopyBuffer, it never bypasses buf through io.ReaderFrom which would
+// allocate its own buffer when src is not a file or socket.
+func copyBuffer(dst io.Writer, src io.Reader, buf []byte, n *uint64) error {
+	for {
+		nr, err := src.Read(buf)
+		if nr > 0 {
+			if n != nil {
+				atomic.AddUint64(n, uint64(nr))
+			}
+
+			nw, errW := dst.Write(buf[:nr])
+			if errW != nil {
+				return errW
+			} else if nw != nr {
+				return io.ErrShortWrite
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Copies src to dst with a pooled buffer and reports the error to ec
+func (t *T) retransmit(src io.Reader, dst io.Writer, n *uint64, ec chan<- error) {
+	buf := t.pool.Get()
+	ec <- copyBuffer(dst, src, *buf, n)
+	t.pool.Put(buf)
+}
+
+// splice is wlnet.Splice with pooled buffers, the bytes read from src and
+// dst are added to nsrc and ndst if set
+func (t *T) splice(ctx context.Context, src, dst io.ReadWriteCloser, nsrc, ndst *uint64) (err error) {
+	if t.MaxTime != 0 {
+		dl := time.Now().Add(t.MaxTime)
+
+		for _, c := range []io.ReadWriteCloser{src, dst} {
+			c := c
+			if nc, ok := c.(net.Conn); ok {
+				nc.SetDeadline(dl)
+			} else {
+				tm := time.AfterFunc(t.MaxTime, func() { c.Close() })
+				defer tm.Stop()
+			}
+		}
+	}
+
+	ec := make(chan error, 2)
+
+	go t.retransmit(src, dst, nsrc, ec)
+	go t.retransmit(dst, src, ndst, ec)
+
+	cancelled := false
+	select {
+	// Regular flow
+	case err = <-ec:
+		st := &status.T{}
+		if err != nil && errors.As(err, &st) {
+			log.Printf("splice error: %s", err)
+		}
+	// Cancel flow
+	case <-ctx.Done():
+		err = nil
+		cancelled = true
+	}
+
+	// interrupt the connection on errors or EOFs from either side
+	// this is strict but does not let connections linger
+	dst.Close()
+	src.Close()
+
+	// wait for stream termination
+	<-ec
+	if cancelled {
+		<-ec
+	}
+	return
+}
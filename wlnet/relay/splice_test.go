@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Wireleap
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wireleap/relay/api/bufpool"
+)
+
+func TestSplice(t *testing.T) {
+	rl := &T{Options: Options{BufSize: 16}, pool: bufpool.New(16)}
+
+	src, src2 := net.Pipe()
+	dst, dst2 := net.Pipe()
+
+	var nsrc, ndst uint64
+	ec := make(chan error)
+	go func() { ec <- rl.splice(context.Background(), src2, dst, &nsrc, &ndst) }()
+
+	p := bytes.Repeat([]byte("wireleap"), 10)
+	go func() {
+		src.Write(p)
+		src.Close()
+	}()
+
+	data, _ := ioutil.ReadAll(dst2)
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, p) {
+		t.Fatalf("corrupted data %q", data)
+	} else if atomic.LoadUint64(&nsrc) != uint64(len(p)) || atomic.LoadUint64(&ndst) != 0 {
+		t.Fatalf("wrong counters %d %d", nsrc, ndst)
+	}
+}
+
+func TestSpliceCancel(t *testing.T) {
+	rl := &T{Options: Options{BufSize: 16, MaxTime: time.Minute}, pool: bufpool.New(16)}
+
+	_, src := net.Pipe()
+	dst, _ := net.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ec := make(chan error)
+	go func() { ec <- rl.splice(ctx, src, dst, nil, nil) }()
+	cancel()
+
+	select {
+	case err := <-ec:
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err = src.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+			t.Fatalf("connections should be closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("splice should be cancelled")
+	}
+}